@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"marketprogo/config"
 	"marketprogo/internal/handlers"
+	"marketprogo/internal/jobs"
 	"marketprogo/internal/middleware"
+	"marketprogo/internal/services"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,6 +27,20 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	services.Configure(cfg)
+
+	// Start background jobs
+	if cfg.JobsEnabled {
+		jobs.Start(context.Background(), database.GetDB(),
+			jobs.Job{Name: "scheduled-prices", Interval: time.Minute, Run: services.ApplyScheduledPriceChanges},
+			jobs.Job{Name: "frequently-bought-together", Interval: time.Hour, Run: services.MineFrequentlyBoughtTogether},
+			jobs.Job{Name: "expire-batches", Interval: 24 * time.Hour, Run: services.ExpireBatches},
+			jobs.Job{Name: "cancel-unpaid-orders", Interval: 5 * time.Minute, Run: services.CancelUnpaidOrders},
+			jobs.Job{Name: "orphaned-reservations", Interval: time.Hour, Run: services.FixOrphanedReservations},
+			jobs.Job{Name: "stock-levels", Interval: time.Hour, Run: services.CheckStockLevels},
+		)
+	}
+
 	// Create Gin router
 	router := gin.Default()
 
@@ -30,6 +48,7 @@ func main() {
 	router.Use(middleware.CORS())
 	router.Use(middleware.Logger())
 	router.Use(middleware.Recovery())
+	router.Use(middleware.Locale(cfg.SupportedLocales, cfg.DefaultLocale))
 
 	// API routes
 	api := router.Group("/api")
@@ -46,6 +65,24 @@ func main() {
 		products := api.Group("/products")
 		{
 			products.GET("", handlers.GetProducts)
+			products.GET("/availability", handlers.GetProductsAvailability)
+			products.GET("/:id/reviews", handlers.GetProductReviews)
+			products.GET("/:id/recommendations", handlers.GetProductRecommendations)
+		}
+
+		// Cart routes, for anonymous and logged-in customers
+		cart := api.Group("/cart")
+		cart.Use(middleware.OptionalAuth())
+		{
+			cart.GET("", handlers.GetCart)
+			cart.PUT("", handlers.UpdateCart)
+			cart.POST("/items", handlers.AddCartItem)
+			cart.PUT("/items/:productId", handlers.UpdateCartItem)
+			cart.DELETE("/items/:productId", handlers.RemoveCartItem)
+			cart.POST("/coupons", handlers.AddCartCoupon)
+			cart.DELETE("/coupons/:code", handlers.RemoveCartCoupon)
+			cart.POST("/merge", handlers.MergeCart)
+			cart.POST("/checkout", handlers.CheckoutCart)
 		}
 
 		// Protected routes
@@ -62,10 +99,138 @@ func main() {
 			// Product routes
 			products := protected.Group("/products")
 			{
+				products.GET("/by-barcode/:code", handlers.GetProductByBarcode)
 				products.GET("/:id", handlers.GetProduct)
 				products.POST("", handlers.CreateProduct)
 				products.PUT("/:id", handlers.UpdateProduct)
 				products.DELETE("/:id", handlers.DeleteProduct)
+
+				// Barcodes and labels
+				products.GET("/:id/qrcode", handlers.GetProductQRCode)
+				products.GET("/:id/barcode", handlers.GetProductBarcode)
+				products.POST("/labels", handlers.CreateLabelSheet)
+
+				// Specifications
+				products.GET("/:id/specifications", handlers.GetProductSpecifications)
+				products.POST("/:id/specifications", handlers.CreateProductSpecification)
+				products.PUT("/:id/specifications/:specId", handlers.UpdateProductSpecification)
+				products.DELETE("/:id/specifications/:specId", handlers.DeleteProductSpecification)
+
+				// Pricing
+				products.GET("/price-at", handlers.GetPriceAt)
+				products.GET("/:id/price-history", handlers.GetPriceHistory)
+				products.GET("/:id/scheduled-prices", handlers.GetScheduledPriceChanges)
+				products.POST("/:id/scheduled-prices", handlers.CreateScheduledPriceChange)
+				products.DELETE("/:id/scheduled-prices/:scheduleId", handlers.CancelScheduledPriceChange)
+
+				// Reviews
+				products.POST("/:id/reviews", handlers.CreateProductReview)
+
+				// Currency price overrides
+				products.GET("/:id/prices", handlers.GetProductPrices)
+				products.PUT("/:id/prices/:currency", handlers.SetProductPrice)
+				products.DELETE("/:id/prices/:currency", handlers.DeleteProductPrice)
+
+				// Translations
+				products.GET("/:id/translations", handlers.GetProductTranslations)
+				products.PUT("/:id/translations/:locale", handlers.SaveProductTranslation)
+				products.DELETE("/:id/translations/:locale", handlers.DeleteProductTranslation)
+				products.PUT("/:id/specifications/:specId/translations/:locale", handlers.SaveSpecificationTranslation)
+				products.DELETE("/:id/specifications/:specId/translations/:locale", handlers.DeleteSpecificationTranslation)
+
+				// Bundles
+				products.GET("/:id/bundle", handlers.GetProductBundle)
+				products.PUT("/:id/bundle", handlers.SetProductBundle)
+				products.DELETE("/:id/bundle", handlers.DeleteProductBundle)
+				products.GET("/:id/availability", handlers.GetProductAvailability)
+
+				// Relations
+				products.GET("/:id/relations", handlers.GetProductRelations)
+				products.POST("/:id/relations", handlers.CreateProductRelation)
+				products.DELETE("/:id/relations/:relationId", handlers.DeleteProductRelation)
+			}
+
+			// Warehouse routes
+			warehouses := protected.Group("/warehouses")
+			{
+				warehouses.GET("", handlers.GetWarehouses)
+				warehouses.GET("/:id", handlers.GetWarehouse)
+				warehouses.POST("", handlers.CreateWarehouse)
+				warehouses.PUT("/:id", handlers.UpdateWarehouse)
+				warehouses.DELETE("/:id", handlers.DeleteWarehouse)
+				warehouses.GET("/:id/stock", handlers.GetWarehouseStock)
+			}
+
+			// Inventory routes
+			inventory := protected.Group("/inventory")
+			{
+				inventory.GET("/reasons", handlers.GetAdjustmentReasons)
+				inventory.GET("/movements", handlers.GetInventoryMovements)
+				inventory.GET("/near-expiry", handlers.GetNearExpiryStock)
+				inventory.GET("/:id", handlers.GetInventoryItem)
+				inventory.POST("", handlers.CreateInventoryItem)
+				inventory.PUT("/:id", handlers.UpdateInventoryItem)
+				inventory.GET("/:id/adjustments", handlers.GetInventoryAdjustments)
+				inventory.POST("/:id/adjustments", handlers.AdjustInventoryItem)
+				inventory.GET("/:id/ledger", handlers.GetInventoryItemLedger)
+			}
+
+			// Replenishment routes
+			reorderRules := protected.Group("/reorder-rules")
+			{
+				reorderRules.GET("", handlers.GetReorderRules)
+				reorderRules.POST("", handlers.CreateReorderRule)
+				reorderRules.PUT("/:id", handlers.UpdateReorderRule)
+				reorderRules.DELETE("/:id", handlers.DeleteReorderRule)
+			}
+			stockAlerts := protected.Group("/stock-alerts")
+			{
+				stockAlerts.GET("", handlers.GetStockAlerts)
+				stockAlerts.POST("/:id/acknowledge", handlers.AcknowledgeStockAlert)
+			}
+
+			// Stock transfer routes
+			transfers := protected.Group("/transfers")
+			{
+				transfers.GET("", handlers.GetTransfers)
+				transfers.GET("/in-transit", handlers.GetInTransitStock)
+				transfers.GET("/:id", handlers.GetTransfer)
+				transfers.POST("", handlers.CreateTransfer)
+				transfers.POST("/:id/dispatch", handlers.DispatchTransfer)
+				transfers.POST("/:id/receive", handlers.ReceiveTransfer)
+				transfers.POST("/:id/cancel", handlers.CancelTransfer)
+				transfers.POST("/:id/documents", handlers.AddTransferDocument)
+			}
+
+			// Stocktake routes
+			stockCounts := protected.Group("/stock-counts")
+			{
+				stockCounts.GET("", handlers.GetStockCounts)
+				stockCounts.GET("/impact", handlers.GetStockCountImpact)
+				stockCounts.GET("/:id", handlers.GetStockCount)
+				stockCounts.GET("/:id/variance", handlers.GetStockCountVariance)
+				stockCounts.POST("", handlers.CreateStockCount)
+				stockCounts.POST("/:id/counts", handlers.RecordStockCounts)
+				stockCounts.POST("/:id/submit", handlers.SubmitStockCount)
+				stockCounts.POST("/:id/cancel", handlers.CancelStockCount)
+			}
+
+			// Currency routes
+			protected.GET("/currencies", handlers.GetCurrencies)
+			protected.GET("/exchange-rates", handlers.GetExchangeRates)
+
+			// Category routes
+			categories := protected.Group("/categories")
+			{
+				categories.GET("/:id/translations", handlers.GetCategoryTranslations)
+				categories.PUT("/:id/translations/:locale", handlers.SaveCategoryTranslation)
+				categories.DELETE("/:id/translations/:locale", handlers.DeleteCategoryTranslation)
+			}
+
+			// Review routes
+			reviews := protected.Group("/reviews")
+			{
+				reviews.POST("/:id/votes", handlers.VoteReview)
 			}
 
 			// Order routes
@@ -74,7 +239,28 @@ func main() {
 				orders.GET("", handlers.GetOrders)
 				orders.GET("/:id", handlers.GetOrder)
 				orders.POST("", handlers.CreateOrder)
+				orders.POST("/allocation-preview", handlers.PreviewOrderAllocation)
 				orders.PUT("/:id", handlers.UpdateOrder)
+				orders.GET("/:id/history", handlers.GetOrderHistory)
+				orders.POST("/:id/invoice", handlers.IssueInvoice)
+			}
+
+			shipping := protected.Group("/shipping")
+			{
+				shipping.GET("/methods", handlers.GetShippingMethods)
+				shipping.POST("/quotes", handlers.QuoteShipping)
+			}
+
+			notifications := protected.Group("/notifications")
+			{
+				notifications.GET("", handlers.GetNotifications)
+				notifications.POST("/:id/read", handlers.MarkNotificationRead)
+			}
+
+			// Invoice routes
+			invoices := protected.Group("/invoices")
+			{
+				invoices.GET("/:id", handlers.GetInvoice)
 			}
 
 			// B2B specific routes
@@ -98,6 +284,54 @@ func main() {
 					pos.PUT("/:id", handlers.UpdatePurchaseOrder)
 				}
 			}
+
+			// Admin routes
+			admin := protected.Group("/admin")
+			admin.Use(middleware.Admin())
+			{
+				admin.GET("/reviews", handlers.GetReviewQueue)
+				admin.PUT("/reviews/:id", handlers.ModerateReview)
+
+				admin.POST("/currencies", handlers.SaveCurrency)
+				admin.POST("/exchange-rates", handlers.CreateExchangeRate)
+				admin.POST("/exchange-rates/import", handlers.ImportExchangeRates)
+
+				admin.POST("/search/reindex", handlers.ReindexProductSearch)
+
+				admin.GET("/tax-classes", handlers.GetTaxClasses)
+				admin.POST("/tax-classes", handlers.CreateTaxClass)
+				admin.PUT("/tax-classes/:id", handlers.UpdateTaxClass)
+				admin.GET("/tax-rates", handlers.GetTaxRates)
+				admin.POST("/tax-rates", handlers.CreateTaxRate)
+				admin.PUT("/tax-rates/:id", handlers.UpdateTaxRate)
+
+				admin.POST("/shipping-methods", handlers.CreateShippingMethod)
+				admin.PUT("/shipping-methods/:id", handlers.UpdateShippingMethod)
+				admin.GET("/shipping-zones", handlers.GetShippingZones)
+				admin.POST("/shipping-zones", handlers.CreateShippingZone)
+				admin.PUT("/shipping-zones/:id", handlers.UpdateShippingZone)
+				admin.GET("/price-lists", handlers.GetPriceLists)
+				admin.POST("/price-lists", handlers.CreatePriceList)
+				admin.PUT("/price-lists/:id", handlers.UpdatePriceList)
+				admin.GET("/promotions", handlers.GetPromotions)
+				admin.POST("/promotions", handlers.CreatePromotion)
+				admin.PUT("/promotions/:id", handlers.UpdatePromotion)
+				admin.POST("/promotions/:id/coupons", handlers.CreateCoupons)
+				admin.PUT("/coupons/:id", handlers.UpdateCoupon)
+
+				admin.GET("/number-sequences", handlers.GetNumberSequences)
+				admin.PUT("/number-sequences/:type", handlers.UpdateNumberSequence)
+
+				admin.GET("/trash", handlers.GetTrash)
+				admin.GET("/dependents/:type/:id", handlers.GetDependents)
+				admin.POST("/trash/:type/:id", handlers.TrashRecord)
+				admin.POST("/trash/:type/:id/restore", handlers.RestoreRecord)
+				admin.DELETE("/trash/:type/:id", handlers.PurgeRecord)
+
+				admin.POST("/stock-check", handlers.RunStockCheck)
+				admin.POST("/stock-counts/:id/recount", handlers.RequestStockRecount)
+				admin.POST("/stock-counts/:id/approve", handlers.ApproveStockCount)
+			}
 		}
 	}
 
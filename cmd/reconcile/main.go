@@ -0,0 +1,64 @@
+// Command reconcile compares inventory item balances with the stock ledger
+// and exits non-zero when they have drifted apart.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"marketprogo/config"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+func main() {
+	baseline := flag.Bool("baseline", false, "record opening-balance movements for items that predate the ledger")
+	flag.Parse()
+
+	cfg := config.LoadConfig()
+	logger.InitLogger()
+
+	if err := database.InitDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	db := database.GetDB()
+
+	drifts, err := services.ReconcileLedger(db)
+	if err != nil {
+		log.Fatalf("Failed to reconcile ledger: %v", err)
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println("Ledger and inventory balances agree")
+		return
+	}
+
+	fmt.Printf("%-10s %-10s %-10s %10s %10s %10s %10s\n",
+		"ITEM", "PRODUCT", "WAREHOUSE", "QUANTITY", "LEDGER", "RESERVED", "LEDGER")
+	for _, drift := range drifts {
+		fmt.Printf("%-10d %-10d %-10d %10d %10d %10d %10d\n",
+			drift.InventoryItemID, drift.ProductID, drift.WarehouseID,
+			drift.Quantity, drift.LedgerQuantity, drift.Reserved, drift.LedgerReserved)
+	}
+
+	if !*baseline {
+		fmt.Printf("%d inventory items have drifted from the ledger\n", len(drifts))
+		os.Exit(1)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		for _, drift := range drifts {
+			if err := services.RecordOpeningBalance(tx, drift); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Fatalf("Failed to record opening balances: %v", err)
+	}
+	fmt.Printf("Recorded opening balances for %d inventory items\n", len(drifts))
+}
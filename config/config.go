@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,6 +17,35 @@ type Config struct {
 	DBName     string
 	ServerPort string
 	JWTSecret  string
+
+	// BaseCurrency is the ISO 4217 code prices and accounts are kept in
+	BaseCurrency string
+
+	// DefaultLocale is the language catalog records are written in; other
+	// SupportedLocales are served from translation tables
+	DefaultLocale    string
+	SupportedLocales []string
+
+	// JobsEnabled runs the background jobs in this process. Disable it on
+	// all but one instance when running several API servers.
+	JobsEnabled bool
+
+	// UnpaidOrderTimeout is how long a pending, unpaid order keeps its
+	// stock before it is cancelled. Zero disables the timeout.
+	UnpaidOrderTimeout time.Duration
+
+	// AvailabilityCacheTTL bounds how long computed product availability
+	// is served from memory. Stock movements invalidate it sooner; zero
+	// disables the cache.
+	AvailabilityCacheTTL time.Duration
+
+	// TaxOriginCountry is where the store is registered for VAT; sales to
+	// business buyers elsewhere in the EU or UK are reverse charged
+	TaxOriginCountry string
+
+	// PricesIncludeTax treats catalog prices as including tax. Purchase
+	// and contract prices always exclude it.
+	PricesIncludeTax bool
 }
 
 func LoadConfig() *Config {
@@ -29,6 +60,16 @@ func LoadConfig() *Config {
 	}
 
 	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
+	jobsEnabled, _ := strconv.ParseBool(getEnv("JOBS_ENABLED", "true"))
+	pricesIncludeTax, _ := strconv.ParseBool(getEnv("PRICES_INCLUDE_TAX", "false"))
+	unpaidOrderTimeout, err := time.ParseDuration(getEnv("UNPAID_ORDER_TIMEOUT", "48h"))
+	if err != nil {
+		unpaidOrderTimeout = 48 * time.Hour
+	}
+	availabilityCacheTTL, err := time.ParseDuration(getEnv("AVAILABILITY_CACHE_TTL", "5m"))
+	if err != nil {
+		availabilityCacheTTL = 5 * time.Minute
+	}
 
 	return &Config{
 		DBHost:     getEnv("DB_HOST", "localhost"),
@@ -38,6 +79,18 @@ func LoadConfig() *Config {
 		DBName:     getEnv("DB_NAME", "marketprogo"),
 		ServerPort: getEnv("SERVER_PORT", "8080"),
 		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key"),
+
+		BaseCurrency: getEnv("BASE_CURRENCY", "GBP"),
+
+		DefaultLocale:    getEnv("DEFAULT_LOCALE", "en"),
+		SupportedLocales: strings.Split(getEnv("SUPPORTED_LOCALES", "en,fr,ar"), ","),
+
+		JobsEnabled:          jobsEnabled,
+		UnpaidOrderTimeout:   unpaidOrderTimeout,
+		AvailabilityCacheTTL: availabilityCacheTTL,
+
+		TaxOriginCountry: strings.ToUpper(getEnv("TAX_ORIGIN_COUNTRY", "GB")),
+		PricesIncludeTax: pricesIncludeTax,
 	}
 }
 
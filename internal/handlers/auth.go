@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"marketprogo/internal/models"
+	"marketprogo/internal/services"
 	"marketprogo/pkg/auth"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
@@ -25,6 +26,9 @@ type RegisterRequest struct {
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+
+	// CartToken merges the anonymous cart into the user's cart
+	CartToken string `json:"cart_token"`
 }
 
 type TokenResponse struct {
@@ -149,6 +153,17 @@ func Login(c *gin.Context) {
 		ExpiresIn:    3600,
 	}
 
+	// A failed merge leaves the anonymous cart to merge later; it does
+	// not fail the login
+	if req.CartToken != "" {
+		if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+			_, err := services.MergeCart(tx, req.CartToken, user.ID)
+			return err
+		}); err != nil {
+			logger.Error.Printf("Failed to merge cart at login: %v", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
 		"tokens":  tokens,
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"marketprogo/internal/models"
+	"marketprogo/pkg/barcode"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCodeSize = 300
+	maxCodeSize     = 2000
+	maxLabelsPerRun = 1000
+)
+
+type LabelSheetRequest struct {
+	Items     []LabelItemRequest `json:"items" binding:"required,min=1"`
+	Columns   int                `json:"columns"`
+	Rows      int                `json:"rows"`
+	ShowPrice bool               `json:"show_price"`
+	Symbology string             `json:"symbology" binding:"omitempty,oneof=qr code128 ean"`
+}
+
+type LabelItemRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Copies    int  `json:"copies"`
+}
+
+func GetProductByBarcode(c *gin.Context) {
+	code := barcode.Normalize(c.Param("code"))
+	if err := barcode.ValidateGTIN(code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().Preload("Categories").
+		Preload("Images").
+		Where("barcode = ?", code).
+		First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product": product})
+}
+
+func GetProductQRCode(c *gin.Context) {
+	product, ok := findProductForCode(c)
+	if !ok {
+		return
+	}
+
+	size := codeDimension(c.Query("size"), defaultCodeSize)
+	renderCode(c, product.SKU, barcode.SymbologyQR, size, size)
+}
+
+func GetProductBarcode(c *gin.Context) {
+	product, ok := findProductForCode(c)
+	if !ok {
+		return
+	}
+
+	symbology, content := defaultSymbology(product)
+	switch c.Query("type") {
+	case "":
+	case string(barcode.SymbologyEAN):
+		if product.Barcode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product has no barcode"})
+			return
+		}
+		symbology, content = barcode.SymbologyEAN, product.Barcode
+	case string(barcode.SymbologyCode128):
+		symbology = barcode.SymbologyCode128
+		if product.Barcode == "" {
+			content = product.SKU
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be ean or code128"})
+		return
+	}
+
+	width := codeDimension(c.Query("width"), defaultCodeSize)
+	height := codeDimension(c.Query("height"), defaultCodeSize/3)
+	renderCode(c, content, symbology, width, height)
+}
+
+func CreateLabelSheet(c *gin.Context) {
+	var req LabelSheetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	layout := barcode.SheetLayout{Columns: req.Columns, Rows: req.Rows}
+	if layout.Columns == 0 {
+		layout.Columns = 3
+	}
+	if layout.Rows == 0 {
+		layout.Rows = 8
+	}
+
+	ids := make([]uint, 0, len(req.Items))
+	for _, item := range req.Items {
+		ids = append(ids, item.ProductID)
+	}
+
+	var products []models.Product
+	if err := database.GetDB().Find(&products, ids).Error; err != nil {
+		logger.Error.Printf("Failed to find products for labels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create label sheet"})
+		return
+	}
+	byID := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	var labels []barcode.Label
+	for _, item := range req.Items {
+		product, exists := byID[item.ProductID]
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d not found", item.ProductID)})
+			return
+		}
+
+		symbology, content := defaultSymbology(product)
+		if req.Symbology != "" {
+			symbology = barcode.Symbology(req.Symbology)
+			if symbology != barcode.SymbologyEAN && product.Barcode == "" {
+				content = product.SKU
+			}
+		}
+
+		label := barcode.Label{
+			Title:     product.Name,
+			Subtitle:  "SKU " + product.SKU,
+			Content:   content,
+			Symbology: symbology,
+		}
+		if req.ShowPrice {
+			label.Price = fmt.Sprintf("%.2f", product.BasePrice)
+		}
+
+		copies := item.Copies
+		if copies <= 0 {
+			copies = 1
+		}
+		for i := 0; i < copies; i++ {
+			labels = append(labels, label)
+		}
+		if len(labels) > maxLabelsPerRun {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d labels can be printed at once", maxLabelsPerRun)})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := barcode.WriteLabelSheet(&buf, labels, layout); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, barcode.FormatSVG.ContentType(), buf.Bytes())
+}
+
+func findProductForCode(c *gin.Context) (models.Product, bool) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return product, false
+	}
+	return product, true
+}
+
+// defaultSymbology prefers a retail EAN symbol when the product carries a
+// GTIN that can be drawn as one, and falls back to Code128
+func defaultSymbology(product models.Product) (barcode.Symbology, string) {
+	if product.Barcode == "" {
+		return barcode.SymbologyCode128, product.SKU
+	}
+	if len(product.Barcode) != 14 && barcode.ValidateGTIN(product.Barcode) == nil {
+		return barcode.SymbologyEAN, product.Barcode
+	}
+	return barcode.SymbologyCode128, product.Barcode
+}
+
+func renderCode(c *gin.Context, content string, symbology barcode.Symbology, width, height int) {
+	format, err := barcode.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+		return
+	}
+
+	code, err := barcode.Encode(content, symbology)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := barcode.Write(&buf, code, format, width, height); err != nil {
+		logger.Error.Printf("Failed to render %s code: %v", symbology, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to render code at the requested size"})
+		return
+	}
+
+	c.Data(http.StatusOK, format.ContentType(), buf.Bytes())
+}
+
+func codeDimension(value string, fallback int) int {
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return fallback
+	}
+	if size > maxCodeSize {
+		return maxCodeSize
+	}
+	return size
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type BundleComponentRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Quantity  int  `json:"quantity" binding:"required,min=1"`
+}
+
+type BundleRequest struct {
+	Pricing         models.BundlePricing     `json:"pricing" binding:"required,oneof=FIXED SUM_MINUS_DISCOUNT"`
+	DiscountPercent float64                  `json:"discount_percent" binding:"min=0,max=100"`
+	Components      []BundleComponentRequest `json:"components" binding:"required,min=1,dive"`
+}
+
+func GetProductBundle(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().Preload("BundleComponents.Component").
+		First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if !product.IsBundle {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product is not a bundle"})
+		return
+	}
+
+	price, err := services.ProductUnitPrice(database.GetDB(), &product, services.BaseCurrency(), 1, false)
+	if err != nil && !errors.Is(err, services.ErrInvalidBundle) {
+		logger.Error.Printf("Failed to price bundle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bundle"})
+		return
+	}
+
+	available, err := services.ProductAvailableQuantity(database.GetDB(), &product)
+	if err != nil {
+		logger.Error.Printf("Failed to get bundle availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bundle"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pricing":          product.BundlePricing,
+		"discount_percent": product.BundleDiscountPercent,
+		"price":            price,
+		"available":        available,
+		"components":       product.BundleComponents,
+	})
+}
+
+func SetProductBundle(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req BundleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Components must be distinct, existing, non-bundle products
+	seen := make(map[uint]bool)
+	for _, component := range req.Components {
+		if component.ProductID == product.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A bundle cannot contain itself"})
+			return
+		}
+		if seen[component.ProductID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate bundle component"})
+			return
+		}
+		seen[component.ProductID] = true
+
+		var componentProduct models.Product
+		if err := database.GetDB().First(&componentProduct, component.ProductID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Component product not found"})
+			return
+		}
+		if componentProduct.IsBundle {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bundles cannot be nested"})
+			return
+		}
+	}
+
+	var usedAsComponent int64
+	if err := database.GetDB().Model(&models.BundleComponent{}).Where("component_id = ?", product.ID).Count(&usedAsComponent).Error; err != nil {
+		logger.Error.Printf("Failed to check bundle components: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save bundle"})
+		return
+	}
+	if usedAsComponent > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is a component of another bundle"})
+		return
+	}
+
+	tx := database.GetDB().Begin()
+
+	if err := tx.Unscoped().Where("bundle_id = ?", product.ID).Delete(&models.BundleComponent{}).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to clear bundle components: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save bundle"})
+		return
+	}
+
+	for _, component := range req.Components {
+		bundleComponent := models.BundleComponent{
+			BundleID:    product.ID,
+			ComponentID: component.ProductID,
+			Quantity:    component.Quantity,
+		}
+		if err := tx.Create(&bundleComponent).Error; err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to create bundle component: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save bundle"})
+			return
+		}
+	}
+
+	if err := tx.Model(&product).Updates(map[string]interface{}{
+		"is_bundle":               true,
+		"bundle_pricing":          req.Pricing,
+		"bundle_discount_percent": req.DiscountPercent,
+	}).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to update bundle product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save bundle"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		logger.Error.Printf("Failed to commit transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save bundle"})
+		return
+	}
+
+	GetProductBundle(c)
+}
+
+func DeleteProductBundle(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("bundle_id = ?", product.ID).Delete(&models.BundleComponent{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&product).Updates(map[string]interface{}{
+			"is_bundle":               false,
+			"bundle_pricing":          "",
+			"bundle_discount_percent": 0,
+		}).Error
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to delete bundle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete bundle"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bundle removed successfully"})
+}
+
+// GetProductAvailability reports on-hand, reserved, available and inbound
+// stock for one product across active warehouses
+func GetProductAvailability(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	availabilities, err := services.ProductAvailabilities(database.GetDB(), []uint{product.ID}, time.Now())
+	if err != nil {
+		logger.Error.Printf("Failed to get product availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, availabilities[product.ID])
+}
+
+// maxAvailabilityLookup caps the products in one bulk availability lookup
+const maxAvailabilityLookup = 200
+
+// GetProductsAvailability looks up availability for several products, given
+// as ids=1,2,3. Unknown products are left out.
+func GetProductsAvailability(c *gin.Context) {
+	var productIDs []uint
+	for _, value := range strings.Split(c.Query("ids"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID: " + value})
+			return
+		}
+		productIDs = append(productIDs, uint(id))
+	}
+	if len(productIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+	if len(productIDs) > maxAvailabilityLookup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d products can be looked up at once", maxAvailabilityLookup)})
+		return
+	}
+
+	availabilities, err := services.ProductAvailabilities(database.GetDB(), productIDs, time.Now())
+	if err != nil {
+		logger.Error.Printf("Failed to get product availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product availability"})
+		return
+	}
+
+	results := make([]*models.ProductAvailability, 0, len(availabilities))
+	for _, productID := range productIDs {
+		if availability, ok := availabilities[productID]; ok {
+			results = append(results, availability)
+			// List repeated IDs once
+			delete(availabilities, productID)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"availability": results})
+}
+
+func GetInvoice(c *gin.Context) {
+	var invoice models.Invoice
+	if err := database.GetDB().Preload("Order.Items.Product").
+		Preload("Order.Items.Components.Product").
+		First(&invoice, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// cartTokenHeader carries an anonymous cart's token
+const cartTokenHeader = "X-Cart-Token"
+
+type CartItemRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Quantity  int  `json:"quantity" binding:"required,min=1"`
+}
+
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity" binding:"min=0"` // 0 removes the item
+}
+
+type UpdateCartRequest struct {
+	Currency          *string `json:"currency"`
+	ShippingAddressID *uint   `json:"shipping_address_id"` // 0 clears it
+	Country           *string `json:"country" binding:"omitempty,max=2"`
+	PostalCode        *string `json:"postal_code"`
+	ShippingMethod    *string `json:"shipping_method"`
+}
+
+type CartCouponRequest struct {
+	Code string `json:"code" binding:"required,max=50"`
+}
+
+type MergeCartRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type CheckoutRequest struct {
+	PaymentMethod      string `json:"payment_method" binding:"required"`
+	CustomerNotes      string `json:"customer_notes"`
+	AllocationStrategy string `json:"allocation_strategy"`
+	ContractID         *uint  `json:"contract_id"`
+
+	// ExpectedFinalAmount is the total the customer was shown; the order
+	// is refused if the cart no longer costs that
+	ExpectedFinalAmount *float64 `json:"expected_final_amount"`
+}
+
+// GetCart returns the cart priced as it would be ordered now, with
+// warnings about stock and price changes. It changes nothing.
+func GetCart(c *gin.Context) {
+	db := database.GetDB()
+	cart, err := services.FindCart(db, cartOwner(c), false)
+	if err != nil {
+		respondCartError(c, err)
+		return
+	}
+	pricing, err := services.PriceCart(db, cart, time.Now())
+	if err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	respondCart(c, cart, pricing)
+}
+
+// AddCartItem adds the product to the cart, starting a cart if there is
+// none. Anonymous clients keep the token returned for later requests.
+func AddCartItem(c *gin.Context) {
+	var req CartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changeCart(c, true, func(tx *gorm.DB, cart *models.Cart) error {
+		return services.SetCartItem(tx, cart, req.ProductID, req.Quantity, true)
+	})
+}
+
+func UpdateCartItem(c *gin.Context) {
+	var req UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	productID, err := strconv.ParseUint(c.Param("productId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	changeCart(c, false, func(tx *gorm.DB, cart *models.Cart) error {
+		return services.SetCartItem(tx, cart, uint(productID), req.Quantity, false)
+	})
+}
+
+func RemoveCartItem(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("productId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	changeCart(c, false, func(tx *gorm.DB, cart *models.Cart) error {
+		return services.SetCartItem(tx, cart, uint(productID), 0, false)
+	})
+}
+
+// UpdateCart sets the cart's currency, destination and shipping method
+func UpdateCart(c *gin.Context) {
+	var req UpdateCartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changeCart(c, true, func(tx *gorm.DB, cart *models.Cart) error {
+		return services.UpdateCart(tx, cart, services.CartSettings{
+			Currency:          req.Currency,
+			ShippingAddressID: req.ShippingAddressID,
+			Country:           req.Country,
+			PostalCode:        req.PostalCode,
+			ShippingMethod:    req.ShippingMethod,
+		})
+	})
+}
+
+func AddCartCoupon(c *gin.Context) {
+	var req CartCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cart *models.Cart
+	var pricing *services.CartPricing
+	if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		if cart, err = services.FindCart(tx, cartOwner(c), true); err != nil {
+			return err
+		}
+		pricing, err = services.AddCartCoupon(tx, cart, req.Code, time.Now())
+		return err
+	}); err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	respondCart(c, cart, pricing)
+}
+
+func RemoveCartCoupon(c *gin.Context) {
+	changeCart(c, false, func(tx *gorm.DB, cart *models.Cart) error {
+		return services.RemoveCartCoupon(tx, cart, c.Param("code"))
+	})
+}
+
+// MergeCart moves an anonymous cart into the logged-in user's cart, for
+// clients that did not pass cart_token when logging in
+func MergeCart(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	var req MergeCartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cart *models.Cart
+	var pricing *services.CartPricing
+	if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		if cart, err = services.MergeCart(tx, req.Token, userID); err != nil {
+			return err
+		}
+		if pricing, err = services.PriceCart(tx, cart, time.Now()); err != nil {
+			return err
+		}
+		return services.SaveCartPricing(tx, cart, pricing)
+	}); err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	respondCart(c, cart, pricing)
+}
+
+// CheckoutCart turns the user's cart into an order. The order is placed
+// and the cart closed in one transaction.
+func CheckoutCart(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	var req CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	strategy, err := services.ParseAllocationStrategy(req.AllocationStrategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown allocation strategy"})
+		return
+	}
+	minShelfLife, err := services.MinShelfLifeDays(database.GetDB(), userID, req.ContractID)
+	if err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	// Retried from scratch if it loses a deadlock or serialization conflict
+	var order *models.Order
+	if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		order, err = services.CheckoutCart(tx, userID, services.CheckoutInput{
+			PaymentMethod:       req.PaymentMethod,
+			CustomerNotes:       req.CustomerNotes,
+			Strategy:            strategy,
+			MinShelfLifeDays:    minShelfLife,
+			ExpectedFinalAmount: req.ExpectedFinalAmount,
+		}, time.Now())
+		return err
+	}); err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Order created successfully",
+		"order":   order,
+	})
+}
+
+// changeCart runs fn on the request's cart in a transaction, starting a
+// cart first when create is set, and responds with the cart repriced. The
+// prices shown are saved with the change.
+func changeCart(c *gin.Context, create bool, fn func(tx *gorm.DB, cart *models.Cart) error) {
+	var cart *models.Cart
+	var pricing *services.CartPricing
+	if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		if create {
+			cart, err = services.OpenCart(tx, cartOwner(c))
+		} else {
+			cart, err = services.FindCart(tx, cartOwner(c), true)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(tx, cart); err != nil {
+			return err
+		}
+		if pricing, err = services.PriceCart(tx, cart, time.Now()); err != nil {
+			return err
+		}
+		return services.SaveCartPricing(tx, cart, pricing)
+	}); err != nil {
+		respondCartError(c, err)
+		return
+	}
+
+	respondCart(c, cart, pricing)
+}
+
+func respondCart(c *gin.Context, cart *models.Cart, pricing *services.CartPricing) {
+	c.Header(cartTokenHeader, cart.Token)
+	c.JSON(http.StatusOK, gin.H{
+		"cart":    cart,
+		"pricing": pricing,
+	})
+}
+
+func respondCartError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCartNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+	case errors.Is(err, services.ErrCartEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	case errors.Is(err, services.ErrCartNoAddress):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose a shipping address before checking out"})
+	case errors.Is(err, services.ErrCartChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cart total has changed, please review your cart"})
+	default:
+		respondOrderError(c, err)
+	}
+}
+
+// cartOwner is the logged-in user, or else the anonymous cart token sent
+func cartOwner(c *gin.Context) services.CartOwner {
+	return services.CartOwner{
+		UserID: currentUserIDPtr(c),
+		Token:  c.GetHeader(cartTokenHeader),
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserID returns the user set on the context by middleware.Auth
+func currentUserID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+	id, ok := value.(uint)
+	return id, ok
+}
+
+// isAdmin reports whether the user set by middleware.Auth is staff
+func isAdmin(c *gin.Context) bool {
+	return c.GetString("user_type") == string(models.UserTypeAdmin)
+}
+
+// currentUserIDPtr is currentUserID for nullable actor columns
+func currentUserIDPtr(c *gin.Context) *uint {
+	id, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+// requestLocales returns the locale fallback chain set by middleware.Locale
+func requestLocales(c *gin.Context) []string {
+	if value, exists := c.Get("locales"); exists {
+		if locales, ok := value.([]string); ok && len(locales) > 0 {
+			return locales
+		}
+	}
+	return []string{services.DefaultLocale()}
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"marketprogo/internal/models"
+	"marketprogo/internal/services"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreateContractRequest struct {
@@ -20,6 +23,9 @@ type CreateContractRequest struct {
 	Notes         string                   `json:"notes"`
 	Items         []ContractItemRequest    `json:"items" binding:"required,min=1"`
 	Schedule      *ContractScheduleRequest `json:"schedule"`
+
+	// Minimum remaining shelf life in days; defaults to the company's
+	MinShelfLifeDays *int `json:"min_shelf_life_days" binding:"omitempty,min=0"`
 }
 
 type ContractItemRequest struct {
@@ -40,6 +46,8 @@ type UpdateContractRequest struct {
 	AutoRenew    bool   `json:"auto_renew"`
 	PaymentTerms int    `json:"payment_terms"`
 	Notes        string `json:"notes"`
+
+	MinShelfLifeDays *int `json:"min_shelf_life_days" binding:"omitempty,min=0"`
 }
 
 func GetContracts(c *gin.Context) {
@@ -100,11 +108,25 @@ func CreateContract(c *gin.Context) {
 		RenewalPeriod: req.RenewalPeriod,
 		PaymentTerms:  req.PaymentTerms,
 		Notes:         req.Notes,
+
+		MinShelfLifeDays: req.MinShelfLifeDays,
+	}
+
+	taxContext, err := services.ContractTaxContext(tx, req.CompanyID, req.StartDate)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Company not found"})
+			return
+		}
+		logger.Error.Printf("Failed to work out contract tax: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contract"})
+		return
 	}
+	taxes := services.NewTaxCalculator(tx, taxContext)
 
 	// Create contract items
 	var items []models.ContractItem
-	var totalAmount float64
 
 	for _, itemReq := range req.Items {
 		var product models.Product
@@ -119,12 +141,17 @@ func CreateContract(c *gin.Context) {
 			ProductID:   product.ID,
 			Quantity:    itemReq.Quantity,
 			UnitPrice:   itemReq.UnitPrice,
-			TotalAmount: itemReq.UnitPrice * float64(itemReq.Quantity),
+			TotalAmount: services.RoundMoney(itemReq.UnitPrice * float64(itemReq.Quantity)),
 			IsActive:    true,
 		}
+		if item.LineTax, err = taxes.Line(product.TaxClassID, item.TotalAmount); err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to work out contract tax: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contract"})
+			return
+		}
 
 		items = append(items, item)
-		totalAmount += item.TotalAmount
 	}
 
 	// Create contract schedule if provided
@@ -139,6 +166,15 @@ func CreateContract(c *gin.Context) {
 		contract.Schedule = &schedule
 	}
 
+	number, err := services.NextNumber(tx, models.SequenceTypeContract, time.Now())
+	if err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to number contract: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contract"})
+		return
+	}
+	contract.ContractNumber = number
+
 	// Create contract
 	if err := tx.Create(&contract).Error; err != nil {
 		tx.Rollback()
@@ -194,6 +230,9 @@ func UpdateContract(c *gin.Context) {
 	if req.Notes != "" {
 		contract.Notes = req.Notes
 	}
+	if req.MinShelfLifeDays != nil {
+		contract.MinShelfLifeDays = req.MinShelfLifeDays
+	}
 
 	if err := database.GetDB().Save(&contract).Error; err != nil {
 		logger.Error.Printf("Failed to update contract: %v", err)
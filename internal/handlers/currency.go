@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CurrencyRequest struct {
+	Code          string `json:"code" binding:"required,len=3,alpha"`
+	Name          string `json:"name" binding:"required"`
+	Symbol        string `json:"symbol"`
+	DecimalPlaces *int   `json:"decimal_places" binding:"omitempty,min=0,max=4"`
+	IsActive      *bool  `json:"is_active"`
+}
+
+type ExchangeRateRequest struct {
+	BaseCurrency  string     `json:"base_currency" binding:"omitempty,len=3"`
+	QuoteCurrency string     `json:"quote_currency" binding:"required,len=3"`
+	Rate          float64    `json:"rate" binding:"required,gt=0"`
+	EffectiveAt   *time.Time `json:"effective_at"`
+}
+
+type ProductPriceRequest struct {
+	BasePrice float64  `json:"base_price" binding:"required,min=0"`
+	B2BPrice  *float64 `json:"b2b_price" binding:"omitempty,min=0"`
+}
+
+func GetCurrencies(c *gin.Context) {
+	var currencies []models.Currency
+	query := database.GetDB().Order("code")
+
+	if isActive := c.Query("is_active"); isActive != "" {
+		active, _ := strconv.ParseBool(isActive)
+		query = query.Where("is_active = ?", active)
+	}
+
+	if err := query.Find(&currencies).Error; err != nil {
+		logger.Error.Printf("Failed to get currencies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get currencies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"base_currency": services.BaseCurrency(),
+		"currencies":    currencies,
+	})
+}
+
+// SaveCurrency creates a currency or updates the one with the same code
+func SaveCurrency(c *gin.Context) {
+	var req CurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency := models.Currency{Code: strings.ToUpper(req.Code)}
+	if err := database.GetDB().Where("code = ?", currency.Code).First(&currency).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Error.Printf("Failed to find currency: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save currency"})
+		return
+	}
+
+	currency.Name = req.Name
+	currency.Symbol = req.Symbol
+	if currency.ID == 0 {
+		currency.DecimalPlaces = 2
+		currency.IsActive = true
+	}
+	if req.DecimalPlaces != nil {
+		currency.DecimalPlaces = *req.DecimalPlaces
+	}
+	if req.IsActive != nil {
+		currency.IsActive = *req.IsActive
+	}
+
+	if err := database.GetDB().Save(&currency).Error; err != nil {
+		logger.Error.Printf("Failed to save currency: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save currency"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Currency saved successfully",
+		"currency": currency,
+	})
+}
+
+func GetExchangeRates(c *gin.Context) {
+	var rates []models.ExchangeRate
+	query := database.GetDB().Order("effective_at DESC, id DESC")
+
+	if currency := c.Query("currency"); currency != "" {
+		currency = strings.ToUpper(currency)
+		query = query.Where("quote_currency = ? OR base_currency = ?", currency, currency)
+	}
+	if from := c.Query("from"); from != "" {
+		at, err := parseDateParam(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		query = query.Where("effective_at >= ?", at)
+	}
+	if to := c.Query("to"); to != "" {
+		at, err := parseDateParam(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		query = query.Where("effective_at <= ?", at)
+	}
+
+	if err := query.Find(&rates).Error; err != nil {
+		logger.Error.Printf("Failed to get exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exchange_rates": rates})
+}
+
+func CreateExchangeRate(c *gin.Context) {
+	var req ExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate := models.ExchangeRate{
+		BaseCurrency:  strings.ToUpper(req.BaseCurrency),
+		QuoteCurrency: strings.ToUpper(req.QuoteCurrency),
+		Rate:          req.Rate,
+		EffectiveAt:   time.Now(),
+		Source:        models.ExchangeRateSourceManual,
+		CreatedByID:   currentUserIDPtr(c),
+	}
+	if rate.BaseCurrency == "" {
+		rate.BaseCurrency = services.BaseCurrency()
+	}
+	if req.EffectiveAt != nil {
+		rate.EffectiveAt = *req.EffectiveAt
+	}
+	if rate.BaseCurrency == rate.QuoteCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Base and quote currency must differ"})
+		return
+	}
+
+	if err := database.GetDB().Create(&rate).Error; err != nil {
+		logger.Error.Printf("Failed to create exchange rate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Exchange rate created successfully",
+		"exchange_rate": rate,
+	})
+}
+
+// ImportExchangeRates loads a CSV upload with the header
+// quote_currency,rate,effective_at[,base_currency]. The whole file is
+// rejected if any row is invalid.
+func ImportExchangeRates(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A CSV file is required"})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		logger.Error.Printf("Failed to open rate import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer f.Close()
+
+	rates, err := parseExchangeRateCSV(f, currentUserIDPtr(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file contains no rates"})
+		return
+	}
+
+	if err := database.GetDB().CreateInBatches(&rates, 500).Error; err != nil {
+		logger.Error.Printf("Failed to import exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Exchange rates imported successfully",
+		"imported": len(rates),
+	})
+}
+
+func parseExchangeRateCSV(r io.Reader, userID *uint) ([]models.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"quote_currency", "rate", "effective_at"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+	baseColumn, hasBase := columns["base_currency"]
+
+	var rates []models.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		rate := models.ExchangeRate{
+			BaseCurrency:  services.BaseCurrency(),
+			QuoteCurrency: strings.ToUpper(record[columns["quote_currency"]]),
+			Source:        models.ExchangeRateSourceImport,
+			CreatedByID:   userID,
+		}
+		if hasBase && record[baseColumn] != "" {
+			rate.BaseCurrency = strings.ToUpper(record[baseColumn])
+		}
+		if len(rate.QuoteCurrency) != 3 || len(rate.BaseCurrency) != 3 || rate.QuoteCurrency == rate.BaseCurrency {
+			return nil, fmt.Errorf("line %d: invalid currency pair", line)
+		}
+		if rate.Rate, err = strconv.ParseFloat(record[columns["rate"]], 64); err != nil || rate.Rate <= 0 {
+			return nil, fmt.Errorf("line %d: rate must be a positive number", line)
+		}
+		if rate.EffectiveAt, err = parseDateParam(record[columns["effective_at"]], false); err != nil || record[columns["effective_at"]] == "" {
+			return nil, fmt.Errorf("line %d: effective_at must be YYYY-MM-DD or RFC3339", line)
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+func GetProductPrices(c *gin.Context) {
+	var prices []models.ProductPrice
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		Order("currency").
+		Find(&prices).Error; err != nil {
+		logger.Error.Printf("Failed to get product prices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prices": prices})
+}
+
+func SetProductPrice(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req ProductPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency, err := services.ResolveCurrency(database.GetDB(), c.Param("currency"))
+	if errors.Is(err, services.ErrUnknownCurrency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown currency"})
+		return
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to resolve currency: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set product price"})
+		return
+	}
+	if currency == services.BaseCurrency() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Base currency prices are set on the product itself"})
+		return
+	}
+
+	price := models.ProductPrice{
+		ProductID: product.ID,
+		Currency:  currency,
+		BasePrice: req.BasePrice,
+		B2BPrice:  req.B2BPrice,
+	}
+	if err := database.GetDB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"base_price", "b2b_price", "updated_at", "deleted_at"}),
+	}).Create(&price).Error; err != nil {
+		logger.Error.Printf("Failed to set product price: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set product price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Product price saved successfully",
+		"price":   price,
+	})
+}
+
+func DeleteProductPrice(c *gin.Context) {
+	result := database.GetDB().Unscoped().
+		Where("product_id = ? AND currency = ?", c.Param("id"), strings.ToUpper(c.Param("currency"))).
+		Delete(&models.ProductPrice{})
+	if result.Error != nil {
+		logger.Error.Printf("Failed to delete product price: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product price"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product price not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product price deleted successfully"})
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateInventoryItemRequest struct {
+	ProductID   uint                    `json:"product_id" binding:"required"`
+	WarehouseID uint                    `json:"warehouse_id" binding:"required"`
+	Quantity    int                     `json:"quantity" binding:"min=0"`
+	BatchNumber string                  `json:"batch_number"`
+	ExpiryDate  *time.Time              `json:"expiry_date"`
+	Status      string                  `json:"status" binding:"omitempty,oneof=active expired damaged"`
+	Reason      models.AdjustmentReason `json:"reason" binding:"required"`
+	Note        string                  `json:"note"`
+}
+
+type UpdateInventoryItemRequest struct {
+	BatchNumber *string    `json:"batch_number"`
+	ExpiryDate  *time.Time `json:"expiry_date"`
+}
+
+type InventoryAdjustmentRequest struct {
+	QuantityChange int                     `json:"quantity_change"`
+	Status         string                  `json:"status" binding:"omitempty,oneof=active expired damaged"`
+	Reason         models.AdjustmentReason `json:"reason" binding:"required"`
+	Note           string                  `json:"note"`
+}
+
+// InventoryItemResponse adds the product and free stock to an inventory item
+type InventoryItemResponse struct {
+	models.InventoryItem
+	ProductSKU  string `json:"product_sku"`
+	ProductName string `json:"product_name"`
+	Available   int    `json:"available"`
+}
+
+func newInventoryItemResponse(item models.InventoryItem) InventoryItemResponse {
+	return InventoryItemResponse{
+		InventoryItem: item,
+		ProductSKU:    item.Product.SKU,
+		ProductName:   item.Product.Name,
+		Available:     item.Quantity - item.Reserved,
+	}
+}
+
+func GetInventoryItem(c *gin.Context) {
+	var item models.InventoryItem
+	if err := database.GetDB().Preload("Product").Preload("Warehouse").
+		First(&item, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newInventoryItemResponse(item))
+}
+
+// CreateInventoryItem puts a new batch of a product into a warehouse. The
+// opening quantity is recorded as an adjustment with the given reason.
+func CreateInventoryItem(c *gin.Context) {
+	var req CreateInventoryItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().First(&product, req.ProductID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.IsBundle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bundles are stocked as their components"})
+		return
+	}
+
+	var warehouse models.Warehouse
+	if err := database.GetDB().First(&warehouse, req.WarehouseID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	item := models.InventoryItem{
+		ProductID:   product.ID,
+		WarehouseID: warehouse.ID,
+		BatchNumber: req.BatchNumber,
+		Status:      models.InventoryStatusActive,
+	}
+	if req.ExpiryDate != nil {
+		item.ExpiryDate = *req.ExpiryDate
+	}
+
+	var createErr error
+	err := services.Transaction(database.GetDB(), func(tx *gorm.DB) error {
+		if createErr = tx.Create(&item).Error; createErr != nil {
+			return createErr
+		}
+
+		if req.Quantity > 0 || (req.Status != "" && req.Status != item.Status) {
+			updated, _, err := services.AdjustStock(tx, item.ID, services.StockAdjustment{
+				QuantityChange: req.Quantity,
+				Status:         req.Status,
+				Reason:         req.Reason,
+				Note:           req.Note,
+				AdjustedByID:   currentUserIDPtr(c),
+			})
+			if err != nil {
+				return err
+			}
+			item = *updated
+		}
+		return nil
+	})
+	if createErr != nil {
+		logger.Error.Printf("Failed to create inventory item: %v", createErr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create inventory item"})
+		return
+	}
+	if err != nil {
+		respondAdjustmentError(c, err)
+		return
+	}
+
+	item.Product = product
+	item.Warehouse = warehouse
+	c.JSON(http.StatusCreated, newInventoryItemResponse(item))
+}
+
+// UpdateInventoryItem corrects batch details. Quantity and status only
+// change through adjustments.
+func UpdateInventoryItem(c *gin.Context) {
+	var item models.InventoryItem
+	if err := database.GetDB().Preload("Product").First(&item, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory item not found"})
+		return
+	}
+
+	var req UpdateInventoryItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.BatchNumber != nil {
+		updates["batch_number"] = *req.BatchNumber
+	}
+	if req.ExpiryDate != nil {
+		updates["expiry_date"] = *req.ExpiryDate
+	}
+	if len(updates) > 0 {
+		if err := database.GetDB().Model(&item).Updates(updates).Error; err != nil {
+			logger.Error.Printf("Failed to update inventory item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory item"})
+			return
+		}
+		// A new expiry date can make the batch sellable or not
+		services.InvalidateAvailability(item.ProductID)
+	}
+
+	c.JSON(http.StatusOK, newInventoryItemResponse(item))
+}
+
+func AdjustInventoryItem(c *gin.Context) {
+	var existing models.InventoryItem
+	if err := database.GetDB().Preload("Product").First(&existing, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory item not found"})
+		return
+	}
+
+	var req InventoryAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var (
+		item       *models.InventoryItem
+		adjustment *models.InventoryAdjustment
+	)
+	err := services.Transaction(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		item, adjustment, err = services.AdjustStock(tx, existing.ID, services.StockAdjustment{
+			QuantityChange: req.QuantityChange,
+			Status:         req.Status,
+			Reason:         req.Reason,
+			Note:           req.Note,
+			AdjustedByID:   currentUserIDPtr(c),
+		})
+		return err
+	})
+	if err != nil {
+		respondAdjustmentError(c, err)
+		return
+	}
+
+	item.Product = existing.Product
+	c.JSON(http.StatusOK, gin.H{
+		"inventory_item": newInventoryItemResponse(*item),
+		"adjustment":     adjustment,
+	})
+}
+
+func GetInventoryAdjustments(c *gin.Context) {
+	var adjustments []models.InventoryAdjustment
+	if err := database.GetDB().Where("inventory_item_id = ?", c.Param("id")).
+		Order("created_at DESC, id DESC").
+		Find(&adjustments).Error; err != nil {
+		logger.Error.Printf("Failed to get inventory adjustments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory adjustments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"adjustments": adjustments})
+}
+
+// GetAdjustmentReasons lists the reason codes adjustments accept
+func GetAdjustmentReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"reasons": models.AdjustmentReasons})
+}
+
+func respondAdjustmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownReason):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reason code", "reasons": models.AdjustmentReasons})
+	case errors.Is(err, services.ErrNothingToApply):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Adjustment changes neither quantity nor status"})
+	case errors.Is(err, services.ErrNegativeStock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Adjustment would make stock negative"})
+	case errors.Is(err, services.ErrBelowReserved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Adjustment would leave less stock than is reserved"})
+	case errors.Is(err, services.ErrReservationsStranded):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.Error.Printf("Failed to adjust inventory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust inventory"})
+	}
+}
+
+// GetInventoryMovements searches the stock ledger
+func GetInventoryMovements(c *gin.Context) {
+	var movements []models.InventoryMovement
+	query := database.GetDB().Order("created_at DESC, id DESC")
+
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+	if movementType := c.Query("type"); movementType != "" {
+		query = query.Where("type = ?", movementType)
+	}
+	if referenceType := c.Query("reference_type"); referenceType != "" {
+		query = query.Where("reference_type = ?", referenceType)
+		if referenceID := c.Query("reference_id"); referenceID != "" {
+			query = query.Where("reference_id = ?", referenceID)
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		at, err := parseDateParam(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		query = query.Where("created_at >= ?", at)
+	}
+	if to := c.Query("to"); to != "" {
+		at, err := parseDateParam(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		query = query.Where("created_at <= ?", at)
+	}
+
+	if err := query.Limit(1000).Find(&movements).Error; err != nil {
+		logger.Error.Printf("Failed to get inventory movements: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory movements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"movements": movements})
+}
+
+// GetInventoryItemLedger returns an item's movements with the balances they
+// add up to next to the stored ones
+func GetInventoryItemLedger(c *gin.Context) {
+	var item models.InventoryItem
+	if err := database.GetDB().First(&item, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inventory item not found"})
+		return
+	}
+
+	var movements []models.InventoryMovement
+	if err := database.GetDB().Where("inventory_item_id = ?", item.ID).
+		Order("created_at, id").
+		Find(&movements).Error; err != nil {
+		logger.Error.Printf("Failed to get inventory movements: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory ledger"})
+		return
+	}
+
+	var quantity, reserved int
+	for _, movement := range movements {
+		quantity += movement.QuantityDelta
+		reserved += movement.ReservedDelta
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"inventory_item_id": item.ID,
+		"movements":         movements,
+		"ledger_quantity":   quantity,
+		"ledger_reserved":   reserved,
+		"quantity":          item.Quantity,
+		"reserved":          item.Reserved,
+		"in_balance":        quantity == item.Quantity && reserved == item.Reserved,
+	})
+}
+
+// GetNearExpiryStock reports active batches expiring within the given
+// number of days, grouped by warehouse
+func GetNearExpiryStock(c *gin.Context) {
+	days := 30
+	if value := c.Query("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+			return
+		}
+		days = parsed
+	}
+
+	var warehouseID *uint
+	if value := c.Query("warehouse_id"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		id := uint(parsed)
+		warehouseID = &id
+	}
+
+	report, err := services.NearExpiryReport(database.GetDB(), time.Now(), days, warehouseID)
+	if err != nil {
+		logger.Error.Printf("Failed to get near-expiry stock: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get near-expiry stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"days": days, "warehouses": report})
+}
@@ -1,21 +1,37 @@
 package handlers
 
 import (
+	"errors"
 	"marketprogo/internal/models"
+	"marketprogo/internal/services"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CreateOrderRequest struct {
-	ShippingAddressID uint               `json:"shipping_address_id" binding:"required"`
-	ShippingMethod    string             `json:"shipping_method" binding:"required"`
-	PaymentMethod     string             `json:"payment_method" binding:"required"`
-	CustomerNotes     string             `json:"customer_notes"`
-	Items             []OrderItemRequest `json:"items" binding:"required,min=1"`
+	ShippingAddressID  uint               `json:"shipping_address_id" binding:"required"`
+	ShippingMethod     string             `json:"shipping_method" binding:"required"`
+	PaymentMethod      string             `json:"payment_method" binding:"required"`
+	CustomerNotes      string             `json:"customer_notes"`
+	Currency           string             `json:"currency"` // defaults to the base currency
+	AllocationStrategy string             `json:"allocation_strategy"`
+	ContractID         *uint              `json:"contract_id"`
+	CouponCodes        []string           `json:"coupon_codes"`
+	Items              []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type AllocationPreviewRequest struct {
+	ShippingAddressID  uint               `json:"shipping_address_id"`
+	AllocationStrategy string             `json:"allocation_strategy"`
+	ContractID         *uint              `json:"contract_id"`
+	Items              []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
 }
 
 type OrderItemRequest struct {
@@ -27,6 +43,8 @@ type UpdateOrderRequest struct {
 	Status        string `json:"status"`
 	PaymentStatus string `json:"payment_status"`
 	AdminNotes    string `json:"admin_notes"`
+	// Reason is kept in the status history
+	Reason string `json:"reason"`
 }
 
 func GetOrders(c *gin.Context) {
@@ -59,6 +77,8 @@ func GetOrder(c *gin.Context) {
 
 	if err := database.GetDB().Preload("User").
 		Preload("Items.Product").
+		Preload("Items.Components.Product").
+		Preload("Items.Allocations").
 		Preload("ShippingAddress").
 		First(&order, id).Error; err != nil {
 		logger.Error.Printf("Failed to get order: %v", err)
@@ -70,108 +90,107 @@ func GetOrder(c *gin.Context) {
 }
 
 func CreateOrder(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// TODO: Get user ID from JWT token
-	userID := uint(1) // Temporary hardcoded user ID
+	strategy, err := services.ParseAllocationStrategy(req.AllocationStrategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown allocation strategy"})
+		return
+	}
 
-	// Start transaction
-	tx := database.GetDB().Begin()
+	minShelfLife, err := services.MinShelfLifeDays(database.GetDB(), userID, req.ContractID)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
 
-	// Create order
-	order := models.Order{
+	input := services.PlaceOrderInput{
 		UserID:            userID,
-		Status:            models.OrderStatusPending,
-		PaymentStatus:     models.PaymentStatusPending,
 		ShippingAddressID: req.ShippingAddressID,
 		ShippingMethod:    req.ShippingMethod,
 		PaymentMethod:     req.PaymentMethod,
 		CustomerNotes:     req.CustomerNotes,
-		OrderDate:         time.Now(),
+		Currency:          req.Currency,
+		Strategy:          strategy,
+		MinShelfLifeDays:  minShelfLife,
+		CouponCodes:       req.CouponCodes,
+		Lines:             orderLines(req.Items),
 	}
 
-	// Calculate order totals
-	var totalAmount float64
-	var items []models.OrderItem
-
-	for _, itemReq := range req.Items {
-		var product models.Product
-		if err := tx.First(&product, itemReq.ProductID).Error; err != nil {
-			tx.Rollback()
-			logger.Error.Printf("Failed to find product: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
-			return
-		}
-
-		// Check inventory
-		var inventory models.InventoryItem
-		if err := tx.Where("product_id = ? AND status = 'active'", product.ID).
-			First(&inventory).Error; err != nil {
-			tx.Rollback()
-			logger.Error.Printf("Failed to find inventory: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Product out of stock"})
-			return
-		}
+	// Retried from scratch if it loses a deadlock or serialization conflict
+	var order *models.Order
+	if err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		var err error
+		order, err = services.PlaceOrder(tx, input)
+		return err
+	}); err != nil {
+		respondOrderError(c, err)
+		return
+	}
 
-		if inventory.Quantity-inventory.Reserved < itemReq.Quantity {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
-			return
-		}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Order created successfully",
+		"order":   order,
+	})
+}
 
-		// Create order item
-		orderItem := models.OrderItem{
-			ProductID:       product.ID,
-			Quantity:        itemReq.Quantity,
-			UnitPrice:       product.BasePrice,
-			TotalAmount:     product.BasePrice * float64(itemReq.Quantity),
-			InventoryItemID: &inventory.ID,
-		}
+// PreviewOrderAllocation shows which warehouses and batches would serve an
+// order without reserving stock
+func PreviewOrderAllocation(c *gin.Context) {
+	var req AllocationPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		items = append(items, orderItem)
-		totalAmount += orderItem.TotalAmount
+	strategy, err := services.ParseAllocationStrategy(req.AllocationStrategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown allocation strategy"})
+		return
+	}
 
-		// Reserve inventory
-		inventory.Reserved += itemReq.Quantity
-		if err := tx.Save(&inventory).Error; err != nil {
-			tx.Rollback()
-			logger.Error.Printf("Failed to update inventory: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	var destination *models.Address
+	if req.ShippingAddressID != 0 {
+		var address models.Address
+		if err := database.GetDB().First(&address, req.ShippingAddressID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping address not found"})
 			return
 		}
+		destination = &address
 	}
 
-	order.TotalAmount = totalAmount
-	order.FinalAmount = totalAmount // Add shipping and tax calculations here
-
-	// Create order
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		logger.Error.Printf("Failed to create order: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	var userID uint
+	if id, ok := currentUserID(c); ok {
+		userID = id
+	}
+	minShelfLife, err := services.MinShelfLifeDays(database.GetDB(), userID, req.ContractID)
+	if err != nil {
+		respondOrderError(c, err)
 		return
 	}
 
-	// Create order items
-	for i := range items {
-		items[i].OrderID = order.ID
-		if err := tx.Create(&items[i]).Error; err != nil {
-			tx.Rollback()
-			logger.Error.Printf("Failed to create order item: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-			return
-		}
+	plan, err := services.PreviewAllocation(database.GetDB(), orderLines(req.Items), services.AllocationOptions{
+		Strategy:         strategy,
+		Destination:      destination,
+		MinShelfLifeDays: minShelfLife,
+	})
+	if err != nil {
+		respondOrderError(c, err)
+		return
 	}
 
-	tx.Commit()
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Order created successfully",
-		"order":   order,
+	c.JSON(http.StatusOK, gin.H{
+		"allocation": plan,
+		"complete":   plan.Complete(),
 	})
 }
 
@@ -183,42 +202,179 @@ func UpdateOrder(c *gin.Context) {
 		return
 	}
 
+	// Customers may only cancel their own orders; payment and fulfilment
+	// are changed by staff
+	admin := isAdmin(c)
+	if !admin && (req.Status != string(models.OrderStatusCancelled) || req.PaymentStatus != "" || req.AdminNotes != "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+	userID, _ := currentUserID(c)
+
 	var order models.Order
-	if err := database.GetDB().First(&order, id).Error; err != nil {
-		logger.Error.Printf("Failed to find order: %v", err)
+	err := services.Transaction(database.GetDB(), func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		if !admin {
+			query = query.Where("user_id = ?", userID)
+		}
+		if err := query.First(&order, id).Error; err != nil {
+			return err
+		}
+
+		// Status changes go through the status graphs, which also move
+		// stock and record the history
+		if err := services.ChangeOrderStatus(tx, &order, services.StatusChange{
+			Status:        models.OrderStatus(req.Status),
+			PaymentStatus: models.PaymentStatus(req.PaymentStatus),
+			Reason:        req.Reason,
+			UserID:        currentUserIDPtr(c),
+		}, time.Now()); err != nil {
+			return err
+		}
+
+		if req.AdminNotes != "" {
+			order.AdminNotes = req.AdminNotes
+			return tx.Model(&order).Update("admin_notes", order.AdminNotes).Error
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
+	if err != nil {
+		respondStatusChangeError(c, err)
+		return
+	}
 
-	// Update fields
-	if req.Status != "" {
-		order.Status = models.OrderStatus(req.Status)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order updated successfully",
+		"order":   order,
+	})
+}
+
+// GetOrderHistory lists an order's status changes and the statuses it can
+// move to next
+func GetOrderHistory(c *gin.Context) {
+	var order models.Order
+	if err := database.GetDB().First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
 	}
-	if req.PaymentStatus != "" {
-		order.PaymentStatus = models.PaymentStatus(req.PaymentStatus)
+
+	var history []models.OrderStatusHistory
+	if err := database.GetDB().Where("order_id = ?", order.ID).Order("id").Find(&history).Error; err != nil {
+		logger.Error.Printf("Failed to get order history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order history"})
+		return
 	}
-	if req.AdminNotes != "" {
-		order.AdminNotes = req.AdminNotes
+
+	c.JSON(http.StatusOK, gin.H{
+		"history": history,
+		"allowed": gin.H{
+			"status":         services.AllowedOrderStatuses(&order),
+			"payment_status": services.AllowedPaymentStatuses(&order),
+		},
+	})
+}
+
+// IssueInvoice bills an order and numbers the invoice
+func IssueInvoice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
 	}
 
-	// Update order status dates
-	if order.Status == models.OrderStatusShipped && order.ShippedDate == nil {
-		now := time.Now()
-		order.ShippedDate = &now
+	var invoice *models.Invoice
+	if err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		invoice, err = services.IssueInvoice(tx, uint(id), time.Now())
+		return err
+	}); err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		case errors.Is(err, services.ErrInvoiceExists), errors.Is(err, services.ErrOrderNotBillable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			logger.Error.Printf("Failed to issue invoice: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue invoice"})
+		}
+		return
 	}
-	if order.Status == models.OrderStatusDelivered && order.DeliveredDate == nil {
-		now := time.Now()
-		order.DeliveredDate = &now
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Invoice issued successfully",
+		"invoice": invoice,
+	})
+}
+
+func orderLines(items []OrderItemRequest) []services.OrderLine {
+	lines := make([]services.OrderLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, services.OrderLine{ProductID: item.ProductID, Quantity: item.Quantity})
 	}
+	return lines
+}
 
-	if err := database.GetDB().Save(&order).Error; err != nil {
+// respondStatusChangeError answers a refused status change with the
+// statuses that are allowed instead
+func respondStatusChangeError(c *gin.Context, err error) {
+	var transitionErr *services.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   transitionErr.Error(),
+			"field":   transitionErr.Field,
+			"current": transitionErr.From,
+			"allowed": transitionErr.Allowed,
+		})
+	case errors.Is(err, services.ErrUnknownStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
 		logger.Error.Printf("Failed to update order: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
-		return
 	}
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Order updated successfully",
-		"order":   order,
-	})
+// respondOrderError maps an order placement failure onto the response
+func respondOrderError(c *gin.Context, err error) {
+	var stockErr *services.StockError
+	var couponErr *services.CouponError
+	switch {
+	case errors.As(err, &stockErr):
+		message := "Insufficient stock"
+		if errors.Is(err, services.ErrOutOfStock) {
+			message = "Product out of stock"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      message,
+			"product_id": stockErr.ProductID,
+			"requested":  stockErr.Requested,
+			"available":  stockErr.Available,
+		})
+	case errors.Is(err, services.ErrProductNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+	case errors.Is(err, services.ErrInvalidBundle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bundle has no components"})
+	case errors.Is(err, services.ErrAddressNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping address not found"})
+	case errors.As(err, &couponErr):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Coupon cannot be used: " + couponErr.Reason,
+			"coupon": couponErr.Code,
+		})
+	case errors.Is(err, services.ErrShippingMethodUnavailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping method not available for this order"})
+	case errors.Is(err, services.ErrContractNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Contract not found"})
+	case errors.Is(err, services.ErrUnknownCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+	case errors.Is(err, services.ErrNoExchangeRate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rate available for the requested currency"})
+	default:
+		logger.Error.Printf("Failed to create order: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	}
 }
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PriceListRequest struct {
+	Name       string                 `json:"name" binding:"required"`
+	StartsAt   *time.Time             `json:"starts_at"`
+	EndsAt     *time.Time             `json:"ends_at"`
+	IsActive   *bool                  `json:"is_active"`
+	Items      []PriceListItemRequest `json:"items" binding:"dive"`
+	CompanyIDs []uint                 `json:"company_ids"`
+}
+
+type PriceListItemRequest struct {
+	ProductID uint    `json:"product_id" binding:"required"`
+	Price     float64 `json:"price" binding:"min=0"` // in the base currency
+}
+
+type priceListResponse struct {
+	models.PriceList
+	CompanyIDs []uint `json:"company_ids"`
+}
+
+func GetPriceLists(c *gin.Context) {
+	db := database.GetDB()
+	var priceLists []models.PriceList
+	if err := db.Preload("Items").Order("id").Find(&priceLists).Error; err != nil {
+		logger.Error.Printf("Failed to get price lists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get price lists"})
+		return
+	}
+
+	var companies []models.Company
+	if err := db.Select("id", "price_list_id").Where("price_list_id IS NOT NULL").Order("id").Find(&companies).Error; err != nil {
+		logger.Error.Printf("Failed to get price list companies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get price lists"})
+		return
+	}
+	companyIDs := make(map[uint][]uint)
+	for _, company := range companies {
+		companyIDs[*company.PriceListID] = append(companyIDs[*company.PriceListID], company.ID)
+	}
+
+	response := make([]priceListResponse, 0, len(priceLists))
+	for _, priceList := range priceLists {
+		ids := companyIDs[priceList.ID]
+		if ids == nil {
+			ids = []uint{}
+		}
+		response = append(response, priceListResponse{PriceList: priceList, CompanyIDs: ids})
+	}
+	c.JSON(http.StatusOK, gin.H{"price_lists": response})
+}
+
+func CreatePriceList(c *gin.Context) {
+	var req PriceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	priceList := models.PriceList{IsActive: true}
+	if !applyPriceListRequest(c, &priceList, &req) {
+		return
+	}
+	if err := savePriceList(&priceList, req.CompanyIDs); err != nil {
+		logger.Error.Printf("Failed to create price list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create price list"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Price list created successfully",
+		"price_list": priceListResponse{PriceList: priceList, CompanyIDs: req.CompanyIDs},
+	})
+}
+
+// UpdatePriceList replaces a price list's prices and companies. Orders
+// already placed keep the prices they were given.
+func UpdatePriceList(c *gin.Context) {
+	var req PriceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var priceList models.PriceList
+	if err := database.GetDB().First(&priceList, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price list not found"})
+		return
+	}
+
+	if !applyPriceListRequest(c, &priceList, &req) {
+		return
+	}
+	if err := savePriceList(&priceList, req.CompanyIDs); err != nil {
+		logger.Error.Printf("Failed to update price list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update price list"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Price list updated successfully",
+		"price_list": priceListResponse{PriceList: priceList, CompanyIDs: req.CompanyIDs},
+	})
+}
+
+func applyPriceListRequest(c *gin.Context, priceList *models.PriceList, req *PriceListRequest) bool {
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return false
+	}
+
+	db := database.GetDB()
+	productIDs := make([]uint, 0, len(req.Items))
+	for _, item := range req.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	if len(uniqueIDs(productIDs)) != len(productIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Each product can only be listed once"})
+		return false
+	}
+	if len(productIDs) > 0 {
+		var count int64
+		if err := db.Model(&models.Product{}).Where("id IN ?", productIDs).Count(&count).Error; err != nil {
+			logger.Error.Printf("Failed to check price list products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate price list"})
+			return false
+		}
+		if int(count) != len(productIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+			return false
+		}
+	}
+	if len(req.CompanyIDs) > 0 {
+		var count int64
+		if err := db.Model(&models.Company{}).Where("id IN ?", req.CompanyIDs).Count(&count).Error; err != nil {
+			logger.Error.Printf("Failed to check price list companies: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate price list"})
+			return false
+		}
+		if int(count) != len(uniqueIDs(req.CompanyIDs)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Company not found"})
+			return false
+		}
+	}
+
+	priceList.Name = req.Name
+	priceList.StartsAt = req.StartsAt
+	priceList.EndsAt = req.EndsAt
+	if req.IsActive != nil {
+		priceList.IsActive = *req.IsActive
+	}
+	priceList.Items = make([]models.PriceListItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		priceList.Items = append(priceList.Items, models.PriceListItem{ProductID: item.ProductID, Price: item.Price})
+	}
+	if req.CompanyIDs == nil {
+		req.CompanyIDs = []uint{}
+	}
+	return true
+}
+
+// savePriceList saves the price list, replaces its items and moves the
+// listed companies onto it; companies no longer listed are taken off
+func savePriceList(priceList *models.PriceList, companyIDs []uint) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		items := priceList.Items
+		if err := tx.Omit("Items").Save(priceList).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("price_list_id = ?", priceList.ID).Delete(&models.PriceListItem{}).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].PriceListID = priceList.ID
+		}
+		if len(items) > 0 {
+			if err := tx.Create(&items).Error; err != nil {
+				return err
+			}
+		}
+		priceList.Items = items
+
+		unassign := tx.Model(&models.Company{}).Where("price_list_id = ?", priceList.ID)
+		if len(companyIDs) > 0 {
+			unassign = unassign.Where("id NOT IN ?", companyIDs)
+		}
+		if err := unassign.Update("price_list_id", nil).Error; err != nil {
+			return err
+		}
+		if len(companyIDs) == 0 {
+			return nil
+		}
+		return tx.Model(&models.Company{}).Where("id IN ?", companyIDs).Update("price_list_id", priceList.ID).Error
+	})
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScheduledPriceRequest struct {
+	BasePrice *float64   `json:"base_price" binding:"omitempty,min=0"`
+	B2BPrice  *float64   `json:"b2b_price" binding:"omitempty,min=0"`
+	CostPrice *float64   `json:"cost_price" binding:"omitempty,min=0"`
+	StartsAt  time.Time  `json:"starts_at" binding:"required"`
+	EndsAt    *time.Time `json:"ends_at"` // set for temporary sale prices
+	Reason    string     `json:"reason"`
+}
+
+func GetPriceHistory(c *gin.Context) {
+	var history []models.PriceHistory
+	query := database.GetDB().Preload("ChangedBy").
+		Where("product_id = ?", c.Param("id")).
+		Order("effective_at DESC, id DESC")
+
+	if from := c.Query("from"); from != "" {
+		at, err := parseDateParam(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		query = query.Where("effective_at >= ?", at)
+	}
+	if to := c.Query("to"); to != "" {
+		at, err := parseDateParam(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		query = query.Where("effective_at <= ?", at)
+	}
+
+	if err := query.Find(&history).Error; err != nil {
+		logger.Error.Printf("Failed to get price history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get price history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"price_history": history})
+}
+
+// GetPriceAt answers what a SKU cost at a given time, for dispute handling.
+// A bare date resolves to the price in force at the end of that day.
+func GetPriceAt(c *gin.Context) {
+	sku := c.Query("sku")
+	if sku == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sku is required"})
+		return
+	}
+	at, err := parseDateParam(c.Query("date"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD or RFC3339"})
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().Unscoped().Where("sku = ?", sku).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	prices, entry, err := services.PriceAt(database.GetDB(), &product, at)
+	if errors.Is(err, services.ErrNoPriceRecorded) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No price recorded for that date"})
+		return
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to get price at date: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sku":          product.SKU,
+		"product_id":   product.ID,
+		"at":           at,
+		"prices":       prices,
+		"price_change": entry,
+	})
+}
+
+func GetScheduledPriceChanges(c *gin.Context) {
+	var changes []models.ScheduledPriceChange
+	query := database.GetDB().Where("product_id = ?", c.Param("id")).Order("starts_at")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Find(&changes).Error; err != nil {
+		logger.Error.Printf("Failed to get scheduled prices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scheduled prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"scheduled_prices": changes})
+}
+
+func CreateScheduledPriceChange(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req ScheduledPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.BasePrice == nil && req.B2BPrice == nil && req.CostPrice == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one price is required"})
+		return
+	}
+	if req.EndsAt != nil && !req.EndsAt.After(req.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return
+	}
+	if req.EndsAt != nil && req.EndsAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at is in the past"})
+		return
+	}
+
+	change := models.ScheduledPriceChange{
+		ProductID:   product.ID,
+		BasePrice:   req.BasePrice,
+		B2BPrice:    req.B2BPrice,
+		CostPrice:   req.CostPrice,
+		StartsAt:    req.StartsAt,
+		EndsAt:      req.EndsAt,
+		Status:      models.ScheduledPriceStatusPending,
+		Reason:      req.Reason,
+		CreatedByID: currentUserIDPtr(c),
+	}
+
+	if err := database.GetDB().Create(&change).Error; err != nil {
+		logger.Error.Printf("Failed to create scheduled price: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule price change"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":         "Price change scheduled successfully",
+		"scheduled_price": change,
+	})
+}
+
+// CancelScheduledPriceChange cancels a pending change, or ends a running
+// sale immediately and restores the previous prices
+func CancelScheduledPriceChange(c *gin.Context) {
+	var change models.ScheduledPriceChange
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		First(&change, c.Param("scheduleId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled price not found"})
+		return
+	}
+
+	var err error
+	switch change.Status {
+	case models.ScheduledPriceStatusPending:
+		err = database.GetDB().Model(&change).
+			Where("status = ?", models.ScheduledPriceStatusPending).
+			Update("status", models.ScheduledPriceStatusCancelled).Error
+	case models.ScheduledPriceStatusActive:
+		err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			if err := tx.Model(&change).Update("ends_at", now).Error; err != nil {
+				return err
+			}
+			return services.EndSale(tx, change.ID, now)
+		})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduled price has already finished"})
+		return
+	}
+
+	if err != nil {
+		logger.Error.Printf("Failed to cancel scheduled price: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scheduled price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled price cancelled successfully"})
+}
+
+// parseDateParam accepts RFC3339 or a bare date; bare dates resolve to the
+// start of the day, or its last instant when endOfDay is set
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
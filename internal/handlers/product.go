@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"fmt"
 	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/barcode"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
+	"marketprogo/pkg/units"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
 )
 
 type CreateProductRequest struct {
@@ -20,6 +25,8 @@ type CreateProductRequest struct {
 	CostPrice   float64  `json:"cost_price"`
 	Weight      float64  `json:"weight"`
 	WeightUnit  string   `json:"weight_unit"`
+	ABCClass    string   `json:"abc_class" binding:"omitempty,oneof=A B C"`
+	TaxClassID  *uint    `json:"tax_class_id"`
 	CategoryIDs []uint   `json:"category_ids"`
 	Images      []string `json:"images"`
 }
@@ -34,6 +41,8 @@ type UpdateProductRequest struct {
 	Weight      float64  `json:"weight"`
 	WeightUnit  string   `json:"weight_unit"`
 	IsActive    *bool    `json:"is_active"`
+	ABCClass    string   `json:"abc_class" binding:"omitempty,oneof=A B C"`
+	TaxClassID  *uint    `json:"tax_class_id"`
 	CategoryIDs []uint   `json:"category_ids"`
 	Images      []string `json:"images"`
 }
@@ -53,12 +62,43 @@ func GetProducts(c *gin.Context) {
 		query = query.Where("is_active = ?", active)
 	}
 
+	// Full-text search in the request locale's index
+	locales := requestLocales(c)
+	if q := c.Query("q"); q != "" {
+		query = query.Where("products.id IN (?)", services.SearchProductIDs(database.GetDB(), q, locales[0]))
+	}
+
+	// Numeric specification ranges, e.g. spec=weight:0.5:2:kg
+	for _, raw := range c.QueryArray("spec") {
+		filter, err := parseSpecFilter(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query, err = applySpecFilter(query, filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	if err := query.Find(&products).Error; err != nil {
 		logger.Error.Printf("Failed to get products: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
 		return
 	}
 
+	if err := services.LocalizeProducts(database.GetDB(), products, locales); err != nil {
+		logger.Error.Printf("Failed to localize products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+		return
+	}
+	if err := services.AttachAvailability(database.GetDB(), products); err != nil {
+		logger.Error.Printf("Failed to get product availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get products"})
+		return
+	}
+
+	c.Header("Content-Language", locales[0])
 	c.JSON(http.StatusOK, gin.H{"products": products})
 }
 
@@ -69,14 +109,28 @@ func GetProduct(c *gin.Context) {
 	if err := database.GetDB().Preload("Categories").
 		Preload("Images").
 		Preload("Specifications").
-		Preload("InventoryItems").
+		Preload("Prices").
 		First(&product, id).Error; err != nil {
 		logger.Error.Printf("Failed to get product: %v", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"product": product})
+	locales := requestLocales(c)
+	products := []models.Product{product}
+	if err := services.LocalizeProducts(database.GetDB(), products, locales); err != nil {
+		logger.Error.Printf("Failed to localize product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product"})
+		return
+	}
+	if err := services.AttachAvailability(database.GetDB(), products); err != nil {
+		logger.Error.Printf("Failed to get product availability: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product"})
+		return
+	}
+
+	c.Header("Content-Language", products[0].Locale)
+	c.JSON(http.StatusOK, gin.H{"product": products[0]})
 }
 
 func CreateProduct(c *gin.Context) {
@@ -86,6 +140,20 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	req.Barcode = barcode.Normalize(req.Barcode)
+	if !validateProductBarcode(c, req.Barcode, 0) {
+		return
+	}
+
+	weightUnit, err := normalizeWeightUnit(req.WeightUnit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TaxClassID != nil && !validateTaxClass(c, *req.TaxClassID) {
+		return
+	}
+
 	product := models.Product{
 		Name:        req.Name,
 		Description: req.Description,
@@ -95,7 +163,9 @@ func CreateProduct(c *gin.Context) {
 		B2BPrice:    req.B2BPrice,
 		CostPrice:   req.CostPrice,
 		Weight:      req.Weight,
-		WeightUnit:  req.WeightUnit,
+		WeightUnit:  weightUnit,
+		ABCClass:    req.ABCClass,
+		TaxClassID:  req.TaxClassID,
 		IsActive:    true,
 	}
 	// Create images
@@ -117,6 +187,26 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	// Record the opening prices so price lookups have a starting point
+	if err := services.RecordPriceChange(tx, product.ID, services.Prices{}, services.CurrentPrices(&product), services.PriceChange{
+		Source:      models.PriceChangeSourceCreated,
+		ChangedByID: currentUserIDPtr(c),
+	}); err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to record product prices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
+
+	// QR codes are rendered on demand, so store where to fetch them
+	product.QRCode = fmt.Sprintf("/api/products/%d/qrcode", product.ID)
+	if err := tx.Model(&product).Update("qr_code", product.QRCode).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to set product QR code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
+
 	// Associate categories
 	if len(req.CategoryIDs) > 0 {
 		var categories []models.Category
@@ -134,6 +224,13 @@ func CreateProduct(c *gin.Context) {
 		}
 	}
 
+	if err := services.IndexProduct(tx, product.ID); err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to index product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
+
 	tx.Commit()
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -142,57 +239,99 @@ func CreateProduct(c *gin.Context) {
 	})
 }
 
+// UpdateProduct changes the fields given. The product is locked for the
+// rest of the transaction and only changed columns are written, so a
+// scheduled price change applied meanwhile is neither lost nor
+// misrecorded.
 func UpdateProduct(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
 	var req UpdateProductRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var product models.Product
-	if err := database.GetDB().First(&product, id).Error; err != nil {
-		logger.Error.Printf("Failed to find product: %v", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-		return
-	}
-
-	// Update fields
+	// Validate before locking the product
+	updates := map[string]interface{}{}
 	if req.Name != "" {
-		product.Name = req.Name
+		updates["name"] = req.Name
 	}
 	if req.Description != "" {
-		product.Description = req.Description
+		updates["description"] = req.Description
 	}
 	if req.Barcode != "" {
-		product.Barcode = req.Barcode
-	}
-	if req.BasePrice != 0 {
-		product.BasePrice = req.BasePrice
-	}
-	if req.B2BPrice != 0 {
-		product.B2BPrice = req.B2BPrice
-	}
-	if req.CostPrice != 0 {
-		product.CostPrice = req.CostPrice
+		req.Barcode = barcode.Normalize(req.Barcode)
+		if !validateProductBarcode(c, req.Barcode, uint(id)) {
+			return
+		}
+		updates["barcode"] = req.Barcode
 	}
 	if req.Weight != 0 {
-		product.Weight = req.Weight
+		updates["weight"] = req.Weight
 	}
 	if req.WeightUnit != "" {
-		product.WeightUnit = req.WeightUnit
+		weightUnit, err := normalizeWeightUnit(req.WeightUnit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["weight_unit"] = weightUnit
 	}
 	if req.IsActive != nil {
-		product.IsActive = *req.IsActive
+		updates["is_active"] = *req.IsActive
+	}
+	if req.ABCClass != "" {
+		updates["abc_class"] = req.ABCClass
+	}
+	if req.TaxClassID != nil {
+		if !validateTaxClass(c, *req.TaxClassID) {
+			return
+		}
+		updates["tax_class_id"] = *req.TaxClassID
 	}
 
 	// Start transaction
 	tx := database.GetDB().Begin()
 
-	// Update product
-	if err := tx.Save(&product).Error; err != nil {
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to find product: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(&product).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to update product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+			return
+		}
+	}
+
+	// Prices go through the same path as scheduled changes, against the
+	// prices read under the lock
+	prices := services.CurrentPrices(&product)
+	if req.BasePrice != 0 {
+		prices.BasePrice = req.BasePrice
+	}
+	if req.B2BPrice != 0 {
+		prices.B2BPrice = req.B2BPrice
+	}
+	if req.CostPrice != 0 {
+		prices.CostPrice = req.CostPrice
+	}
+	if err := services.ChangePrices(tx, &product, prices, services.PriceChange{
+		Source:      models.PriceChangeSourceManual,
+		ChangedByID: currentUserIDPtr(c),
+	}); err != nil {
 		tx.Rollback()
-		logger.Error.Printf("Failed to update product: %v", err)
+		logger.Error.Printf("Failed to change prices: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
@@ -214,6 +353,13 @@ func UpdateProduct(c *gin.Context) {
 		}
 	}
 
+	if err := services.IndexProduct(tx, product.ID); err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to index product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
 	tx.Commit()
 
 	c.JSON(http.StatusOK, gin.H{
@@ -232,12 +378,47 @@ func DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	// Soft delete
-	if err := database.GetDB().Delete(&product).Error; err != nil {
-		logger.Error.Printf("Failed to delete product: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
-		return
+	// Soft delete, reporting dependents per the policy query parameter
+	trashRecord(c, services.TrashKindProduct, product.ID)
+}
+
+// validateProductBarcode checks the GTIN check digit and that no other
+// product already carries the code, writing the error response if not
+func validateProductBarcode(c *gin.Context, code string, productID uint) bool {
+	if code == "" {
+		return true
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+	if err := barcode.ValidateGTIN(code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid barcode: " + err.Error()})
+		return false
+	}
+
+	var count int64
+	if err := database.GetDB().Model(&models.Product{}).
+		Where("barcode = ? AND id <> ?", code, productID).
+		Count(&count).Error; err != nil {
+		logger.Error.Printf("Failed to check barcode: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate barcode"})
+		return false
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Barcode already assigned to another product"})
+		return false
+	}
+
+	return true
+}
+
+// normalizeWeightUnit maps spellings like "kgs" or "pounds" onto the unit
+// symbols the units package converts between
+func normalizeWeightUnit(unit string) (string, error) {
+	if unit == "" {
+		return "", nil
+	}
+	parsed, err := units.Lookup(unit)
+	if err != nil || parsed.Dimension != units.DimensionMass {
+		return "", fmt.Errorf("weight_unit %q is not a mass unit", unit)
+	}
+	return parsed.Symbol, nil
 }
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PromotionRequest struct {
+	Name           string     `json:"name" binding:"required"`
+	Description    string     `json:"description"`
+	Type           string     `json:"type" binding:"required,oneof=PERCENTAGE FIXED_AMOUNT BUY_X_GET_Y FREE_SHIPPING"`
+	Scope          string     `json:"scope" binding:"omitempty,oneof=ORDER CATEGORY PRODUCT"` // defaults to ORDER
+	Value          float64    `json:"value" binding:"min=0"`
+	BuyQuantity    int        `json:"buy_quantity" binding:"min=0"`
+	GetQuantity    int        `json:"get_quantity" binding:"min=0"`
+	ProductIDs     []uint     `json:"product_ids"`
+	CategoryIDs    []uint     `json:"category_ids"`
+	MinSpend       float64    `json:"min_spend" binding:"min=0"`
+	CustomerType   string     `json:"customer_type" binding:"omitempty,oneof=B2C B2B"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	IsActive       *bool      `json:"is_active"`
+	RequiresCoupon bool       `json:"requires_coupon"`
+	Stackable      bool       `json:"stackable"`
+	Priority       int        `json:"priority"`
+}
+
+type CreateCouponsRequest struct {
+	Codes                 []string `json:"codes" binding:"required,min=1,dive,required,max=50"`
+	UsageLimit            *int     `json:"usage_limit" binding:"omitempty,min=1"`
+	UsageLimitPerCustomer *int     `json:"usage_limit_per_customer" binding:"omitempty,min=1"`
+}
+
+type UpdateCouponRequest struct {
+	UsageLimit            *int  `json:"usage_limit" binding:"omitempty,min=1"`
+	UsageLimitPerCustomer *int  `json:"usage_limit_per_customer" binding:"omitempty,min=1"`
+	IsActive              *bool `json:"is_active"`
+}
+
+// GetPromotions lists promotions with their coupons, filtered by
+// ?active=true to those running now
+func GetPromotions(c *gin.Context) {
+	query := database.GetDB().Preload("Products").Preload("Categories").Preload("Coupons").
+		Order("priority DESC, id")
+	if c.Query("active") == "true" {
+		now := time.Now()
+		query = query.Where("is_active AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", now, now)
+	}
+
+	var promotions []models.Promotion
+	if err := query.Find(&promotions).Error; err != nil {
+		logger.Error.Printf("Failed to get promotions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get promotions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promotions": promotions})
+}
+
+func CreatePromotion(c *gin.Context) {
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promotion := models.Promotion{IsActive: true}
+	if !applyPromotionRequest(c, &promotion, &req) {
+		return
+	}
+	if err := savePromotion(&promotion); err != nil {
+		logger.Error.Printf("Failed to create promotion: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Promotion created successfully",
+		"promotion": promotion,
+	})
+}
+
+// UpdatePromotion changes a promotion. Orders already placed keep the
+// discounts they were given.
+func UpdatePromotion(c *gin.Context) {
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var promotion models.Promotion
+	if err := database.GetDB().First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	if !applyPromotionRequest(c, &promotion, &req) {
+		return
+	}
+	if err := savePromotion(&promotion); err != nil {
+		logger.Error.Printf("Failed to update promotion: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Promotion updated successfully",
+		"promotion": promotion,
+	})
+}
+
+func applyPromotionRequest(c *gin.Context, promotion *models.Promotion, req *PromotionRequest) bool {
+	promotionType := models.PromotionType(req.Type)
+	scope := models.PromotionScope(req.Scope)
+	if scope == "" {
+		scope = models.PromotionScopeOrder
+	}
+
+	switch promotionType {
+	case models.PromotionTypePercentage:
+		if req.Value <= 0 || req.Value > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Percentage must be above 0 and at most 100"})
+			return false
+		}
+	case models.PromotionTypeFixedAmount:
+		if req.Value <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fixed amount must be above 0"})
+			return false
+		}
+	case models.PromotionTypeBuyXGetY:
+		if req.BuyQuantity < 1 || req.GetQuantity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "buy_quantity and get_quantity must be at least 1"})
+			return false
+		}
+		// Value is the discount on the free units; they are free by default
+		if req.Value == 0 {
+			req.Value = 100
+		}
+		if req.Value > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Percentage must be at most 100"})
+			return false
+		}
+	case models.PromotionTypeFreeShipping:
+		req.Value = 0
+	}
+	if promotionType != models.PromotionTypeBuyXGetY {
+		req.BuyQuantity, req.GetQuantity = 0, 0
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return false
+	}
+
+	var products []models.Product
+	var categories []models.Category
+	switch scope {
+	case models.PromotionScopeProduct:
+		if len(req.ProductIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product_ids is required for product promotions"})
+			return false
+		}
+		if err := database.GetDB().Where("id IN ?", req.ProductIDs).Find(&products).Error; err != nil {
+			logger.Error.Printf("Failed to check promotion products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate promotion"})
+			return false
+		}
+		if len(products) != len(uniqueIDs(req.ProductIDs)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+			return false
+		}
+	case models.PromotionScopeCategory:
+		if len(req.CategoryIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "category_ids is required for category promotions"})
+			return false
+		}
+		if err := database.GetDB().Where("id IN ?", req.CategoryIDs).Find(&categories).Error; err != nil {
+			logger.Error.Printf("Failed to check promotion categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate promotion"})
+			return false
+		}
+		if len(categories) != len(uniqueIDs(req.CategoryIDs)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return false
+		}
+	}
+
+	promotion.Name = req.Name
+	promotion.Description = req.Description
+	promotion.Type = promotionType
+	promotion.Scope = scope
+	promotion.Value = req.Value
+	promotion.BuyQuantity = req.BuyQuantity
+	promotion.GetQuantity = req.GetQuantity
+	promotion.Products = products
+	promotion.Categories = categories
+	promotion.MinSpend = req.MinSpend
+	promotion.CustomerType = models.UserType(req.CustomerType)
+	promotion.StartsAt = req.StartsAt
+	promotion.EndsAt = req.EndsAt
+	if req.IsActive != nil {
+		promotion.IsActive = *req.IsActive
+	}
+	promotion.RequiresCoupon = req.RequiresCoupon
+	promotion.Stackable = req.Stackable
+	promotion.Priority = req.Priority
+	return true
+}
+
+// savePromotion saves the promotion and replaces the products and
+// categories it covers
+func savePromotion(promotion *models.Promotion) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		products, categories := promotion.Products, promotion.Categories
+		if err := tx.Omit("Products", "Categories", "Coupons").Save(promotion).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(promotion).Association("Products").Replace(products); err != nil {
+			return err
+		}
+		return tx.Model(promotion).Association("Categories").Replace(categories)
+	})
+}
+
+// CreateCoupons adds codes to a promotion. Codes are stored upper-case and
+// must be unused, including by deleted coupons.
+func CreateCoupons(c *gin.Context) {
+	var req CreateCouponsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db := database.GetDB()
+	var promotion models.Promotion
+	if err := db.First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	coupons := make([]models.Coupon, 0, len(req.Codes))
+	codes := make([]string, 0, len(req.Codes))
+	seen := make(map[string]bool)
+	for _, code := range req.Codes {
+		code = services.NormalizeCouponCode(code)
+		if code == "" || seen[code] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon codes must be unique and not blank"})
+			return
+		}
+		seen[code] = true
+		codes = append(codes, code)
+		coupons = append(coupons, models.Coupon{
+			PromotionID:           promotion.ID,
+			Code:                  code,
+			UsageLimit:            req.UsageLimit,
+			UsageLimitPerCustomer: req.UsageLimitPerCustomer,
+			IsActive:              true,
+		})
+	}
+
+	var taken []string
+	if err := db.Unscoped().Model(&models.Coupon{}).Where("code IN ?", codes).Pluck("code", &taken).Error; err != nil {
+		logger.Error.Printf("Failed to check coupon codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupons"})
+		return
+	}
+	if len(taken) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon codes already exist", "codes": taken})
+		return
+	}
+
+	if err := db.Create(&coupons).Error; err != nil {
+		logger.Error.Printf("Failed to create coupons: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupons"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Coupons created successfully",
+		"coupons": coupons,
+	})
+}
+
+// UpdateCoupon changes a coupon's limits or deactivates it. Limits left
+// out of the request are removed.
+func UpdateCoupon(c *gin.Context) {
+	var req UpdateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var coupon models.Coupon
+	if err := database.GetDB().First(&coupon, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	coupon.UsageLimit = req.UsageLimit
+	coupon.UsageLimitPerCustomer = req.UsageLimitPerCustomer
+	if req.IsActive != nil {
+		coupon.IsActive = *req.IsActive
+	}
+	// Leave usage_count to the orders redeeming the coupon
+	if err := database.GetDB().Model(&coupon).Select("usage_limit", "usage_limit_per_customer", "is_active").
+		Updates(&coupon).Error; err != nil {
+		logger.Error.Printf("Failed to update coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Coupon updated successfully",
+		"coupon":  coupon,
+	})
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"marketprogo/internal/models"
+	"marketprogo/internal/services"
 	"marketprogo/pkg/database"
 	"marketprogo/pkg/logger"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreatePurchaseOrderRequest struct {
@@ -16,6 +19,7 @@ type CreatePurchaseOrderRequest struct {
 	Currency       string          `json:"currency" binding:"required"`
 	ExchangeRate   float64         `json:"exchange_rate" binding:"required"`
 	ShippingMethod string          `json:"shipping_method" binding:"required"`
+	WarehouseID    *uint           `json:"warehouse_id"`
 	Notes          string          `json:"notes"`
 	Items          []POItemRequest `json:"items" binding:"required,min=1"`
 }
@@ -79,12 +83,34 @@ func CreatePurchaseOrder(c *gin.Context) {
 		return
 	}
 
+	if req.WarehouseID != nil {
+		var warehouse models.Warehouse
+		if err := database.GetDB().First(&warehouse, *req.WarehouseID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Warehouse not found"})
+			return
+		}
+	}
+
 	// Start transaction
 	tx := database.GetDB().Begin()
 
+	taxContext, err := services.PurchaseTaxContext(tx, req.SupplierID, req.WarehouseID, time.Now())
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found"})
+			return
+		}
+		logger.Error.Printf("Failed to work out purchase order tax: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order"})
+		return
+	}
+	taxes := services.NewTaxCalculator(tx, taxContext)
+
 	// Create purchase order
 	po := models.PurchaseOrder{
 		SupplierID:     req.SupplierID,
+		WarehouseID:    req.WarehouseID,
 		Status:         models.POStatusDraft,
 		OrderDate:      time.Now(),
 		ExpectedDate:   req.ExpectedDate,
@@ -96,7 +122,7 @@ func CreatePurchaseOrder(c *gin.Context) {
 
 	// Create PO items
 	var items []models.POItem
-	var totalAmount float64
+	var totalAmount, taxAmount float64
 
 	for _, itemReq := range req.Items {
 		var product models.Product
@@ -111,16 +137,33 @@ func CreatePurchaseOrder(c *gin.Context) {
 			ProductID:   product.ID,
 			Quantity:    itemReq.Quantity,
 			UnitPrice:   itemReq.UnitPrice,
-			TotalAmount: itemReq.UnitPrice * float64(itemReq.Quantity),
+			TotalAmount: services.RoundMoney(itemReq.UnitPrice * float64(itemReq.Quantity)),
 			Status:      "pending",
 		}
+		if item.LineTax, err = taxes.Line(product.TaxClassID, item.TotalAmount); err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to work out purchase order tax: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order"})
+			return
+		}
 
 		items = append(items, item)
 		totalAmount += item.TotalAmount
+		taxAmount += item.TaxAmount
 	}
 
-	po.TotalAmount = totalAmount
-	po.FinalAmount = totalAmount // Add shipping and tax calculations here
+	po.TotalAmount = services.RoundMoney(totalAmount)
+	po.TaxAmount = services.RoundMoney(taxAmount)
+	po.FinalAmount = services.RoundMoney(po.TotalAmount + po.TaxAmount)
+
+	number, err := services.NextNumber(tx, models.SequenceTypePurchaseOrder, po.OrderDate)
+	if err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to number purchase order: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order"})
+		return
+	}
+	po.PONumber = number
 
 	// Create purchase order
 	if err := tx.Create(&po).Error; err != nil {
@@ -200,6 +243,13 @@ func UpdatePurchaseOrder(c *gin.Context) {
 		return
 	}
 
+	// Status and expected date change what is promised as inbound
+	var productIDs []uint
+	if err := database.GetDB().Model(&models.POItem{}).Where("po_id = ?", po.ID).Pluck("product_id", &productIDs).Error; err != nil {
+		logger.Error.Printf("Failed to get purchase order items: %v", err)
+	}
+	services.InvalidateAvailability(productIDs...)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Purchase order updated successfully",
 		"purchase_order": po,
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProductRelationRequest struct {
+	RelatedProductID uint                `json:"related_product_id" binding:"required"`
+	Type             models.RelationType `json:"type" binding:"required,oneof=RELATED ACCESSORY REPLACEMENT UPSELL"`
+	Position         int                 `json:"position"`
+}
+
+// Recommendation is a product suggested for another, either curated or mined
+type Recommendation struct {
+	Product      models.Product      `json:"product"`
+	Type         models.RelationType `json:"type,omitempty"`
+	Score        float64             `json:"score,omitempty"`
+	CoOrderCount int                 `json:"co_order_count,omitempty"`
+}
+
+func GetProductRelations(c *gin.Context) {
+	var relations []models.ProductRelation
+	if err := database.GetDB().Preload("RelatedProduct").
+		Where("product_id = ?", c.Param("id")).
+		Order("type, position, id").
+		Find(&relations).Error; err != nil {
+		logger.Error.Printf("Failed to get product relations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get product relations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"relations": relations})
+}
+
+func CreateProductRelation(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req ProductRelationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RelatedProductID == product.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A product cannot be related to itself"})
+		return
+	}
+
+	var related models.Product
+	if err := database.GetDB().First(&related, req.RelatedProductID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Related product not found"})
+		return
+	}
+
+	var existing int64
+	database.GetDB().Model(&models.ProductRelation{}).
+		Where("product_id = ? AND related_product_id = ? AND type = ?", product.ID, related.ID, req.Type).
+		Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Relation already exists"})
+		return
+	}
+
+	relation := models.ProductRelation{
+		ProductID:        product.ID,
+		RelatedProductID: related.ID,
+		Type:             req.Type,
+		Position:         req.Position,
+	}
+	if err := database.GetDB().Create(&relation).Error; err != nil {
+		logger.Error.Printf("Failed to create product relation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product relation"})
+		return
+	}
+
+	relation.RelatedProduct = &related
+	c.JSON(http.StatusCreated, relation)
+}
+
+func DeleteProductRelation(c *gin.Context) {
+	result := database.GetDB().Unscoped().
+		Where("id = ? AND product_id = ?", c.Param("relationId"), c.Param("id")).
+		Delete(&models.ProductRelation{})
+	if result.Error != nil {
+		logger.Error.Printf("Failed to delete product relation: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product relation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Relation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Relation deleted successfully"})
+}
+
+// GetProductRecommendations returns curated relations grouped by type and
+// the products most often bought together with this one
+func GetProductRecommendations(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+	minOrders, _ := strconv.Atoi(c.DefaultQuery("min_orders", "2"))
+	if minOrders < 1 {
+		minOrders = 1
+	}
+
+	var relations []models.ProductRelation
+	if err := database.GetDB().Joins("RelatedProduct").
+		Where("product_relations.product_id = ? AND \"RelatedProduct\".is_active = ?", product.ID, true).
+		Order("product_relations.type, product_relations.position, product_relations.id").
+		Find(&relations).Error; err != nil {
+		logger.Error.Printf("Failed to get product relations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recommendations"})
+		return
+	}
+
+	affinities, err := services.FrequentlyBoughtTogether(database.GetDB(), product.ID, minOrders, limit)
+	if err != nil {
+		logger.Error.Printf("Failed to get product affinities: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recommendations"})
+		return
+	}
+
+	var minedIDs []uint
+	for _, affinity := range affinities {
+		minedIDs = append(minedIDs, affinity.RelatedProductID)
+	}
+	var mined []models.Product
+	if len(minedIDs) > 0 {
+		if err := database.GetDB().Where("id IN ? AND is_active = ?", minedIDs, true).
+			Find(&mined).Error; err != nil {
+			logger.Error.Printf("Failed to get recommended products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recommendations"})
+			return
+		}
+	}
+
+	// Localize curated and mined products in one pass
+	products := make([]models.Product, 0, len(relations)+len(mined))
+	for _, relation := range relations {
+		products = append(products, *relation.RelatedProduct)
+	}
+	products = append(products, mined...)
+	locales := requestLocales(c)
+	if err := services.LocalizeProducts(database.GetDB(), products, locales); err != nil {
+		logger.Error.Printf("Failed to localize recommendations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recommendations"})
+		return
+	}
+
+	curated := make(map[models.RelationType][]Recommendation)
+	for i, relation := range relations {
+		curated[relation.Type] = append(curated[relation.Type], Recommendation{
+			Product: products[i],
+			Type:    relation.Type,
+		})
+	}
+
+	byID := make(map[uint]models.Product)
+	for _, p := range products[len(relations):] {
+		byID[p.ID] = p
+	}
+	boughtTogether := make([]Recommendation, 0, len(affinities))
+	for _, affinity := range affinities {
+		if p, ok := byID[affinity.RelatedProductID]; ok {
+			boughtTogether = append(boughtTogether, Recommendation{
+				Product:      p,
+				Score:        affinity.Score,
+				CoOrderCount: affinity.CoOrderCount,
+			})
+		}
+	}
+
+	c.Header("Content-Language", locales[0])
+	c.JSON(http.StatusOK, gin.H{
+		"related":                    curated[models.RelationTypeRelated],
+		"accessories":                curated[models.RelationTypeAccessory],
+		"replacements":               curated[models.RelationTypeReplacement],
+		"upsells":                    curated[models.RelationTypeUpsell],
+		"frequently_bought_together": boughtTogether,
+	})
+}
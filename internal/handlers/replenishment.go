@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateReorderRuleRequest struct {
+	ProductID       uint  `json:"product_id" binding:"required"`
+	WarehouseID     uint  `json:"warehouse_id" binding:"required"`
+	ReorderPoint    int   `json:"reorder_point" binding:"min=0"`
+	SafetyStock     int   `json:"safety_stock" binding:"min=0,ltefield=ReorderPoint"`
+	ReorderQuantity int   `json:"reorder_quantity" binding:"required,min=1"`
+	SupplierID      *uint `json:"supplier_id"`
+	IsActive        *bool `json:"is_active"`
+}
+
+type UpdateReorderRuleRequest struct {
+	ReorderPoint    *int  `json:"reorder_point" binding:"omitempty,min=0"`
+	SafetyStock     *int  `json:"safety_stock" binding:"omitempty,min=0"`
+	ReorderQuantity *int  `json:"reorder_quantity" binding:"omitempty,min=1"`
+	SupplierID      *uint `json:"supplier_id"`
+	ClearSupplier   bool  `json:"clear_supplier"`
+	IsActive        *bool `json:"is_active"`
+}
+
+func GetReorderRules(c *gin.Context) {
+	var rules []models.ReorderRule
+	query := database.GetDB().Preload("Product").Preload("Supplier").Order("warehouse_id, product_id")
+
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+	if supplierID := c.Query("supplier_id"); supplierID != "" {
+		query = query.Where("supplier_id = ?", supplierID)
+	}
+
+	if err := query.Find(&rules).Error; err != nil {
+		logger.Error.Printf("Failed to get reorder rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reorder rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reorder_rules": rules})
+}
+
+func CreateReorderRule(c *gin.Context) {
+	var req CreateReorderRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().First(&product, req.ProductID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.IsBundle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bundles are stocked as their components"})
+		return
+	}
+	var warehouse models.Warehouse
+	if err := database.GetDB().First(&warehouse, req.WarehouseID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Warehouse not found"})
+		return
+	}
+	if req.SupplierID != nil && !supplierExists(c, *req.SupplierID) {
+		return
+	}
+
+	var existing int64
+	if err := database.GetDB().Model(&models.ReorderRule{}).
+		Where("product_id = ? AND warehouse_id = ?", product.ID, warehouse.ID).
+		Count(&existing).Error; err != nil {
+		logger.Error.Printf("Failed to check reorder rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reorder rule"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product already has a reorder rule for this warehouse"})
+		return
+	}
+
+	rule := models.ReorderRule{
+		ProductID:       product.ID,
+		WarehouseID:     warehouse.ID,
+		ReorderPoint:    req.ReorderPoint,
+		SafetyStock:     req.SafetyStock,
+		ReorderQuantity: req.ReorderQuantity,
+		SupplierID:      req.SupplierID,
+		IsActive:        true,
+	}
+	if err := database.GetDB().Create(&rule).Error; err != nil {
+		logger.Error.Printf("Failed to create reorder rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reorder rule"})
+		return
+	}
+	// An explicit inactive rule needs its own update; false is the zero value
+	if req.IsActive != nil && !*req.IsActive {
+		if err := database.GetDB().Model(&rule).Update("is_active", false).Error; err != nil {
+			logger.Error.Printf("Failed to create reorder rule: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reorder rule"})
+			return
+		}
+	}
+
+	rule.Product = product
+	c.JSON(http.StatusCreated, rule)
+}
+
+func UpdateReorderRule(c *gin.Context) {
+	var rule models.ReorderRule
+	if err := database.GetDB().First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reorder rule not found"})
+		return
+	}
+
+	var req UpdateReorderRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.ReorderPoint != nil {
+		rule.ReorderPoint = *req.ReorderPoint
+		updates["reorder_point"] = rule.ReorderPoint
+	}
+	if req.SafetyStock != nil {
+		rule.SafetyStock = *req.SafetyStock
+		updates["safety_stock"] = rule.SafetyStock
+	}
+	if rule.SafetyStock > rule.ReorderPoint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Safety stock cannot exceed the reorder point"})
+		return
+	}
+	if req.ReorderQuantity != nil {
+		updates["reorder_quantity"] = *req.ReorderQuantity
+	}
+	if req.ClearSupplier {
+		updates["supplier_id"] = nil
+	} else if req.SupplierID != nil {
+		if !supplierExists(c, *req.SupplierID) {
+			return
+		}
+		updates["supplier_id"] = *req.SupplierID
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if len(updates) > 0 {
+		if err := database.GetDB().Model(&rule).Updates(updates).Error; err != nil {
+			logger.Error.Printf("Failed to update reorder rule: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reorder rule"})
+			return
+		}
+	}
+
+	if err := database.GetDB().Preload("Product").Preload("Supplier").First(&rule, rule.ID).Error; err != nil {
+		logger.Error.Printf("Failed to reload reorder rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reorder rule"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func DeleteReorderRule(c *gin.Context) {
+	var rule models.ReorderRule
+	if err := database.GetDB().First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reorder rule not found"})
+		return
+	}
+
+	// Rules are configuration, so they go for good; the unique product and
+	// warehouse pair must be free for a new rule. Their alerts are closed.
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.StockAlert{}).
+			Where("reorder_rule_id = ? AND status <> ?", rule.ID, models.StockAlertStatusResolved).
+			Updates(map[string]interface{}{"status": models.StockAlertStatusResolved, "resolved_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&rule).Error
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to delete reorder rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reorder rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reorder rule deleted successfully"})
+}
+
+// GetStockAlerts lists stock alerts, by default those not yet resolved
+func GetStockAlerts(c *gin.Context) {
+	var alerts []models.StockAlert
+	query := database.GetDB().Preload("Product").Order("level, updated_at DESC")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", models.StockAlertStatusResolved)
+	}
+	if level := c.Query("level"); level != "" {
+		query = query.Where("level = ?", level)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+
+	if err := query.Limit(500).Find(&alerts).Error; err != nil {
+		logger.Error.Printf("Failed to get stock alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stock alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// AcknowledgeStockAlert marks an open alert as seen. It reopens if the
+// shortage becomes critical.
+func AcknowledgeStockAlert(c *gin.Context) {
+	var alert models.StockAlert
+	if err := database.GetDB().First(&alert, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock alert not found"})
+		return
+	}
+	if alert.Status != models.StockAlertStatusOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only open alerts can be acknowledged"})
+		return
+	}
+
+	alert.Status = models.StockAlertStatusAcknowledged
+	alert.AcknowledgedBy = currentUserIDPtr(c)
+	if err := database.GetDB().Model(&alert).Updates(map[string]interface{}{
+		"status":          alert.Status,
+		"acknowledged_by": alert.AcknowledgedBy,
+	}).Error; err != nil {
+		logger.Error.Printf("Failed to acknowledge stock alert: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge stock alert"})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// RunStockCheck checks stock levels now instead of waiting for the job
+func RunStockCheck(c *gin.Context) {
+	if err := services.CheckStockLevels(database.GetDB(), time.Now()); err != nil {
+		logger.Error.Printf("Failed to check stock levels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock levels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock levels checked"})
+}
+
+// supplierExists writes a 400 when the supplier is missing
+func supplierExists(c *gin.Context, supplierID uint) bool {
+	var supplier models.Supplier
+	if err := database.GetDB().First(&supplier, supplierID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found"})
+		return false
+	}
+	return true
+}
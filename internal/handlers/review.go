@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CreateReviewRequest struct {
+	Rating int      `json:"rating" binding:"required,min=1,max=5"`
+	Title  string   `json:"title" binding:"required,max=200"`
+	Body   string   `json:"body" binding:"max=5000"`
+	Images []string `json:"images" binding:"max=10"`
+}
+
+type ReviewVoteRequest struct {
+	Helpful *bool `json:"helpful" binding:"required"`
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status" binding:"required,oneof=APPROVED REJECTED"`
+	Note   string `json:"note"`
+}
+
+func GetProductReviews(c *gin.Context) {
+	var reviews []models.Review
+	query := database.GetDB().Preload("Images").
+		Where("product_id = ? AND status = ?", c.Param("id"), models.ReviewStatusApproved)
+
+	if rating := c.Query("rating"); rating != "" {
+		query = query.Where("rating = ?", rating)
+	}
+
+	switch c.Query("sort") {
+	case "helpful":
+		query = query.Order("helpful_count DESC, created_at DESC")
+	case "rating":
+		query = query.Order("rating DESC, created_at DESC")
+	default:
+		query = query.Order("created_at DESC")
+	}
+
+	if err := query.Find(&reviews).Error; err != nil {
+		logger.Error.Printf("Failed to get reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reviews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+}
+
+func CreateProductReview(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req CreateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var user models.User
+	if err := database.GetDB().First(&user, userID).Error; err != nil {
+		logger.Error.Printf("Failed to find user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
+		return
+	}
+
+	// Only customers who received the product may review it
+	var orderItem models.OrderItem
+	err := database.GetDB().
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("orders.user_id = ? AND orders.status = ?", userID, models.OrderStatusDelivered).
+		Where("order_items.product_id = ? AND order_items.status = 'active'", product.ID).
+		Order("orders.delivered_date DESC").
+		First(&orderItem).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only customers who received this product can review it"})
+		return
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to check purchase: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
+		return
+	}
+
+	var existing int64
+	if err := database.GetDB().Model(&models.Review{}).
+		Where("product_id = ? AND user_id = ?", product.ID, userID).
+		Count(&existing).Error; err != nil {
+		logger.Error.Printf("Failed to check existing review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this product"})
+		return
+	}
+
+	review := models.Review{
+		ProductID:          product.ID,
+		UserID:             userID,
+		ReviewerName:       reviewerName(user),
+		OrderItemID:        &orderItem.ID,
+		Rating:             req.Rating,
+		Title:              req.Title,
+		Body:               req.Body,
+		Status:             models.ReviewStatusPending,
+		IsVerifiedPurchase: true,
+	}
+	for _, url := range req.Images {
+		review.Images = append(review.Images, models.ReviewImage{URL: url})
+	}
+
+	if err := database.GetDB().Create(&review).Error; err != nil {
+		logger.Error.Printf("Failed to create review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Review submitted for moderation",
+		"review":  review,
+	})
+}
+
+func VoteReview(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req ReviewVoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var review models.Review
+	if err := database.GetDB().Where("status = ?", models.ReviewStatusApproved).
+		First(&review, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+	if review.UserID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot vote on your own review"})
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		vote := models.ReviewVote{ReviewID: review.ID, UserID: userID, IsHelpful: *req.Helpful}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "review_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"is_helpful", "updated_at"}),
+		}).Create(&vote).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&review).UpdateColumns(map[string]interface{}{
+			"helpful_count": tx.Model(&models.ReviewVote{}).Select("COUNT(*)").
+				Where("review_id = ? AND is_helpful", review.ID),
+			"not_helpful_count": tx.Model(&models.ReviewVote{}).Select("COUNT(*)").
+				Where("review_id = ? AND NOT is_helpful", review.ID),
+		}).Error
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to record review vote: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record vote"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vote recorded successfully"})
+}
+
+func GetReviewQueue(c *gin.Context) {
+	status := c.DefaultQuery("status", string(models.ReviewStatusPending))
+	var reviews []models.Review
+
+	if err := database.GetDB().Preload("Images").
+		Preload("Product").
+		Where("status = ?", status).
+		Order("created_at").
+		Find(&reviews).Error; err != nil {
+		logger.Error.Printf("Failed to get review queue: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reviews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+}
+
+func ModerateReview(c *gin.Context) {
+	var req ModerateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var review models.Review
+	if err := database.GetDB().First(&review, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	}
+
+	now := time.Now()
+	review.Status = models.ReviewStatus(req.Status)
+	review.ModerationNote = req.Note
+	review.ModeratedAt = &now
+	review.ModeratedByID = currentUserIDPtr(c)
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&review).Error; err != nil {
+			return err
+		}
+		return refreshProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to moderate review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate review"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Review moderated successfully",
+		"review":  review,
+	})
+}
+
+// refreshProductRating recomputes the aggregate rating from approved reviews
+func refreshProductRating(tx *gorm.DB, productID uint) error {
+	var stats struct {
+		Average float64
+		Count   int
+	}
+	if err := tx.Model(&models.Review{}).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", productID, models.ReviewStatusApproved).
+		Scan(&stats).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Product{}).Where("id = ?", productID).UpdateColumns(map[string]interface{}{
+		"rating_average": math.Round(stats.Average*100) / 100,
+		"rating_count":   stats.Count,
+	}).Error
+}
+
+// reviewerName shows a first name and last initial rather than the account
+func reviewerName(user models.User) string {
+	name := strings.TrimSpace(user.FirstName)
+	if last := strings.TrimSpace(user.LastName); last != "" {
+		name += " " + string([]rune(last)[0]) + "."
+	}
+	return name
+}
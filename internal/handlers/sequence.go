@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateNumberSequenceRequest struct {
+	Pattern string `json:"pattern" binding:"required,max=100"`
+	Reset   string `json:"reset" binding:"required,oneof=NEVER YEARLY MONTHLY"`
+}
+
+func GetNumberSequences(c *gin.Context) {
+	sequences, err := services.NumberSequences(database.GetDB())
+	if err != nil {
+		logger.Error.Printf("Failed to get number sequences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get number sequences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sequences": sequences})
+}
+
+func UpdateNumberSequence(c *gin.Context) {
+	var req UpdateNumberSequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sequence *models.NumberSequence
+	if err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		sequence, err = services.UpdateNumberSequence(tx, models.SequenceType(c.Param("type")),
+			req.Pattern, models.SequenceReset(req.Reset), time.Now())
+		return err
+	}); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownSequence):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Number sequence not found"})
+		case errors.Is(err, services.ErrInvalidPattern):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			logger.Error.Printf("Failed to update number sequence: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update number sequence"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Number sequence updated successfully",
+		"sequence": sequence,
+	})
+}
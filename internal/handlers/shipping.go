@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ShippingMethodRequest struct {
+	Code                  string                `json:"code" binding:"required,max=30"`
+	Name                  string                `json:"name" binding:"required"`
+	Description           string                `json:"description"`
+	Carrier               string                `json:"carrier"`
+	IsActive              *bool                 `json:"is_active"`
+	RateBasis             string                `json:"rate_basis" binding:"required,oneof=WEIGHT VALUE ITEMS"`
+	SortOrder             int                   `json:"sort_order"`
+	FreeShippingThreshold *float64              `json:"free_shipping_threshold" binding:"omitempty,min=0"`
+	MinDeliveryDays       int                   `json:"min_delivery_days" binding:"min=0"`
+	MaxDeliveryDays       int                   `json:"max_delivery_days" binding:"min=0,gtefield=MinDeliveryDays"`
+	TaxClassID            *uint                 `json:"tax_class_id"`
+	Rates                 []ShippingRateRequest `json:"rates" binding:"dive"` // replaces the method's rates when given
+}
+
+type ShippingRateRequest struct {
+	ZoneID   uint     `json:"zone_id" binding:"required"`
+	MinValue float64  `json:"min_value" binding:"min=0"`
+	MaxValue *float64 `json:"max_value"`
+	Price    float64  `json:"price" binding:"min=0"`
+}
+
+type ShippingZoneRequest struct {
+	Name      string                        `json:"name" binding:"required"`
+	Locations []ShippingZoneLocationRequest `json:"locations" binding:"required,min=1,dive"`
+}
+
+type ShippingZoneLocationRequest struct {
+	Country        string `json:"country" binding:"required,len=2"`
+	PostcodePrefix string `json:"postcode_prefix"`
+}
+
+type ShippingQuoteRequest struct {
+	ShippingAddressID uint               `json:"shipping_address_id"`
+	Country           string             `json:"country" binding:"omitempty,len=2"`
+	PostalCode        string             `json:"postal_code"`
+	Currency          string             `json:"currency"` // defaults to the base currency
+	Items             []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+// GetShippingMethods lists the active methods. Admins get inactive methods
+// and rates with ?all=true.
+func GetShippingMethods(c *gin.Context) {
+	query := database.GetDB().Order("sort_order, id")
+	if c.Query("all") == "true" && isAdmin(c) {
+		query = query.Preload("Rates", func(db *gorm.DB) *gorm.DB {
+			return db.Order("zone_id, min_value")
+		})
+	} else {
+		query = query.Where("is_active")
+	}
+
+	var methods []models.ShippingMethod
+	if err := query.Find(&methods).Error; err != nil {
+		logger.Error.Printf("Failed to get shipping methods: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shipping methods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shipping_methods": methods})
+}
+
+// QuoteShipping lists the methods that can deliver the items to the
+// address, with their prices and delivery estimates
+func QuoteShipping(c *gin.Context) {
+	var req ShippingQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	country, postalCode := req.Country, req.PostalCode
+	if req.ShippingAddressID != 0 {
+		var address models.Address
+		if err := database.GetDB().First(&address, req.ShippingAddressID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping address not found"})
+			return
+		}
+		country, postalCode = address.Country, address.PostalCode
+	}
+	if country == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shipping_address_id or country is required"})
+		return
+	}
+
+	db := database.GetDB()
+	now := time.Now()
+	currency, err := services.ResolveCurrency(db, req.Currency)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+	rate, err := services.ExchangeRateAt(db, services.BaseCurrency(), currency, now)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	lines := orderLines(req.Items)
+	value, err := services.GoodsValue(db, lines)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+	quotes, err := services.ShippingQuotes(db, services.Shipment{
+		Lines:      lines,
+		Country:    country,
+		PostalCode: postalCode,
+		Value:      value,
+	}, now)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+	for i := range quotes {
+		quotes[i].Price = services.RoundMoney(quotes[i].Price * rate)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quotes":   quotes,
+		"currency": currency,
+	})
+}
+
+func CreateShippingMethod(c *gin.Context) {
+	var req ShippingMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	method := models.ShippingMethod{IsActive: true}
+	if !applyShippingMethodRequest(c, &method, &req) {
+		return
+	}
+	if err := saveShippingMethod(&method, req.Rates != nil); err != nil {
+		logger.Error.Printf("Failed to create shipping method: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":         "Shipping method created successfully",
+		"shipping_method": method,
+	})
+}
+
+func UpdateShippingMethod(c *gin.Context) {
+	var req ShippingMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var method models.ShippingMethod
+	if err := database.GetDB().Preload("Rates").First(&method, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+
+	if !applyShippingMethodRequest(c, &method, &req) {
+		return
+	}
+	if err := saveShippingMethod(&method, req.Rates != nil); err != nil {
+		logger.Error.Printf("Failed to update shipping method: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Shipping method updated successfully",
+		"shipping_method": method,
+	})
+}
+
+func applyShippingMethodRequest(c *gin.Context, method *models.ShippingMethod, req *ShippingMethodRequest) bool {
+	if req.TaxClassID != nil && !validateTaxClass(c, *req.TaxClassID) {
+		return false
+	}
+	if req.Rates != nil && !validateShippingRates(c, req.Rates) {
+		return false
+	}
+
+	method.Code = strings.TrimSpace(req.Code)
+	method.Name = req.Name
+	method.Description = req.Description
+	method.Carrier = req.Carrier
+	if req.IsActive != nil {
+		method.IsActive = *req.IsActive
+	}
+	method.RateBasis = models.ShippingRateBasis(req.RateBasis)
+	method.SortOrder = req.SortOrder
+	method.FreeShippingThreshold = req.FreeShippingThreshold
+	method.MinDeliveryDays = req.MinDeliveryDays
+	method.MaxDeliveryDays = req.MaxDeliveryDays
+	method.TaxClassID = req.TaxClassID
+	if req.Rates != nil {
+		method.Rates = make([]models.ShippingRate, 0, len(req.Rates))
+		for _, rate := range req.Rates {
+			method.Rates = append(method.Rates, models.ShippingRate{
+				ZoneID:   rate.ZoneID,
+				MinValue: rate.MinValue,
+				MaxValue: rate.MaxValue,
+				Price:    rate.Price,
+			})
+		}
+	}
+	return true
+}
+
+// validateShippingRates checks the zones exist and each zone's bands do
+// not overlap
+func validateShippingRates(c *gin.Context, rates []ShippingRateRequest) bool {
+	byZone := make(map[uint][]ShippingRateRequest)
+	for _, rate := range rates {
+		if rate.MaxValue != nil && *rate.MaxValue <= rate.MinValue {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_value must be above min_value"})
+			return false
+		}
+		byZone[rate.ZoneID] = append(byZone[rate.ZoneID], rate)
+	}
+
+	zoneIDs := make([]uint, 0, len(byZone))
+	for zoneID, bands := range byZone {
+		zoneIDs = append(zoneIDs, zoneID)
+		sort.Slice(bands, func(i, j int) bool { return bands[i].MinValue < bands[j].MinValue })
+		for i := 1; i < len(bands); i++ {
+			if bands[i-1].MaxValue == nil || *bands[i-1].MaxValue > bands[i].MinValue {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Rate bands overlap", "zone_id": zoneID})
+				return false
+			}
+		}
+	}
+
+	var count int64
+	if err := database.GetDB().Model(&models.ShippingZone{}).Where("id IN ?", zoneIDs).Count(&count).Error; err != nil {
+		logger.Error.Printf("Failed to check shipping zones: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate shipping rates"})
+		return false
+	}
+	if int(count) != len(zoneIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping zone not found"})
+		return false
+	}
+	return true
+}
+
+// saveShippingMethod saves the method, replacing its rates when asked
+func saveShippingMethod(method *models.ShippingMethod, replaceRates bool) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		rates := method.Rates
+		if err := tx.Omit("Rates").Save(method).Error; err != nil {
+			return err
+		}
+		if !replaceRates {
+			return nil
+		}
+		if err := tx.Where("method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		for i := range rates {
+			rates[i].MethodID = method.ID
+		}
+		if len(rates) > 0 {
+			if err := tx.Create(&rates).Error; err != nil {
+				return err
+			}
+		}
+		method.Rates = rates
+		return nil
+	})
+}
+
+func GetShippingZones(c *gin.Context) {
+	var zones []models.ShippingZone
+	if err := database.GetDB().Preload("Locations").Order("name").Find(&zones).Error; err != nil {
+		logger.Error.Printf("Failed to get shipping zones: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shipping zones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shipping_zones": zones})
+}
+
+func CreateShippingZone(c *gin.Context) {
+	var req ShippingZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone := models.ShippingZone{Name: req.Name, Locations: zoneLocations(req.Locations)}
+	if err := database.GetDB().Create(&zone).Error; err != nil {
+		logger.Error.Printf("Failed to create shipping zone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shipping zone"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Shipping zone created successfully",
+		"shipping_zone": zone,
+	})
+}
+
+// UpdateShippingZone renames a zone and replaces its locations
+func UpdateShippingZone(c *gin.Context) {
+	var req ShippingZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var zone models.ShippingZone
+	if err := database.GetDB().First(&zone, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping zone not found"})
+		return
+	}
+
+	zone.Name = req.Name
+	locations := zoneLocations(req.Locations)
+	if err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&zone).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("zone_id = ?", zone.ID).Delete(&models.ShippingZoneLocation{}).Error; err != nil {
+			return err
+		}
+		for i := range locations {
+			locations[i].ZoneID = zone.ID
+		}
+		return tx.Create(&locations).Error
+	}); err != nil {
+		logger.Error.Printf("Failed to update shipping zone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shipping zone"})
+		return
+	}
+	zone.Locations = locations
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Shipping zone updated successfully",
+		"shipping_zone": zone,
+	})
+}
+
+func zoneLocations(requests []ShippingZoneLocationRequest) []models.ShippingZoneLocation {
+	locations := make([]models.ShippingZoneLocation, 0, len(requests))
+	for _, location := range requests {
+		locations = append(locations, models.ShippingZoneLocation{
+			Country:        strings.ToUpper(location.Country),
+			PostcodePrefix: strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(location.PostcodePrefix), " ", "")),
+		})
+	}
+	return locations
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"marketprogo/pkg/units"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SpecificationRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	Type    string   `json:"type" binding:"required,oneof=number text boolean enum"`
+	Value   string   `json:"value" binding:"required"`
+	Unit    string   `json:"unit"`
+	Options []string `json:"options"`
+}
+
+type specFilter struct {
+	Name string
+	Min  *float64
+	Max  *float64
+	Unit string
+}
+
+func GetProductSpecifications(c *gin.Context) {
+	productID := c.Param("id")
+	var specs []models.ProductSpecification
+
+	if err := database.GetDB().Where("product_id = ?", productID).
+		Order("name").
+		Find(&specs).Error; err != nil {
+		logger.Error.Printf("Failed to get specifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get specifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"specifications": specs})
+}
+
+func CreateProductSpecification(c *gin.Context) {
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req SpecificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	spec := models.ProductSpecification{ProductID: product.ID}
+	if err := applySpecification(&spec, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.GetDB().Create(&spec).Error; err != nil {
+		logger.Error.Printf("Failed to create specification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create specification"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Specification created successfully",
+		"specification": spec,
+	})
+}
+
+func UpdateProductSpecification(c *gin.Context) {
+	var spec models.ProductSpecification
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		First(&spec, c.Param("specId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Specification not found"})
+		return
+	}
+
+	var req SpecificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := applySpecification(&spec, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.GetDB().Save(&spec).Error; err != nil {
+		logger.Error.Printf("Failed to update specification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update specification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Specification updated successfully",
+		"specification": spec,
+	})
+}
+
+func DeleteProductSpecification(c *gin.Context) {
+	result := database.GetDB().Where("product_id = ?", c.Param("id")).
+		Delete(&models.ProductSpecification{}, c.Param("specId"))
+	if result.Error != nil {
+		logger.Error.Printf("Failed to delete specification: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete specification"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Specification not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Specification deleted successfully"})
+}
+
+// applySpecification validates the value against its type and fills in the
+// canonical numeric value used for range filtering
+func applySpecification(spec *models.ProductSpecification, req SpecificationRequest) error {
+	spec.Name = strings.TrimSpace(req.Name)
+	spec.Type = models.SpecificationType(req.Type)
+	spec.Unit = ""
+	spec.Options = ""
+	spec.NumericValue = nil
+	spec.CanonicalUnit = ""
+
+	value := strings.TrimSpace(req.Value)
+
+	switch spec.Type {
+	case models.SpecificationTypeNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("value %q is not a number", req.Value)
+		}
+		spec.Value = strconv.FormatFloat(number, 'f', -1, 64)
+		spec.Unit = strings.TrimSpace(req.Unit)
+		spec.CanonicalUnit = spec.Unit
+
+		if spec.Unit != "" {
+			canonical, unit, err := units.ToCanonical(number, spec.Unit)
+			if err == nil {
+				number, spec.CanonicalUnit = canonical, unit
+			} else if !errors.Is(err, units.ErrUnknownUnit) {
+				return err
+			}
+		}
+		spec.NumericValue = &number
+
+	case models.SpecificationTypeBoolean:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("value %q is not a boolean", req.Value)
+		}
+		spec.Value = strconv.FormatBool(flag)
+
+	case models.SpecificationTypeEnum:
+		if len(req.Options) == 0 {
+			return errors.New("enum specifications need options")
+		}
+		allowed := false
+		for i, option := range req.Options {
+			req.Options[i] = strings.TrimSpace(option)
+			if strings.Contains(req.Options[i], ",") {
+				return fmt.Errorf("option %q must not contain a comma", option)
+			}
+			if req.Options[i] == value {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("value %q is not one of the options", req.Value)
+		}
+		spec.Value = value
+		spec.Options = strings.Join(req.Options, ",")
+
+	default:
+		spec.Value = value
+		spec.Unit = strings.TrimSpace(req.Unit)
+	}
+
+	return nil
+}
+
+// parseSpecFilter parses name:min:max[:unit], where min or max may be empty
+func parseSpecFilter(raw string) (specFilter, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] == "" {
+		return specFilter{}, fmt.Errorf("spec filter %q must look like name:min:max[:unit]", raw)
+	}
+
+	filter := specFilter{Name: parts[0]}
+	if len(parts) == 4 {
+		filter.Unit = parts[3]
+	}
+
+	for i, bound := range []**float64{&filter.Min, &filter.Max} {
+		text := parts[i+1]
+		if text == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return specFilter{}, fmt.Errorf("spec filter %q has a non-numeric bound", raw)
+		}
+		*bound = &value
+	}
+
+	return filter, nil
+}
+
+// applySpecFilter restricts query to products with a number spec in range,
+// comparing in canonical units so 500g matches a 0.5kg filter
+func applySpecFilter(query *gorm.DB, filter specFilter) (*gorm.DB, error) {
+	unit := filter.Unit
+	if unit != "" {
+		if _, canonical, err := units.ToCanonical(1, unit); err == nil {
+			for _, bound := range []*float64{filter.Min, filter.Max} {
+				if bound != nil {
+					*bound, _, _ = units.ToCanonical(*bound, unit)
+				}
+			}
+			unit = canonical
+		} else if !errors.Is(err, units.ErrUnknownUnit) {
+			return nil, err
+		}
+	}
+
+	sub := database.GetDB().Model(&models.ProductSpecification{}).
+		Select("1").
+		Where("product_specifications.product_id = products.id").
+		Where("LOWER(product_specifications.name) = LOWER(?)", filter.Name).
+		Where("product_specifications.numeric_value IS NOT NULL")
+	if filter.Unit != "" {
+		sub = sub.Where("product_specifications.canonical_unit = ?", unit)
+	}
+	if filter.Min != nil {
+		sub = sub.Where("product_specifications.numeric_value >= ?", *filter.Min)
+	}
+	if filter.Max != nil {
+		sub = sub.Where("product_specifications.numeric_value <= ?", *filter.Max)
+	}
+
+	return query.Where("EXISTS (?)", sub), nil
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateStockCountRequest struct {
+	WarehouseID      uint     `json:"warehouse_id" binding:"required"`
+	CategoryIDs      []uint   `json:"category_ids"`
+	ABCClass         string   `json:"abc_class" binding:"omitempty,oneof=A B C"`
+	RecountThreshold *float64 `json:"recount_threshold" binding:"omitempty,min=0"`
+	Notes            string   `json:"notes"`
+}
+
+type CountEntryRequest struct {
+	LineID   uint `json:"line_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"min=0"`
+}
+
+type RecordCountsRequest struct {
+	Counts []CountEntryRequest `json:"counts" binding:"required,min=1,dive"`
+}
+
+type RequestRecountRequest struct {
+	LineIDs []uint `json:"line_ids" binding:"required,min=1"`
+}
+
+type ApproveStockCountRequest struct {
+	// Reasons maps line IDs to adjustment reason codes
+	Reasons map[uint]models.AdjustmentReason `json:"reasons"`
+}
+
+func GetStockCounts(c *gin.Context) {
+	var counts []models.StockCount
+	query := database.GetDB().Preload("Warehouse").Preload("Categories").Order("created_at DESC")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if warehouseID := c.Query("warehouse_id"); warehouseID != "" {
+		query = query.Where("warehouse_id = ?", warehouseID)
+	}
+
+	if err := query.Limit(500).Find(&counts).Error; err != nil {
+		logger.Error.Printf("Failed to get stock counts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stock counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stock_counts": counts})
+}
+
+func GetStockCount(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+	respondStockCount(c, http.StatusOK, id)
+}
+
+// CreateStockCount opens a count session over a warehouse
+func CreateStockCount(c *gin.Context) {
+	var req CreateStockCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count *models.StockCount
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		count, err = services.CreateStockCount(tx, services.StockCountInput{
+			WarehouseID:      req.WarehouseID,
+			CategoryIDs:      req.CategoryIDs,
+			ABCClass:         req.ABCClass,
+			RecountThreshold: req.RecountThreshold,
+			Notes:            req.Notes,
+			UserID:           currentUserIDPtr(c),
+		})
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusCreated, count.ID)
+}
+
+// RecordStockCounts enters blind counts for lines of an open session
+func RecordStockCounts(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+	var req RecordCountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries := make([]services.CountEntry, 0, len(req.Counts))
+	for _, entry := range req.Counts {
+		entries = append(entries, services.CountEntry{LineID: entry.LineID, Quantity: entry.Quantity})
+	}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		_, err := services.RecordCounts(tx, id, entries, currentUserIDPtr(c), time.Now())
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusOK, id)
+}
+
+func SubmitStockCount(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		_, err := services.SubmitStockCount(tx, id, time.Now())
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusOK, id)
+}
+
+// RequestStockRecount reopens a submitted session for some lines to be
+// counted again
+func RequestStockRecount(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+	var req RequestRecountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		_, err := services.RequestRecount(tx, id, req.LineIDs)
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusOK, id)
+}
+
+// ApproveStockCount posts a submitted session's variances as adjustments
+func ApproveStockCount(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+	var req ApproveStockCountRequest
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		_, err := services.ApproveStockCount(tx, id, req.Reasons, currentUserIDPtr(c), time.Now())
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusOK, id)
+}
+
+func CancelStockCount(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		_, err := services.CancelStockCount(tx, id)
+		return err
+	})
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	respondStockCount(c, http.StatusOK, id)
+}
+
+// GetStockCountVariance reports a session's variances and their value
+func GetStockCountVariance(c *gin.Context) {
+	id, ok := stockCountID(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.StockCountVariance(database.GetDB(), id)
+	if err != nil {
+		respondStockCountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetStockCountImpact totals the valuation impact of counts approved in a
+// period, by warehouse
+func GetStockCountImpact(c *gin.Context) {
+	to, err := parseDateParam(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+		return
+	}
+	from := to.AddDate(0, -1, 0)
+	if value := c.Query("from"); value != "" {
+		if from, err = parseDateParam(value, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+	}
+	var warehouseID *uint
+	if value := c.Query("warehouse_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid warehouse_id"})
+			return
+		}
+		parsed := uint(id)
+		warehouseID = &parsed
+	}
+
+	impacts, err := services.StockCountImpact(database.GetDB(), from, to, warehouseID)
+	if err != nil {
+		logger.Error.Printf("Failed to get stock count impact: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stock count impact"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "warehouses": impacts})
+}
+
+func stockCountID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stock count ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondStockCount writes the session with its lines. Book quantities
+// and variances stay hidden while the session is open, so counts are blind.
+func respondStockCount(c *gin.Context, status int, id uint) {
+	var count models.StockCount
+	if err := database.GetDB().
+		Preload("Warehouse").
+		Preload("Categories").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lines.Product").
+		First(&count, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stock count not found"})
+		} else {
+			logger.Error.Printf("Failed to get stock count: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stock count"})
+		}
+		return
+	}
+
+	if count.Status == models.StockCountStatusOpen {
+		for i := range count.Lines {
+			line := &count.Lines[i]
+			line.ExpectedQuantity = nil
+			line.Variance = nil
+			line.UnitCost = 0
+			line.VarianceValue = 0
+		}
+	}
+	c.JSON(status, gin.H{"stock_count": count})
+}
+
+func respondStockCountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stock count not found"})
+	case errors.Is(err, services.ErrCountState),
+		errors.Is(err, services.ErrCountIncomplete),
+		errors.Is(err, services.ErrBelowReserved),
+		errors.Is(err, services.ErrNegativeStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWarehouseNotFound),
+		errors.Is(err, services.ErrCategoryNotFound),
+		errors.Is(err, services.ErrEmptyCount),
+		errors.Is(err, services.ErrUnknownCountLine),
+		errors.Is(err, services.ErrUnknownReason):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error.Printf("Failed to update stock count: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock count"})
+	}
+}
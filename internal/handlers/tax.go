@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TaxClassRequest struct {
+	Code        string `json:"code" binding:"required,max=30"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	IsDefault   bool   `json:"is_default"`
+}
+
+type TaxRateRequest struct {
+	TaxClassID    uint       `json:"tax_class_id" binding:"required"`
+	Country       string     `json:"country" binding:"required,len=2"`
+	Region        string     `json:"region"`
+	Name          string     `json:"name"`
+	Rate          float64    `json:"rate" binding:"min=0,max=100"`
+	Treatment     string     `json:"treatment" binding:"omitempty,oneof=STANDARD ZERO_RATED EXEMPT"`
+	EffectiveFrom *time.Time `json:"effective_from"` // defaults to now
+	EffectiveTo   *time.Time `json:"effective_to"`
+}
+
+func GetTaxClasses(c *gin.Context) {
+	var classes []models.TaxClass
+	if err := database.GetDB().Preload("Rates", func(db *gorm.DB) *gorm.DB {
+		return db.Order("country, region, effective_from DESC")
+	}).Order("code").Find(&classes).Error; err != nil {
+		logger.Error.Printf("Failed to get tax classes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tax classes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tax_classes": classes})
+}
+
+func CreateTaxClass(c *gin.Context) {
+	var req TaxClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	class := models.TaxClass{
+		Code:        strings.ToUpper(req.Code),
+		Name:        req.Name,
+		Description: req.Description,
+		IsDefault:   req.IsDefault,
+	}
+	if err := saveTaxClass(&class); err != nil {
+		logger.Error.Printf("Failed to create tax class: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax class"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Tax class created successfully",
+		"tax_class": class,
+	})
+}
+
+func UpdateTaxClass(c *gin.Context) {
+	var req TaxClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var class models.TaxClass
+	if err := database.GetDB().First(&class, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax class not found"})
+		return
+	}
+
+	class.Code = strings.ToUpper(req.Code)
+	class.Name = req.Name
+	class.Description = req.Description
+	class.IsDefault = req.IsDefault
+	if err := saveTaxClass(&class); err != nil {
+		logger.Error.Printf("Failed to update tax class: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax class"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Tax class updated successfully",
+		"tax_class": class,
+	})
+}
+
+// saveTaxClass saves the class, taking the default from any other class
+// when it becomes the default
+func saveTaxClass(class *models.TaxClass) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if class.IsDefault {
+			if err := tx.Model(&models.TaxClass{}).Where("is_default AND id <> ?", class.ID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(class).Error
+	})
+}
+
+// GetTaxRates lists rates, filtered by ?tax_class_id= and ?country=
+func GetTaxRates(c *gin.Context) {
+	query := database.GetDB().Order("country, region, tax_class_id, effective_from DESC")
+	if classID := c.Query("tax_class_id"); classID != "" {
+		query = query.Where("tax_class_id = ?", classID)
+	}
+	if country := c.Query("country"); country != "" {
+		query = query.Where("country = ?", strings.ToUpper(country))
+	}
+
+	var rates []models.TaxRate
+	if err := query.Find(&rates).Error; err != nil {
+		logger.Error.Printf("Failed to get tax rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tax rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tax_rates": rates})
+}
+
+func CreateTaxRate(c *gin.Context) {
+	var req TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateTaxClass(c, req.TaxClassID) {
+		return
+	}
+
+	var rate models.TaxRate
+	if !applyTaxRateRequest(c, &rate, &req) {
+		return
+	}
+	if err := database.GetDB().Create(&rate).Error; err != nil {
+		logger.Error.Printf("Failed to create tax rate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Tax rate created successfully",
+		"tax_rate": rate,
+	})
+}
+
+// UpdateTaxRate changes a rate. Documents already taxed keep the results
+// stored on their lines; to change a rate from a date, end the old one and
+// add another.
+func UpdateTaxRate(c *gin.Context) {
+	var req TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rate models.TaxRate
+	if err := database.GetDB().First(&rate, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rate not found"})
+		return
+	}
+	if req.TaxClassID != rate.TaxClassID && !validateTaxClass(c, req.TaxClassID) {
+		return
+	}
+	if req.EffectiveFrom == nil {
+		req.EffectiveFrom = &rate.EffectiveFrom
+	}
+
+	if !applyTaxRateRequest(c, &rate, &req) {
+		return
+	}
+	if err := database.GetDB().Save(&rate).Error; err != nil {
+		logger.Error.Printf("Failed to update tax rate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Tax rate updated successfully",
+		"tax_rate": rate,
+	})
+}
+
+func applyTaxRateRequest(c *gin.Context, rate *models.TaxRate, req *TaxRateRequest) bool {
+	treatment := models.TaxTreatment(req.Treatment)
+	if treatment == "" {
+		treatment = models.TaxTreatmentStandard
+	}
+	if treatment != models.TaxTreatmentStandard && req.Rate != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Zero-rated and exempt rates must be 0"})
+		return false
+	}
+	effectiveFrom := time.Now()
+	if req.EffectiveFrom != nil {
+		effectiveFrom = *req.EffectiveFrom
+	}
+	if req.EffectiveTo != nil && !req.EffectiveTo.After(effectiveFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effective_to must be after effective_from"})
+		return false
+	}
+
+	rate.TaxClassID = req.TaxClassID
+	rate.Country = strings.ToUpper(req.Country)
+	rate.Region = strings.TrimSpace(req.Region)
+	rate.Name = req.Name
+	rate.Rate = req.Rate
+	rate.Treatment = treatment
+	rate.EffectiveFrom = effectiveFrom
+	rate.EffectiveTo = req.EffectiveTo
+	return true
+}
+
+func validateTaxClass(c *gin.Context, id uint) bool {
+	var count int64
+	if err := database.GetDB().Model(&models.TaxClass{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		logger.Error.Printf("Failed to check tax class: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate tax class"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tax class not found"})
+		return false
+	}
+	return true
+}
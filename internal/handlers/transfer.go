@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TransferLineRequest struct {
+	ProductID       uint  `json:"product_id" binding:"required"`
+	Quantity        int   `json:"quantity" binding:"required,min=1"`
+	InventoryItemID *uint `json:"inventory_item_id"`
+}
+
+type CreateTransferRequest struct {
+	SourceWarehouseID      uint                  `json:"source_warehouse_id" binding:"required"`
+	DestinationWarehouseID uint                  `json:"destination_warehouse_id" binding:"required"`
+	Notes                  string                `json:"notes"`
+	ExpectedDate           *time.Time            `json:"expected_date"`
+	Lines                  []TransferLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+type DispatchTransferRequest struct {
+	Carrier        string     `json:"carrier"`
+	TrackingNumber string     `json:"tracking_number"`
+	ExpectedDate   *time.Time `json:"expected_date"`
+}
+
+type TransferReceiptRequest struct {
+	LineID   uint   `json:"line_id" binding:"required"`
+	Quantity int    `json:"quantity" binding:"min=0"`
+	Damaged  int    `json:"damaged" binding:"min=0"`
+	Note     string `json:"note"`
+}
+
+type ReceiveTransferRequest struct {
+	Lines []TransferReceiptRequest `json:"lines" binding:"dive"`
+	// Close records anything still in transit as short
+	Close bool `json:"close"`
+}
+
+type CancelTransferRequest struct {
+	Note string `json:"note"`
+}
+
+type TransferDocumentRequest struct {
+	FileName    string `json:"file_name" binding:"required"`
+	FileType    string `json:"file_type" binding:"required"`
+	FileSize    int64  `json:"file_size"`
+	URL         string `json:"url" binding:"required"`
+	Description string `json:"description"`
+}
+
+func GetTransfers(c *gin.Context) {
+	var transfers []models.StockTransfer
+	query := database.GetDB().Preload("Lines").Order("created_at DESC")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if warehouseID := c.Query("source_warehouse_id"); warehouseID != "" {
+		query = query.Where("source_warehouse_id = ?", warehouseID)
+	}
+	if warehouseID := c.Query("destination_warehouse_id"); warehouseID != "" {
+		query = query.Where("destination_warehouse_id = ?", warehouseID)
+	}
+
+	if err := query.Limit(500).Find(&transfers).Error; err != nil {
+		logger.Error.Printf("Failed to get transfers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers})
+}
+
+func GetTransfer(c *gin.Context) {
+	transfer, ok := loadTransfer(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer})
+}
+
+func CreateTransfer(c *gin.Context) {
+	var req CreateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input := services.TransferInput{
+		SourceWarehouseID:      req.SourceWarehouseID,
+		DestinationWarehouseID: req.DestinationWarehouseID,
+		Notes:                  req.Notes,
+		ExpectedDate:           req.ExpectedDate,
+		UserID:                 currentUserIDPtr(c),
+	}
+	for _, line := range req.Lines {
+		input.Lines = append(input.Lines, services.TransferLineInput{
+			ProductID:       line.ProductID,
+			Quantity:        line.Quantity,
+			InventoryItemID: line.InventoryItemID,
+		})
+	}
+
+	var transfer *models.StockTransfer
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		transfer, err = services.CreateTransfer(tx, input)
+		return err
+	})
+	if err != nil {
+		respondTransferError(c, err)
+		return
+	}
+
+	respondTransfer(c, http.StatusCreated, transfer.ID)
+}
+
+// DispatchTransfer takes the transfer's stock out of the source warehouse
+func DispatchTransfer(c *gin.Context) {
+	id, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var req DispatchTransferRequest
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		transfer, err := services.DispatchTransfer(tx, id, currentUserIDPtr(c), time.Now())
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"carrier": req.Carrier, "tracking_number": req.TrackingNumber}
+		if req.ExpectedDate != nil {
+			updates["expected_date"] = *req.ExpectedDate
+		}
+		return tx.Model(transfer).Updates(updates).Error
+	})
+	if err != nil {
+		respondTransferError(c, err)
+		return
+	}
+
+	respondTransfer(c, http.StatusOK, id)
+}
+
+// ReceiveTransfer books stock that arrived at the destination warehouse
+func ReceiveTransfer(c *gin.Context) {
+	id, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var req ReceiveTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Lines) == 0 && !req.Close {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to receive"})
+		return
+	}
+
+	receipts := make([]services.TransferReceipt, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		receipts = append(receipts, services.TransferReceipt{
+			LineID:   line.LineID,
+			Quantity: line.Quantity,
+			Damaged:  line.Damaged,
+			Note:     line.Note,
+		})
+	}
+
+	err := services.Transact(database.GetDB(), func(tx *gorm.DB) error {
+		_, err := services.ReceiveTransfer(tx, id, receipts, req.Close, currentUserIDPtr(c), time.Now())
+		return err
+	})
+	if err != nil {
+		respondTransferError(c, err)
+		return
+	}
+
+	respondTransfer(c, http.StatusOK, id)
+}
+
+func CancelTransfer(c *gin.Context) {
+	id, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var req CancelTransferRequest
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		_, err := services.CancelTransfer(tx, id, currentUserIDPtr(c), req.Note)
+		return err
+	})
+	if err != nil {
+		respondTransferError(c, err)
+		return
+	}
+
+	respondTransfer(c, http.StatusOK, id)
+}
+
+// AddTransferDocument attaches a document, such as a delivery note, to a
+// transfer
+func AddTransferDocument(c *gin.Context) {
+	var transfer models.StockTransfer
+	if err := database.GetDB().First(&transfer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	var req TransferDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	document := models.Document{
+		DocumentableID:   transfer.ID,
+		DocumentableType: services.ReferenceTypeTransfer,
+		FileName:         req.FileName,
+		FileType:         req.FileType,
+		FileSize:         req.FileSize,
+		URL:              req.URL,
+		Description:      req.Description,
+	}
+	if err := database.GetDB().Model(&transfer).Association("Documents").Append(&document); err != nil {
+		logger.Error.Printf("Failed to add transfer document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add document"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, document)
+}
+
+// GetInTransitStock lists stock dispatched and not yet received
+func GetInTransitStock(c *gin.Context) {
+	var productID, warehouseID *uint
+	for param, target := range map[string]**uint{"product_id": &productID, "warehouse_id": &warehouseID} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+			return
+		}
+		parsed := uint(id)
+		*target = &parsed
+	}
+
+	lines, err := services.InTransitStock(database.GetDB(), productID, warehouseID)
+	if err != nil {
+		logger.Error.Printf("Failed to get in-transit stock: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get in-transit stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"in_transit": lines})
+}
+
+func transferID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func loadTransfer(c *gin.Context, id interface{}) (*models.StockTransfer, bool) {
+	var transfer models.StockTransfer
+	if err := database.GetDB().
+		Preload("SourceWarehouse").
+		Preload("DestinationWarehouse").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lines.Product").
+		Preload("Lines.Discrepancies").
+		Preload("History", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Documents").
+		First(&transfer, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		} else {
+			logger.Error.Printf("Failed to get transfer: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfer"})
+		}
+		return nil, false
+	}
+	return &transfer, true
+}
+
+func respondTransfer(c *gin.Context, status int, id uint) {
+	transfer, ok := loadTransfer(c, id)
+	if !ok {
+		return
+	}
+	c.JSON(status, gin.H{"transfer": transfer})
+}
+
+func respondTransferError(c *gin.Context, err error) {
+	var stockErr *services.StockError
+	switch {
+	case errors.As(err, &stockErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Insufficient stock in the source warehouse",
+			"product_id": stockErr.ProductID,
+			"requested":  stockErr.Requested,
+			"available":  stockErr.Available,
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+	case errors.Is(err, services.ErrTransferState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSameWarehouse),
+		errors.Is(err, services.ErrWarehouseNotFound),
+		errors.Is(err, services.ErrProductNotFound),
+		errors.Is(err, services.ErrInvalidTransferBatch),
+		errors.Is(err, services.ErrUnknownTransferLine),
+		errors.Is(err, services.ErrOverReceipt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error.Printf("Failed to update transfer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transfer"})
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/i18n"
+	"marketprogo/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TranslationRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type SpecificationTranslationRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Value string `json:"value"`
+}
+
+func GetProductTranslations(c *gin.Context) {
+	var translations []models.ProductTranslation
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		Order("locale").
+		Find(&translations).Error; err != nil {
+		logger.Error.Printf("Failed to get product translations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get translations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"translations": translations})
+}
+
+func SaveProductTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	var product models.Product
+	if err := database.GetDB().First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var req TranslationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	translation := models.ProductTranslation{
+		ProductID:   product.ID,
+		Locale:      locale,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := upsertTranslation(tx, &translation, "product_id", "name", "description"); err != nil {
+			return err
+		}
+		return services.IndexProduct(tx, product.ID)
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to save product translation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Translation saved successfully",
+		"translation": translation,
+	})
+}
+
+func DeleteProductTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	var deleted int64
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("product_id = ? AND locale = ?", c.Param("id"), locale).
+			Delete(&models.ProductTranslation{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		if deleted == 0 {
+			return nil
+		}
+
+		var product models.Product
+		if err := tx.First(&product, c.Param("id")).Error; err != nil {
+			return err
+		}
+		return services.IndexProduct(tx, product.ID)
+	})
+	if err != nil {
+		logger.Error.Printf("Failed to delete product translation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete translation"})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Translation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Translation deleted successfully"})
+}
+
+func GetCategoryTranslations(c *gin.Context) {
+	var translations []models.CategoryTranslation
+	if err := database.GetDB().Where("category_id = ?", c.Param("id")).
+		Order("locale").
+		Find(&translations).Error; err != nil {
+		logger.Error.Printf("Failed to get category translations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get translations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"translations": translations})
+}
+
+func SaveCategoryTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	var category models.Category
+	if err := database.GetDB().First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	var req TranslationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	translation := models.CategoryTranslation{
+		CategoryID:  category.ID,
+		Locale:      locale,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := upsertTranslation(database.GetDB(), &translation, "category_id", "name", "description"); err != nil {
+		logger.Error.Printf("Failed to save category translation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Translation saved successfully",
+		"translation": translation,
+	})
+}
+
+func DeleteCategoryTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	result := database.GetDB().Unscoped().Where("category_id = ? AND locale = ?", c.Param("id"), locale).
+		Delete(&models.CategoryTranslation{})
+	if result.Error != nil {
+		logger.Error.Printf("Failed to delete category translation: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete translation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Translation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Translation deleted successfully"})
+}
+
+func SaveSpecificationTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	var spec models.ProductSpecification
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		First(&spec, c.Param("specId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Specification not found"})
+		return
+	}
+
+	var req SpecificationTranslationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Value != "" && spec.Type != models.SpecificationTypeText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only text specification values can be translated"})
+		return
+	}
+
+	translation := models.ProductSpecificationTranslation{
+		SpecificationID: spec.ID,
+		Locale:          locale,
+		Name:            req.Name,
+		Value:           req.Value,
+	}
+	if err := upsertTranslation(database.GetDB(), &translation, "specification_id", "name", "value"); err != nil {
+		logger.Error.Printf("Failed to save specification translation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Translation saved successfully",
+		"translation": translation,
+	})
+}
+
+func DeleteSpecificationTranslation(c *gin.Context) {
+	locale, ok := translationLocale(c)
+	if !ok {
+		return
+	}
+
+	var spec models.ProductSpecification
+	if err := database.GetDB().Where("product_id = ?", c.Param("id")).
+		First(&spec, c.Param("specId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Specification not found"})
+		return
+	}
+
+	result := database.GetDB().Unscoped().Where("specification_id = ? AND locale = ?", spec.ID, locale).
+		Delete(&models.ProductSpecificationTranslation{})
+	if result.Error != nil {
+		logger.Error.Printf("Failed to delete specification translation: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete translation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Translation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Translation deleted successfully"})
+}
+
+func ReindexProductSearch(c *gin.Context) {
+	count, err := services.ReindexProducts(database.GetDB())
+	if err != nil {
+		logger.Error.Printf("Failed to reindex products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reindex products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Products reindexed successfully",
+		"products": count,
+	})
+}
+
+// translationLocale validates the :locale parameter. The default locale is
+// edited on the record itself rather than through a translation.
+func translationLocale(c *gin.Context) (string, bool) {
+	locale := i18n.Normalize(c.Param("locale"))
+	if !services.IsTranslatableLocale(locale) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported translation locale"})
+		return "", false
+	}
+	return locale, true
+}
+
+// upsertTranslation inserts or replaces the translation keyed by
+// (ownerColumn, locale)
+func upsertTranslation(db *gorm.DB, translation interface{}, ownerColumn string, columns ...string) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: ownerColumn}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns(append(columns, "updated_at")),
+	}).Create(translation).Error
+}
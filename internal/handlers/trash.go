@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetTrash lists soft-deleted records of one type, most recent first
+func GetTrash(c *gin.Context) {
+	kind := services.TrashKind(c.DefaultQuery("type", string(services.TrashKindProduct)))
+	query := database.GetDB().Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC")
+
+	var (
+		items interface{}
+		err   error
+	)
+	switch kind {
+	case services.TrashKindProduct:
+		var products []models.Product
+		err = query.Find(&products).Error
+		items = products
+	case services.TrashKindCategory:
+		var categories []models.Category
+		err = query.Find(&categories).Error
+		items = categories
+	case services.TrashKindSupplier:
+		var suppliers []models.Supplier
+		err = query.Find(&suppliers).Error
+		items = suppliers
+	case services.TrashKindWarehouse:
+		var warehouses []models.Warehouse
+		err = query.Find(&warehouses).Error
+		items = warehouses
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown record type"})
+		return
+	}
+	if err != nil {
+		logger.Error.Printf("Failed to get trash: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": kind, "items": items})
+}
+
+// GetDependents reports what deleting a record would affect
+func GetDependents(c *gin.Context) {
+	kind, id, ok := trashTarget(c)
+	if !ok {
+		return
+	}
+
+	dependents, err := services.Dependents(database.GetDB(), kind, id)
+	if err != nil {
+		logger.Error.Printf("Failed to get dependents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get dependents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"type": kind, "id": id, "dependents": dependents})
+}
+
+// TrashRecord moves a record to the trash under the requested policy
+func TrashRecord(c *gin.Context) {
+	kind, id, ok := trashTarget(c)
+	if !ok {
+		return
+	}
+	trashRecord(c, kind, id)
+}
+
+func RestoreRecord(c *gin.Context) {
+	kind, id, ok := trashTarget(c)
+	if !ok {
+		return
+	}
+
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		return services.Restore(tx, kind, id)
+	})
+	switch {
+	case errors.Is(err, services.ErrNotInTrash):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record is not in the trash"})
+		return
+	case errors.Is(err, services.ErrParentInTrash):
+		c.JSON(http.StatusConflict, gin.H{"error": "Restore the parent category first"})
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to restore record: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore record"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Record restored successfully"})
+}
+
+func PurgeRecord(c *gin.Context) {
+	kind, id, ok := trashTarget(c)
+	if !ok {
+		return
+	}
+
+	var references []services.Dependent
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		references, err = services.Purge(tx, kind, id)
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrNotInTrash):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record is not in the trash"})
+		return
+	case errors.Is(err, services.ErrStillReferenced):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Record is referenced by trading history and cannot be purged",
+			"references": references,
+		})
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to purge record: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge record"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Record permanently deleted"})
+}
+
+// trashRecord soft-deletes a record, answering 409 with the dependents
+// report when the policy does not allow it
+func trashRecord(c *gin.Context, kind services.TrashKind, id uint) {
+	policy := services.DeletePolicy(c.DefaultQuery("policy", string(services.DeletePolicyBlock)))
+	if policy != services.DeletePolicyBlock && policy != services.DeletePolicyCascade {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "policy must be block or cascade"})
+		return
+	}
+
+	var dependents []services.Dependent
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		dependents, err = services.Trash(tx, kind, id, policy)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	case errors.Is(err, services.ErrHasDependents):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Record has dependents",
+			"policy":     policy,
+			"dependents": dependents,
+		})
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to delete %s: %v", kind, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Record moved to trash", "dependents": dependents})
+}
+
+func trashTarget(c *gin.Context) (services.TrashKind, uint, bool) {
+	kind := services.TrashKind(c.Param("type"))
+	switch kind {
+	case services.TrashKindProduct, services.TrashKindCategory, services.TrashKindSupplier, services.TrashKindWarehouse:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown record type"})
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return "", 0, false
+	}
+	return kind, uint(id), true
+}
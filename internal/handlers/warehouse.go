@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/internal/services"
+	"marketprogo/pkg/database"
+	"marketprogo/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AddressRequest struct {
+	StreetAddress1 string   `json:"street_address1" binding:"required"`
+	StreetAddress2 string   `json:"street_address2"`
+	City           string   `json:"city" binding:"required"`
+	State          string   `json:"state"`
+	PostalCode     string   `json:"postal_code" binding:"required"`
+	Country        string   `json:"country" binding:"required"`
+	Latitude       *float64 `json:"latitude" binding:"omitempty,min=-90,max=90"`
+	Longitude      *float64 `json:"longitude" binding:"omitempty,min=-180,max=180"`
+}
+
+type CreateWarehouseRequest struct {
+	Name     string         `json:"name" binding:"required"`
+	Code     string         `json:"code" binding:"required"`
+	Address  AddressRequest `json:"address" binding:"required"`
+	IsActive *bool          `json:"is_active"`
+	Priority *int           `json:"priority"`
+}
+
+type UpdateWarehouseRequest struct {
+	Name     string          `json:"name"`
+	Code     string          `json:"code"`
+	Address  *AddressRequest `json:"address"`
+	IsActive *bool           `json:"is_active"`
+	Priority *int            `json:"priority"`
+}
+
+func (r AddressRequest) apply(address *models.Address) {
+	address.StreetAddress1 = r.StreetAddress1
+	address.StreetAddress2 = r.StreetAddress2
+	address.City = r.City
+	address.State = r.State
+	address.PostalCode = r.PostalCode
+	address.Country = r.Country
+	address.Latitude = r.Latitude
+	address.Longitude = r.Longitude
+}
+
+func GetWarehouses(c *gin.Context) {
+	var warehouses []models.Warehouse
+	query := database.GetDB().Preload("Address").Order("priority, name")
+
+	if isActive := c.Query("is_active"); isActive != "" {
+		active, _ := strconv.ParseBool(isActive)
+		query = query.Where("is_active = ?", active)
+	}
+
+	if err := query.Find(&warehouses).Error; err != nil {
+		logger.Error.Printf("Failed to get warehouses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get warehouses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouses": warehouses})
+}
+
+func GetWarehouse(c *gin.Context) {
+	var warehouse models.Warehouse
+	if err := database.GetDB().Preload("Address").First(&warehouse, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouse)
+}
+
+func CreateWarehouse(c *gin.Context) {
+	var req CreateWarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !warehouseCodeAvailable(c, req.Code, 0) {
+		return
+	}
+
+	tx := database.GetDB().Begin()
+
+	var address models.Address
+	req.Address.apply(&address)
+	if err := tx.Create(&address).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to create warehouse address: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+
+	warehouse := models.Warehouse{
+		Name:      req.Name,
+		Code:      req.Code,
+		AddressID: address.ID,
+		IsActive:  true,
+		Priority:  100,
+	}
+	if req.Priority != nil {
+		warehouse.Priority = *req.Priority
+	}
+	if err := tx.Create(&warehouse).Error; err != nil {
+		tx.Rollback()
+		logger.Error.Printf("Failed to create warehouse: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+	// Zero values fall back to the column defaults on insert, so an explicit
+	// inactive or zero-priority warehouse needs its own update
+	zeroes := map[string]interface{}{}
+	if req.IsActive != nil && !*req.IsActive {
+		zeroes["is_active"] = false
+	}
+	if req.Priority != nil && *req.Priority == 0 {
+		zeroes["priority"] = 0
+	}
+	if len(zeroes) > 0 {
+		if err := tx.Model(&warehouse).Updates(zeroes).Error; err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to create warehouse: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		logger.Error.Printf("Failed to commit transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create warehouse"})
+		return
+	}
+
+	warehouse.Address = address
+	c.JSON(http.StatusCreated, warehouse)
+}
+
+func UpdateWarehouse(c *gin.Context) {
+	var warehouse models.Warehouse
+	if err := database.GetDB().Preload("Address").First(&warehouse, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	var req UpdateWarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Code != "" && req.Code != warehouse.Code && !warehouseCodeAvailable(c, req.Code, warehouse.ID) {
+		return
+	}
+
+	tx := database.GetDB().Begin()
+
+	updates := map[string]interface{}{}
+	if req.Name != "" {
+		updates["name"] = req.Name
+	}
+	if req.Code != "" {
+		updates["code"] = req.Code
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	if req.Priority != nil {
+		updates["priority"] = *req.Priority
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&warehouse).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to update warehouse: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update warehouse"})
+			return
+		}
+	}
+
+	if req.Address != nil {
+		req.Address.apply(&warehouse.Address)
+		if err := tx.Save(&warehouse.Address).Error; err != nil {
+			tx.Rollback()
+			logger.Error.Printf("Failed to update warehouse address: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update warehouse"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		logger.Error.Printf("Failed to commit transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update warehouse"})
+		return
+	}
+	if req.IsActive != nil {
+		services.InvalidateAllAvailability()
+	}
+
+	c.JSON(http.StatusOK, warehouse)
+}
+
+func DeleteWarehouse(c *gin.Context) {
+	var warehouse models.Warehouse
+	if err := database.GetDB().First(&warehouse, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	trashRecord(c, services.TrashKindWarehouse, warehouse.ID)
+}
+
+// GetWarehouseStock lists the inventory items held in a warehouse
+func GetWarehouseStock(c *gin.Context) {
+	var warehouse models.Warehouse
+	if err := database.GetDB().First(&warehouse, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Warehouse not found"})
+		return
+	}
+
+	var items []models.InventoryItem
+	query := database.GetDB().Preload("Product").
+		Where("warehouse_id = ?", warehouse.ID).
+		Order("product_id, expiry_date, id")
+
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if includeEmpty, _ := strconv.ParseBool(c.Query("include_empty")); !includeEmpty {
+		query = query.Where("quantity > 0 OR reserved > 0")
+	}
+
+	if err := query.Find(&items).Error; err != nil {
+		logger.Error.Printf("Failed to get warehouse stock: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get warehouse stock"})
+		return
+	}
+
+	stock := make([]InventoryItemResponse, 0, len(items))
+	for _, item := range items {
+		stock = append(stock, newInventoryItemResponse(item))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouse_id": warehouse.ID, "stock": stock})
+}
+
+// warehouseCodeAvailable writes a 409 when another warehouse has the code
+func warehouseCodeAvailable(c *gin.Context, code string, warehouseID uint) bool {
+	var count int64
+	if err := database.GetDB().Unscoped().Model(&models.Warehouse{}).
+		Where("code = ? AND id <> ?", code, warehouseID).
+		Count(&count).Error; err != nil {
+		logger.Error.Printf("Failed to check warehouse code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check warehouse code"})
+		return false
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Warehouse code already in use"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"context"
+	"marketprogo/pkg/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job is a unit of background work run on a fixed interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(db *gorm.DB, now time.Time) error
+}
+
+// Start runs every job in its own goroutine until ctx is cancelled. Each
+// job runs once immediately and then on every tick; runs never overlap.
+func Start(ctx context.Context, db *gorm.DB, jobs ...Job) {
+	for _, job := range jobs {
+		go run(ctx, db, job)
+	}
+}
+
+func run(ctx context.Context, db *gorm.DB, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		execute(db, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func execute(db *gorm.DB, job Job) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error.Printf("Job %s panicked: %v", job.Name, err)
+		}
+	}()
+
+	if err := job.Run(db, time.Now()); err != nil {
+		logger.Error.Printf("Job %s failed: %v", job.Name, err)
+	}
+}
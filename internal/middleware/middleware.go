@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"marketprogo/internal/models"
 	"marketprogo/pkg/auth"
+	"marketprogo/pkg/i18n"
 	"marketprogo/pkg/logger"
 	"net/http"
 	"strings"
@@ -15,8 +17,9 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Cart-Token")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Cart-Token")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -77,38 +80,81 @@ func Recovery() gin.HandlerFunc {
 // Auth middleware
 func Auth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		if c.GetHeader("Authorization") == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error": "Authorization token is required",
 			})
 			return
 		}
+		authenticate(c)
+	}
+}
 
-		// Extract token from Bearer header
-		const bearerPrefix = "Bearer "
-		if !strings.HasPrefix(authHeader, bearerPrefix) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format",
-			})
+// OptionalAuth middleware lets anonymous requests through, but sets the
+// user like Auth when a token is sent. A bad token is still refused.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
 			return
 		}
-		token := authHeader[len(bearerPrefix):]
+		authenticate(c)
+	}
+}
 
-		// Parse and validate JWT token
-		claims, err := auth.ValidateToken(token)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid or expired token",
+func authenticate(c *gin.Context) {
+	// Extract token from Bearer header
+	const bearerPrefix = "Bearer "
+	authHeader := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid authorization header format",
+		})
+		return
+	}
+	token := authHeader[len(bearerPrefix):]
+
+	// Parse and validate JWT token
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired token",
+		})
+		return
+	}
+
+	// Set user details in context
+	c.Set("user_id", claims.UserID)
+	c.Set("user_type", claims.UserType)
+	c.Set("company_id", claims.CompanyID)
+
+	c.Next()
+}
+
+// Admin middleware, must run after Auth
+func Admin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_type") != string(models.UserTypeAdmin) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Admin access required",
 			})
 			return
 		}
 
-		// Set user details in context
-		c.Set("user_id", claims.UserID)
-		c.Set("user_type", claims.UserType)
-		c.Set("company_id", claims.CompanyID)
+		c.Next()
+	}
+}
+
+// Locale middleware resolves the response language from the locale query
+// parameter or Accept-Language, and stores the fallback chain
+func Locale(supported []string, defaultLocale string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := i18n.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+		if locale := c.Query("locale"); locale != "" {
+			requested = append([]string{locale}, requested...)
+		}
 
+		c.Set("locales", i18n.FallbackChain(requested, supported, defaultLocale))
 		c.Next()
 	}
 }
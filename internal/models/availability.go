@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// ProductAvailability is a product's computed stock position across active
+// warehouses. For a bundle the figures count whole bundles its components
+// make up, and inbound stock is not shown.
+type ProductAvailability struct {
+	ProductID uint `json:"product_id"`
+	IsBundle  bool `json:"is_bundle"`
+
+	// Sellable stock only: active, unexpired batches
+	OnHand    int  `json:"on_hand"`
+	Reserved  int  `json:"reserved"`
+	Available int  `json:"available"`
+	InStock   bool `json:"in_stock"`
+
+	Inbound         []InboundStock `json:"inbound"`
+	InboundQuantity int            `json:"inbound_quantity"`
+
+	// EarliestShipDate is today when stock is available, else the first
+	// expected inbound date; nil when neither is known
+	EarliestShipDate *time.Time `json:"earliest_ship_date"`
+
+	Warehouses []WarehouseAvailability `json:"warehouses,omitempty"`
+	ComputedAt time.Time               `json:"computed_at"`
+}
+
+type InboundSource string
+
+const (
+	InboundSourcePurchaseOrder InboundSource = "PURCHASE_ORDER"
+	InboundSourceTransfer      InboundSource = "TRANSFER"
+)
+
+// InboundStock is stock expected from one open purchase order or transfer
+type InboundStock struct {
+	Source       InboundSource `json:"source"`
+	ReferenceID  uint          `json:"reference_id"`
+	WarehouseID  *uint         `json:"warehouse_id,omitempty"`
+	Quantity     int           `json:"quantity"`
+	ExpectedDate *time.Time    `json:"expected_date"`
+}
+
+type WarehouseAvailability struct {
+	WarehouseID uint `json:"warehouse_id"`
+	OnHand      int  `json:"on_hand"`
+	Reserved    int  `json:"reserved"`
+	Available   int  `json:"available"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CartStatus string
+
+const (
+	CartStatusActive CartStatus = "ACTIVE"
+	// CartStatusMerged carts were anonymous and moved into a user's cart
+	CartStatusMerged CartStatus = "MERGED"
+	// CartStatusConverted carts were checked out into OrderID
+	CartStatusConverted CartStatus = "CONVERTED"
+)
+
+// Cart is a basket kept on the server. Anonymous carts are found by Token
+// and belong to a user once they log in; a user has one active cart.
+type Cart struct {
+	gorm.Model
+	Token  string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"token"`
+	UserID *uint      `gorm:"index" json:"user_id,omitempty"`
+	Status CartStatus `gorm:"type:varchar(20);not null;default:'ACTIVE'" json:"status"`
+
+	// Currency the cart is priced in; empty is the base currency
+	Currency string `gorm:"type:varchar(3)" json:"currency,omitempty"`
+
+	// Where the cart ships to. Anonymous carts estimate tax and shipping
+	// from Country and PostalCode; checkout needs ShippingAddressID.
+	ShippingAddressID *uint  `json:"shipping_address_id,omitempty"`
+	Country           string `gorm:"type:varchar(2)" json:"country,omitempty"`
+	PostalCode        string `gorm:"type:varchar(20)" json:"postal_code,omitempty"`
+	ShippingMethod    string `gorm:"type:varchar(30)" json:"shipping_method,omitempty"`
+
+	Items   []CartItem   `json:"items"`
+	Coupons []CartCoupon `json:"coupons,omitempty"`
+
+	OrderID      *uint      `json:"order_id,omitempty"`
+	CheckedOutAt *time.Time `json:"checked_out_at,omitempty"`
+}
+
+// CartItem is a product in a cart. UnitPrice is the price last shown to
+// the customer, so a change can be pointed out.
+type CartItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CartID    uint      `gorm:"uniqueIndex:idx_cart_item_product;not null" json:"cart_id"`
+	ProductID uint      `gorm:"uniqueIndex:idx_cart_item_product;not null" json:"product_id"`
+	Product   *Product  `json:"product,omitempty"`
+	Quantity  int       `gorm:"not null" json:"quantity"`
+	UnitPrice float64   `json:"unit_price"`
+}
+
+// CartCoupon is a coupon code entered on a cart
+type CartCoupon struct {
+	ID     uint   `gorm:"primarykey" json:"-"`
+	CartID uint   `gorm:"uniqueIndex:idx_cart_coupon_code;not null" json:"-"`
+	Code   string `gorm:"type:varchar(50);uniqueIndex:idx_cart_coupon_code;not null" json:"code"`
+}
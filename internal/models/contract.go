@@ -29,6 +29,9 @@ type Contract struct {
 	PaymentTerms   int            `json:"payment_terms"`  // in days
 	Notes          string         `json:"notes"`
 
+	// MinShelfLifeDays overrides the company's minimum when set
+	MinShelfLifeDays *int `json:"min_shelf_life_days,omitempty"`
+
 	// Items
 	Items []ContractItem `json:"items"`
 
@@ -47,9 +50,9 @@ type ContractItem struct {
 	Product     *Product  `json:"product"`
 	Quantity    int       `gorm:"not null" json:"quantity"`
 	UnitPrice   float64   `gorm:"not null" json:"unit_price"`
-	TaxAmount   float64   `json:"tax_amount"`
 	TotalAmount float64   `gorm:"not null" json:"total_amount"`
-	IsActive    bool      `gorm:"default:true" json:"is_active"`
+	LineTax
+	IsActive bool `gorm:"default:true" json:"is_active"`
 }
 
 type ContractSchedule struct {
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ExchangeRateSource string
+
+const (
+	ExchangeRateSourceManual ExchangeRateSource = "MANUAL"
+	ExchangeRateSourceImport ExchangeRateSource = "IMPORT"
+)
+
+type Currency struct {
+	gorm.Model
+	Code          string `gorm:"type:varchar(3);uniqueIndex;not null" json:"code"` // ISO 4217
+	Name          string `gorm:"not null" json:"name"`
+	Symbol        string `json:"symbol"`
+	DecimalPlaces int    `gorm:"default:2" json:"decimal_places"`
+	IsActive      bool   `gorm:"default:true" json:"is_active"`
+}
+
+// ExchangeRate is how many units of QuoteCurrency one unit of BaseCurrency
+// buys from EffectiveAt onwards. Rows are never updated so the table keeps
+// the full rate history.
+type ExchangeRate struct {
+	gorm.Model
+	BaseCurrency  string             `gorm:"type:varchar(3);not null;index:idx_exchange_rate_pair" json:"base_currency"`
+	QuoteCurrency string             `gorm:"type:varchar(3);not null;index:idx_exchange_rate_pair" json:"quote_currency"`
+	Rate          float64            `gorm:"not null" json:"rate"`
+	EffectiveAt   time.Time          `gorm:"not null;index:idx_exchange_rate_pair" json:"effective_at"`
+	Source        ExchangeRateSource `gorm:"type:varchar(20);not null" json:"source"`
+	CreatedByID   *uint              `json:"created_by_id,omitempty"`
+}
+
+// ProductPrice overrides the converted price of a product in one currency
+type ProductPrice struct {
+	gorm.Model
+	ProductID uint     `gorm:"uniqueIndex:idx_product_price_currency;not null" json:"product_id"`
+	Product   *Product `json:"-"`
+	Currency  string   `gorm:"type:varchar(3);uniqueIndex:idx_product_price_currency;not null" json:"currency"`
+	BasePrice float64  `gorm:"not null" json:"base_price"`
+	B2BPrice  *float64 `json:"b2b_price"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AdjustmentReason string
+
+const (
+	AdjustmentReasonInitialStock    AdjustmentReason = "INITIAL_STOCK"
+	AdjustmentReasonGoodsReceived   AdjustmentReason = "GOODS_RECEIVED"
+	AdjustmentReasonCountCorrection AdjustmentReason = "COUNT_CORRECTION"
+	AdjustmentReasonDamaged         AdjustmentReason = "DAMAGED"
+	AdjustmentReasonExpired         AdjustmentReason = "EXPIRED"
+	AdjustmentReasonLost            AdjustmentReason = "LOST"
+	AdjustmentReasonFound           AdjustmentReason = "FOUND"
+	AdjustmentReasonCustomerReturn  AdjustmentReason = "CUSTOMER_RETURN"
+	AdjustmentReasonOther           AdjustmentReason = "OTHER"
+)
+
+// AdjustmentReasons lists the reason codes accepted for manual adjustments
+var AdjustmentReasons = []AdjustmentReason{
+	AdjustmentReasonInitialStock,
+	AdjustmentReasonGoodsReceived,
+	AdjustmentReasonCountCorrection,
+	AdjustmentReasonDamaged,
+	AdjustmentReasonExpired,
+	AdjustmentReasonLost,
+	AdjustmentReasonFound,
+	AdjustmentReasonCustomerReturn,
+	AdjustmentReasonOther,
+}
+
+// InventoryAdjustment records a manual change to an inventory item's
+// quantity or status
+type InventoryAdjustment struct {
+	gorm.Model
+	InventoryItemID uint             `gorm:"index;not null" json:"inventory_item_id"`
+	InventoryItem   *InventoryItem   `json:"-"`
+	QuantityBefore  int              `json:"quantity_before"`
+	QuantityAfter   int              `json:"quantity_after"`
+	QuantityChange  int              `json:"quantity_change"`
+	StatusBefore    string           `json:"status_before,omitempty"`
+	StatusAfter     string           `json:"status_after,omitempty"`
+	Reason          AdjustmentReason `gorm:"type:varchar(30);not null" json:"reason"`
+	Note            string           `json:"note"`
+	AdjustedByID    *uint            `json:"adjusted_by_id,omitempty"`
+}
+
+type MovementType string
+
+const (
+	MovementTypeReceipt     MovementType = "RECEIPT"
+	MovementTypeReservation MovementType = "RESERVATION"
+	MovementTypeRelease     MovementType = "RELEASE"
+	MovementTypeShipment    MovementType = "SHIPMENT"
+	MovementTypeAdjustment  MovementType = "ADJUSTMENT"
+	MovementTypeTransfer    MovementType = "TRANSFER"
+	MovementTypeReturn      MovementType = "RETURN"
+)
+
+var ErrImmutableMovement = errors.New("inventory movements are append-only")
+
+// InventoryMovement is one entry in the append-only stock ledger. Summing
+// QuantityDelta and ReservedDelta for an item gives its Quantity and
+// Reserved balances.
+type InventoryMovement struct {
+	ID              uint         `gorm:"primarykey" json:"id"`
+	CreatedAt       time.Time    `gorm:"index" json:"created_at"`
+	InventoryItemID uint         `gorm:"index;not null" json:"inventory_item_id"`
+	ProductID       uint         `gorm:"index;not null" json:"product_id"`
+	WarehouseID     uint         `gorm:"index;not null" json:"warehouse_id"`
+	Type            MovementType `gorm:"type:varchar(20);not null" json:"type"`
+	QuantityDelta   int          `gorm:"not null;default:0" json:"quantity_delta"`
+	ReservedDelta   int          `gorm:"not null;default:0" json:"reserved_delta"`
+	ReferenceType   string       `gorm:"type:varchar(50);index:idx_movement_reference" json:"reference_type,omitempty"`
+	ReferenceID     *uint        `gorm:"index:idx_movement_reference" json:"reference_id,omitempty"`
+	UserID          *uint        `json:"user_id,omitempty"`
+	Note            string       `json:"note,omitempty"`
+}
+
+func (m *InventoryMovement) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableMovement
+}
+
+func (m *InventoryMovement) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableMovement
+}
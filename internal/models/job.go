@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// JobCheckpoint records how far an incremental background job has got
+type JobCheckpoint struct {
+	Name      string    `gorm:"primaryKey;type:varchar(100)" json:"name"`
+	LastID    uint      `gorm:"not null;default:0" json:"last_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationType string
+
+const (
+	NotificationTypeOrderStatus   NotificationType = "ORDER_STATUS"
+	NotificationTypePaymentStatus NotificationType = "PAYMENT_STATUS"
+)
+
+// Notification is a message for a user, shown in the app until read
+type Notification struct {
+	gorm.Model
+	UserID        uint             `gorm:"index;not null" json:"user_id"`
+	Type          NotificationType `gorm:"type:varchar(30);not null" json:"type"`
+	Title         string           `gorm:"not null" json:"title"`
+	Message       string           `json:"message"`
+	ReferenceType string           `gorm:"type:varchar(50)" json:"reference_type,omitempty"`
+	ReferenceID   *uint            `json:"reference_id,omitempty"`
+	ReadAt        *time.Time       `gorm:"index" json:"read_at"`
+}
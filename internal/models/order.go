@@ -42,12 +42,39 @@ type Order struct {
 	DiscountAmount float64       `json:"discount_amount"`
 	FinalAmount    float64       `gorm:"not null" json:"final_amount"`
 
-	// Shipping
+	// PricesIncludeTax records whether the items were priced with tax
+	// included. TotalAmount always excludes tax.
+	//
+	// DiscountAmount is what promotions took off the items and shipping.
+	// It is already deducted from TotalAmount and ShippingAmount.
+	PricesIncludeTax bool `gorm:"default:false" json:"prices_include_tax"`
+
+	// Currency the customer pays in. Amounts above are in this currency; the
+	// Base* amounts are converted to the store's base currency at ExchangeRate.
+	Currency           string  `gorm:"type:varchar(3);not null;default:'GBP'" json:"currency"`
+	ExchangeRate       float64 `gorm:"not null;default:1" json:"exchange_rate"`
+	BaseCurrency       string  `gorm:"type:varchar(3);not null;default:'GBP'" json:"base_currency"`
+	BaseTotalAmount    float64 `json:"base_total_amount"`
+	BaseTaxAmount      float64 `json:"base_tax_amount"`
+	BaseShippingAmount float64 `json:"base_shipping_amount"`
+	BaseDiscountAmount float64 `json:"base_discount_amount"`
+	BaseFinalAmount    float64 `json:"base_final_amount"`
+
+	// Shipping. ShippingMethod is the chosen method's code; ShippingAmount
+	// excludes tax, which is ShippingTaxAmount and part of TaxAmount.
 	ShippingAddressID uint    `json:"shipping_address_id"`
 	ShippingAddress   Address `json:"shipping_address"`
 	ShippingMethod    string  `json:"shipping_method"`
+	ShippingTaxAmount float64 `json:"shipping_tax_amount"`
 	TrackingNumber    string  `json:"tracking_number"`
 
+	// Delivery window quoted when the order was placed
+	EstimatedDeliveryFrom *time.Time `json:"estimated_delivery_from,omitempty"`
+	EstimatedDeliveryTo   *time.Time `json:"estimated_delivery_to,omitempty"`
+
+	// AllocationStrategy chose the warehouses the items ship from
+	AllocationStrategy string `gorm:"type:varchar(30)" json:"allocation_strategy"`
+
 	// Payment
 	PaymentMethod    string     `json:"payment_method"`
 	PaymentReference string     `json:"payment_reference"`
@@ -66,6 +93,30 @@ type Order struct {
 	DeliveredDate *time.Time `json:"delivered_date"`
 }
 
+type OrderStatusField string
+
+const (
+	OrderStatusFieldStatus        OrderStatusField = "STATUS"
+	OrderStatusFieldPaymentStatus OrderStatusField = "PAYMENT_STATUS"
+)
+
+// OrderStatusHistory records one change of an order's status or payment
+// status. ChangedByID is nil for changes made by background jobs.
+type OrderStatusHistory struct {
+	ID          uint             `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time        `gorm:"index" json:"created_at"`
+	OrderID     uint             `gorm:"index;not null" json:"order_id"`
+	Field       OrderStatusField `gorm:"type:varchar(20);not null" json:"field"`
+	FromStatus  string           `gorm:"type:varchar(20)" json:"from_status,omitempty"`
+	ToStatus    string           `gorm:"type:varchar(20);not null" json:"to_status"`
+	ChangedByID *uint            `json:"changed_by_id,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
 type OrderItem struct {
 	gorm.Model
 	OrderID        uint    `json:"order_id"`
@@ -74,18 +125,69 @@ type OrderItem struct {
 	Product        Product `json:"product"`
 	Quantity       int     `gorm:"not null" json:"quantity"`
 	UnitPrice      float64 `gorm:"not null" json:"unit_price"`
-	TaxAmount      float64 `json:"tax_amount"`
 	DiscountAmount float64 `json:"discount_amount"`
 	TotalAmount    float64 `gorm:"not null" json:"total_amount"`
+	LineTax
+
+	// DiscountAmount is taken off TotalAmount before tax; Discounts splits
+	// it by promotion
+	Discounts []OrderItemDiscount `json:"discounts,omitempty"`
 
-	// Inventory tracking
-	InventoryItemID *uint          `json:"inventory_item_id,omitempty"`
-	InventoryItem   *InventoryItem `json:"inventory_item,omitempty"`
+	// Inventory tracking. InventoryItemID is only set when the whole line
+	// comes from one inventory item; Allocations always lists every source,
+	// including those of bundle components.
+	InventoryItemID *uint                 `json:"inventory_item_id,omitempty"`
+	InventoryItem   *InventoryItem        `json:"inventory_item,omitempty"`
+	Allocations     []OrderItemAllocation `json:"allocations,omitempty"`
+
+	// Components of a bundle line, with the line price split across them
+	Components []OrderItemComponent `json:"components,omitempty"`
 
 	// Status
 	Status string `gorm:"default:'active'" json:"status"` // active, cancelled, returned
 }
 
+type OrderItemComponent struct {
+	gorm.Model
+	OrderItemID uint    `gorm:"index;not null" json:"order_item_id"`
+	ProductID   uint    `json:"product_id"`
+	Product     Product `json:"product"`
+	Quantity    int     `gorm:"not null" json:"quantity"` // total units across the line
+	UnitPrice   float64 `json:"unit_price"`
+	TotalAmount float64 `json:"total_amount"`
+
+	// Inventory tracking, as for OrderItem
+	InventoryItemID *uint                 `json:"inventory_item_id,omitempty"`
+	InventoryItem   *InventoryItem        `json:"inventory_item,omitempty"`
+	Allocations     []OrderItemAllocation `gorm:"foreignKey:OrderItemComponentID" json:"allocations,omitempty"`
+}
+
+type AllocationStatus string
+
+const (
+	AllocationStatusReserved AllocationStatus = "RESERVED"
+	AllocationStatusReleased AllocationStatus = "RELEASED"
+	AllocationStatusShipped  AllocationStatus = "SHIPPED"
+	AllocationStatusReturned AllocationStatus = "RETURNED"
+)
+
+// OrderItemAllocation is the stock reserved for an order line in one
+// inventory item. Bundle lines are allocated per component.
+type OrderItemAllocation struct {
+	gorm.Model
+	OrderItemID          uint           `gorm:"index;not null" json:"order_item_id"`
+	OrderItemComponentID *uint          `gorm:"index" json:"order_item_component_id,omitempty"`
+	ProductID            uint           `gorm:"not null" json:"product_id"`
+	InventoryItemID      uint           `gorm:"index;not null" json:"inventory_item_id"`
+	InventoryItem        *InventoryItem `json:"inventory_item,omitempty"`
+	WarehouseID          uint           `gorm:"index;not null" json:"warehouse_id"`
+	Quantity             int            `gorm:"not null" json:"quantity"`
+
+	// Status follows the order: reserved stock is released on cancellation
+	// and issued on shipment; shipped stock is put back on return
+	Status AllocationStatus `gorm:"type:varchar(20);not null;default:'RESERVED';index" json:"status"`
+}
+
 type Invoice struct {
 	gorm.Model
 	OrderID          uint       `json:"order_id"`
@@ -95,6 +197,11 @@ type Invoice struct {
 	DueDate          time.Time  `json:"due_date"`
 	Amount           float64    `gorm:"not null" json:"amount"`
 	TaxAmount        float64    `json:"tax_amount"`
+	Currency         string     `gorm:"type:varchar(3);not null;default:'GBP'" json:"currency"`
+	ExchangeRate     float64    `gorm:"not null;default:1" json:"exchange_rate"`
+	BaseCurrency     string     `gorm:"type:varchar(3);not null;default:'GBP'" json:"base_currency"`
+	BaseAmount       float64    `json:"base_amount"`
+	BaseTaxAmount    float64    `json:"base_tax_amount"`
 	Status           string     `gorm:"default:'pending'" json:"status"` // pending, paid, overdue, cancelled
 	PaymentDate      *time.Time `json:"payment_date"`
 	PaymentMethod    string     `json:"payment_method"`
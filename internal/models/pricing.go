@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PriceChangeSource string
+
+const (
+	PriceChangeSourceCreated   PriceChangeSource = "CREATED"
+	PriceChangeSourceManual    PriceChangeSource = "MANUAL"
+	PriceChangeSourceScheduled PriceChangeSource = "SCHEDULED"
+	PriceChangeSourceSaleStart PriceChangeSource = "SALE_START"
+	PriceChangeSourceSaleEnd   PriceChangeSource = "SALE_END"
+)
+
+type ScheduledPriceStatus string
+
+const (
+	ScheduledPriceStatusPending   ScheduledPriceStatus = "PENDING"
+	ScheduledPriceStatusActive    ScheduledPriceStatus = "ACTIVE" // sale running
+	ScheduledPriceStatusCompleted ScheduledPriceStatus = "COMPLETED"
+	ScheduledPriceStatusCancelled ScheduledPriceStatus = "CANCELLED"
+)
+
+// PriceHistory is written for every change to a product's prices
+type PriceHistory struct {
+	gorm.Model
+	ProductID    uint              `gorm:"index;not null" json:"product_id"`
+	Product      *Product          `json:"-"`
+	OldBasePrice float64           `json:"old_base_price"`
+	NewBasePrice float64           `json:"new_base_price"`
+	OldB2BPrice  float64           `json:"old_b2b_price"`
+	NewB2BPrice  float64           `json:"new_b2b_price"`
+	OldCostPrice float64           `json:"old_cost_price"`
+	NewCostPrice float64           `json:"new_cost_price"`
+	Source       PriceChangeSource `gorm:"type:varchar(20);not null" json:"source"`
+	Reason       string            `json:"reason"`
+	EffectiveAt  time.Time         `gorm:"index;not null" json:"effective_at"`
+
+	ScheduledPriceChangeID *uint `json:"scheduled_price_change_id,omitempty"`
+	ChangedByID            *uint `json:"changed_by_id,omitempty"`
+	ChangedBy              *User `json:"changed_by,omitempty"`
+}
+
+// ScheduledPriceChange is a future price change. When EndsAt is set it is a
+// temporary sale and the previous prices are restored once it ends.
+type ScheduledPriceChange struct {
+	gorm.Model
+	ProductID uint                 `gorm:"index;not null" json:"product_id"`
+	Product   *Product             `json:"product,omitempty"`
+	BasePrice *float64             `json:"base_price"`
+	B2BPrice  *float64             `json:"b2b_price"`
+	CostPrice *float64             `json:"cost_price"`
+	StartsAt  time.Time            `gorm:"index;not null" json:"starts_at"`
+	EndsAt    *time.Time           `gorm:"index" json:"ends_at"`
+	Status    ScheduledPriceStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	Reason    string               `json:"reason"`
+
+	CreatedByID *uint `json:"created_by_id,omitempty"`
+
+	// Prices in force when a sale started
+	PreviousBasePrice *float64 `json:"previous_base_price,omitempty"`
+	PreviousB2BPrice  *float64 `json:"previous_b2b_price,omitempty"`
+	PreviousCostPrice *float64 `json:"previous_cost_price,omitempty"`
+
+	AppliedAt  *time.Time `json:"applied_at"`
+	RevertedAt *time.Time `json:"reverted_at"`
+}
+
+// IsSale reports whether the change is temporary
+func (s *ScheduledPriceChange) IsSale() bool {
+	return s.EndsAt != nil
+}
+
+// PriceList holds negotiated prices, in the base currency, for the
+// companies assigned to it. A contract price for the same product wins.
+type PriceList struct {
+	gorm.Model
+	Name     string          `gorm:"not null" json:"name"`
+	StartsAt *time.Time      `json:"starts_at,omitempty"`
+	EndsAt   *time.Time      `json:"ends_at,omitempty"`
+	IsActive bool            `gorm:"default:true" json:"is_active"`
+	Items    []PriceListItem `json:"items"`
+}
+
+type PriceListItem struct {
+	ID          uint    `gorm:"primarykey" json:"id"`
+	PriceListID uint    `gorm:"uniqueIndex:idx_price_list_product;not null" json:"price_list_id"`
+	ProductID   uint    `gorm:"uniqueIndex:idx_price_list_product;not null" json:"product_id"`
+	Price       float64 `gorm:"not null" json:"price"`
+}
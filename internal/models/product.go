@@ -6,13 +6,23 @@ import (
 	"gorm.io/gorm"
 )
 
+type BundlePricing string
+
+const (
+	// BundlePricingFixed sells the bundle at its own BasePrice/B2BPrice
+	BundlePricingFixed BundlePricing = "FIXED"
+	// BundlePricingSumMinusDiscount sums the component prices and takes
+	// BundleDiscountPercent off
+	BundlePricingSumMinusDiscount BundlePricing = "SUM_MINUS_DISCOUNT"
+)
+
 type Product struct {
 	gorm.Model
 	Name        string  `gorm:"not null" json:"name"`
 	Description string  `json:"description"`
 	SKU         string  `gorm:"uniqueIndex;not null" json:"sku"`
-	Barcode     string  `json:"barcode"`
-	QRCode      string  `json:"qr_code"`  // URL to stored QR code image
+	Barcode     string  `gorm:"index" json:"barcode"` // GTIN-8/12/13/14
+	QRCode      string  `json:"qr_code"`              // URL to the generated QR code image
 	BasePrice   float64 `gorm:"not null" json:"base_price"`
 	B2BPrice    float64 `json:"b2b_price"`
 	CostPrice   float64 `json:"cost_price"`
@@ -21,6 +31,17 @@ type Product struct {
 	IsActive    bool    `gorm:"default:true" json:"is_active"`
 	IsFeatured  bool    `gorm:"default:false" json:"is_featured"`
 
+	// TaxClassID picks the product's tax rates; nil uses the default class
+	TaxClassID *uint     `gorm:"index" json:"tax_class_id,omitempty"`
+	TaxClass   *TaxClass `json:"tax_class,omitempty"`
+
+	// ABCClass ranks the product for cycle counting: A is counted most often
+	ABCClass string `gorm:"type:varchar(1);index" json:"abc_class,omitempty"`
+
+	// Aggregated from approved reviews
+	RatingAverage float64 `gorm:"default:0" json:"rating_average"`
+	RatingCount   int     `gorm:"default:0" json:"rating_count"`
+
 	// Images
 	Images []ProductImage `json:"images"`
 
@@ -32,6 +53,29 @@ type Product struct {
 
 	// Specifications
 	Specifications []ProductSpecification `json:"specifications"`
+
+	// Bundles are sold as one product but stocked as their components
+	IsBundle              bool              `gorm:"default:false" json:"is_bundle"`
+	BundlePricing         BundlePricing     `gorm:"type:varchar(20)" json:"bundle_pricing,omitempty"`
+	BundleDiscountPercent float64           `json:"bundle_discount_percent,omitempty"`
+	BundleComponents      []BundleComponent `gorm:"foreignKey:BundleID" json:"bundle_components,omitempty"`
+
+	// Per-currency price overrides
+	Prices []ProductPrice `json:"prices,omitempty"`
+
+	// Locale the name and description were resolved to for this response
+	Locale string `gorm:"-" json:"locale,omitempty"`
+
+	// Availability is computed stock, filled in for responses
+	Availability *ProductAvailability `gorm:"-" json:"availability,omitempty"`
+}
+
+type BundleComponent struct {
+	gorm.Model
+	BundleID    uint     `gorm:"uniqueIndex:idx_bundle_component;not null" json:"bundle_id"`
+	ComponentID uint     `gorm:"uniqueIndex:idx_bundle_component;not null" json:"component_id"`
+	Component   *Product `gorm:"foreignKey:ComponentID" json:"component,omitempty"`
+	Quantity    int      `gorm:"not null" json:"quantity"` // units of the component per bundle
 }
 
 type ProductImage struct {
@@ -53,6 +97,12 @@ type Category struct {
 	Products    []Product  `gorm:"many2many:product_categories;" json:"products"`
 }
 
+const (
+	InventoryStatusActive  = "active"
+	InventoryStatusExpired = "expired"
+	InventoryStatusDamaged = "damaged"
+)
+
 type InventoryItem struct {
 	gorm.Model
 	ProductID   uint      `json:"product_id"`
@@ -66,6 +116,11 @@ type InventoryItem struct {
 	Status      string    `gorm:"default:'active'" json:"status"` // active, expired, damaged
 }
 
+// Perishable reports whether the batch has an expiry date
+func (i *InventoryItem) Perishable() bool {
+	return !i.ExpiryDate.IsZero()
+}
+
 type Warehouse struct {
 	gorm.Model
 	Name           string          `gorm:"not null" json:"name"`
@@ -73,14 +128,31 @@ type Warehouse struct {
 	AddressID      uint            `json:"address_id"`
 	Address        Address         `json:"address"`
 	IsActive       bool            `gorm:"default:true" json:"is_active"`
+	Priority       int             `gorm:"default:100" json:"priority"` // lower ships first
 	InventoryItems []InventoryItem `json:"inventory_items"`
 }
 
+type SpecificationType string
+
+const (
+	SpecificationTypeNumber  SpecificationType = "number"
+	SpecificationTypeText    SpecificationType = "text"
+	SpecificationTypeBoolean SpecificationType = "boolean"
+	SpecificationTypeEnum    SpecificationType = "enum"
+)
+
 type ProductSpecification struct {
 	gorm.Model
-	ProductID uint    `json:"product_id"`
-	Product   Product `json:"-"`
-	Name      string  `gorm:"not null" json:"name"`
-	Value     string  `gorm:"not null" json:"value"`
-	Unit      string  `json:"unit"`
+	ProductID uint              `gorm:"index" json:"product_id"`
+	Product   Product           `json:"-"`
+	Name      string            `gorm:"not null" json:"name"`
+	Type      SpecificationType `gorm:"type:varchar(10);not null;default:'text'" json:"type"`
+	Value     string            `gorm:"not null" json:"value"`
+	Unit      string            `json:"unit"`
+	Options   string            `json:"options,omitempty"` // comma-separated allowed values for enum specs
+
+	// Number specs are also stored in the canonical unit of their dimension
+	// (g for mass, mm for length) so ranges compare across entry units
+	NumericValue  *float64 `gorm:"index" json:"numeric_value,omitempty"`
+	CanonicalUnit string   `json:"canonical_unit,omitempty"`
 }
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PromotionType string
+
+const (
+	// PromotionTypePercentage takes Value percent off the eligible lines
+	PromotionTypePercentage PromotionType = "PERCENTAGE"
+	// PromotionTypeFixedAmount takes Value, in the base currency, off the
+	// eligible lines
+	PromotionTypeFixedAmount PromotionType = "FIXED_AMOUNT"
+	// PromotionTypeBuyXGetY discounts the cheapest GetQuantity units of
+	// every BuyQuantity+GetQuantity eligible units by Value percent
+	PromotionTypeBuyXGetY PromotionType = "BUY_X_GET_Y"
+	// PromotionTypeFreeShipping waives the shipping charge
+	PromotionTypeFreeShipping PromotionType = "FREE_SHIPPING"
+)
+
+type PromotionScope string
+
+const (
+	PromotionScopeOrder    PromotionScope = "ORDER"
+	PromotionScopeCategory PromotionScope = "CATEGORY"
+	PromotionScopeProduct  PromotionScope = "PRODUCT"
+)
+
+// Promotion is a discount applied automatically, or only with one of its
+// coupons when RequiresCoupon is set
+type Promotion struct {
+	gorm.Model
+	Name        string         `gorm:"not null" json:"name"`
+	Description string         `json:"description"`
+	Type        PromotionType  `gorm:"type:varchar(20);not null" json:"type"`
+	Scope       PromotionScope `gorm:"type:varchar(10);not null;default:'ORDER'" json:"scope"`
+	Value       float64        `json:"value"`
+
+	BuyQuantity int `gorm:"default:0" json:"buy_quantity,omitempty"`
+	GetQuantity int `gorm:"default:0" json:"get_quantity,omitempty"`
+
+	// Lines in scope for PRODUCT and CATEGORY promotions
+	Products   []Product  `gorm:"many2many:promotion_products;" json:"products,omitempty"`
+	Categories []Category `gorm:"many2many:promotion_categories;" json:"categories,omitempty"`
+
+	// MinSpend is the least goods value, in the base currency, the order
+	// must have before discounts
+	MinSpend float64 `gorm:"default:0" json:"min_spend"`
+	// CustomerType limits the promotion to B2C or B2B buyers; empty is both
+	CustomerType UserType `gorm:"type:varchar(10)" json:"customer_type,omitempty"`
+
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	IsActive bool       `gorm:"default:true" json:"is_active"`
+
+	RequiresCoupon bool `gorm:"default:false" json:"requires_coupon"`
+	// Promotions apply in Priority order, highest first. One that is not
+	// Stackable is only applied on its own.
+	Stackable bool `gorm:"default:false" json:"stackable"`
+	Priority  int  `gorm:"default:0" json:"priority"`
+
+	Coupons []Coupon `json:"coupons,omitempty"`
+}
+
+// Coupon is a code that unlocks its promotion. Nil limits are unlimited.
+type Coupon struct {
+	gorm.Model
+	PromotionID           uint       `gorm:"index;not null" json:"promotion_id"`
+	Promotion             *Promotion `json:"promotion,omitempty"`
+	Code                  string     `gorm:"type:varchar(50);uniqueIndex;not null" json:"code"`
+	UsageLimit            *int       `json:"usage_limit,omitempty"`
+	UsageLimitPerCustomer *int       `json:"usage_limit_per_customer,omitempty"`
+	UsageCount            int        `gorm:"not null;default:0" json:"usage_count"`
+	IsActive              bool       `gorm:"default:true" json:"is_active"`
+}
+
+// PromotionRedemption records a promotion used on an order. It is released
+// if the order is cancelled, giving the coupon use back.
+type PromotionRedemption struct {
+	ID                 uint       `gorm:"primarykey" json:"id"`
+	CreatedAt          time.Time  `json:"created_at"`
+	PromotionID        uint       `gorm:"index;not null" json:"promotion_id"`
+	CouponID           *uint      `gorm:"index" json:"coupon_id,omitempty"`
+	OrderID            uint       `gorm:"index;not null" json:"order_id"`
+	UserID             uint       `gorm:"index;not null" json:"user_id"`
+	DiscountAmount     float64    `json:"discount_amount"`
+	BaseDiscountAmount float64    `json:"base_discount_amount"`
+	ReleasedAt         *time.Time `json:"released_at,omitempty"`
+}
+
+// OrderItemDiscount is one promotion's share of a line's discount, so
+// refunds can give back the right amount
+type OrderItemDiscount struct {
+	ID          uint    `gorm:"primarykey" json:"id"`
+	OrderItemID uint    `gorm:"index;not null" json:"order_item_id"`
+	PromotionID uint    `gorm:"index;not null" json:"promotion_id"`
+	CouponID    *uint   `json:"coupon_id,omitempty"`
+	Amount      float64 `gorm:"not null" json:"amount"`
+}
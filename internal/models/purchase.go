@@ -33,6 +33,11 @@ type PurchaseOrder struct {
 	ShippingAmount float64   `json:"shipping_amount"`
 	FinalAmount    float64   `gorm:"not null" json:"final_amount"`
 
+	// WarehouseID is where the goods are delivered; its stock counts them
+	// as inbound until received
+	WarehouseID *uint      `gorm:"index" json:"warehouse_id"`
+	Warehouse   *Warehouse `json:"warehouse,omitempty"`
+
 	// Shipping
 	ShippingMethod  string `json:"shipping_method"`
 	ContainerNumber string `json:"container_number"`
@@ -56,16 +61,16 @@ type PurchaseOrder struct {
 
 type POItem struct {
 	gorm.Model
-	POID             uint          `json:"po_id"`
-	PurchaseOrder    PurchaseOrder `gorm:"foreignKey:POID" json:"-"`
-	ProductID        uint          `json:"product_id"`
-	Product          Product       `json:"product"`
-	Quantity         int           `gorm:"not null" json:"quantity"`
-	UnitPrice        float64       `gorm:"not null" json:"unit_price"`
-	TaxAmount        float64       `json:"tax_amount"`
-	TotalAmount      float64       `gorm:"not null" json:"total_amount"`
-	ReceivedQuantity int           `gorm:"default:0" json:"received_quantity"`
-	Status           string        `gorm:"default:'pending'" json:"status"` // pending, partial, complete
+	POID          uint          `json:"po_id"`
+	PurchaseOrder PurchaseOrder `gorm:"foreignKey:POID" json:"-"`
+	ProductID     uint          `json:"product_id"`
+	Product       Product       `json:"product"`
+	Quantity      int           `gorm:"not null" json:"quantity"`
+	UnitPrice     float64       `gorm:"not null" json:"unit_price"`
+	TotalAmount   float64       `gorm:"not null" json:"total_amount"`
+	LineTax
+	ReceivedQuantity int    `gorm:"default:0" json:"received_quantity"`
+	Status           string `gorm:"default:'pending'" json:"status"` // pending, partial, complete
 }
 
 type Supplier struct {
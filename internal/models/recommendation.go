@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RelationType string
+
+const (
+	RelationTypeRelated     RelationType = "RELATED"
+	RelationTypeAccessory   RelationType = "ACCESSORY"
+	RelationTypeReplacement RelationType = "REPLACEMENT"
+	RelationTypeUpsell      RelationType = "UPSELL"
+)
+
+// ProductRelation is a manually curated link from one product to another
+type ProductRelation struct {
+	gorm.Model
+	ProductID        uint         `gorm:"uniqueIndex:idx_product_relation;not null" json:"product_id"`
+	RelatedProductID uint         `gorm:"uniqueIndex:idx_product_relation;not null" json:"related_product_id"`
+	RelatedProduct   *Product     `gorm:"foreignKey:RelatedProductID" json:"related_product,omitempty"`
+	Type             RelationType `gorm:"type:varchar(20);uniqueIndex:idx_product_relation;not null" json:"type"`
+	Position         int          `gorm:"default:0" json:"position"`
+}
+
+// ProductAffinity counts how often two products were ordered together.
+// Score is the share of orders containing ProductID that also contained
+// RelatedProductID.
+type ProductAffinity struct {
+	ProductID        uint      `gorm:"primaryKey;autoIncrement:false" json:"product_id"`
+	RelatedProductID uint      `gorm:"primaryKey;autoIncrement:false" json:"related_product_id"`
+	CoOrderCount     int       `gorm:"not null;default:0" json:"co_order_count"`
+	Score            float64   `gorm:"index;not null;default:0" json:"score"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ProductOrderStat counts the orders each product appeared in
+type ProductOrderStat struct {
+	ProductID  uint      `gorm:"primaryKey;autoIncrement:false" json:"product_id"`
+	OrderCount int       `gorm:"not null;default:0" json:"order_count"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReorderRule sets the stock levels that trigger replenishment of a product
+// in one warehouse
+type ReorderRule struct {
+	gorm.Model
+	ProductID   uint      `gorm:"uniqueIndex:idx_reorder_rule;not null" json:"product_id"`
+	Product     Product   `json:"product"`
+	WarehouseID uint      `gorm:"uniqueIndex:idx_reorder_rule;not null" json:"warehouse_id"`
+	Warehouse   Warehouse `json:"-"`
+
+	// ReorderPoint is the available-plus-inbound level at or below which
+	// more is ordered, in multiples of ReorderQuantity. SafetyStock is the
+	// available level below which the shortage is critical.
+	ReorderPoint    int `gorm:"not null" json:"reorder_point"`
+	SafetyStock     int `gorm:"not null;default:0" json:"safety_stock"`
+	ReorderQuantity int `gorm:"not null" json:"reorder_quantity"`
+
+	// SupplierID is the preferred supplier draft purchase orders go to
+	SupplierID *uint     `gorm:"index" json:"supplier_id"`
+	Supplier   *Supplier `json:"supplier,omitempty"`
+	IsActive   bool      `gorm:"default:true" json:"is_active"`
+}
+
+type StockAlertLevel string
+
+const (
+	// StockAlertLevelLow is stock at or below the reorder point
+	StockAlertLevelLow StockAlertLevel = "LOW"
+	// StockAlertLevelCritical is available stock below safety stock
+	StockAlertLevelCritical StockAlertLevel = "CRITICAL"
+)
+
+type StockAlertStatus string
+
+const (
+	StockAlertStatusOpen         StockAlertStatus = "OPEN"
+	StockAlertStatusAcknowledged StockAlertStatus = "ACKNOWLEDGED"
+	StockAlertStatusResolved     StockAlertStatus = "RESOLVED"
+)
+
+// StockAlert is a shortfall against a reorder rule. A rule has at most one
+// unresolved alert, refreshed on every check until stock recovers.
+type StockAlert struct {
+	gorm.Model
+	ReorderRuleID uint             `gorm:"index;not null" json:"reorder_rule_id"`
+	ProductID     uint             `gorm:"index;not null" json:"product_id"`
+	Product       Product          `json:"product"`
+	WarehouseID   uint             `gorm:"index;not null" json:"warehouse_id"`
+	Level         StockAlertLevel  `gorm:"type:varchar(20);not null" json:"level"`
+	Status        StockAlertStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+
+	// Stock when the alert was last checked
+	Available    int `json:"available"`
+	Inbound      int `json:"inbound"`
+	ReorderPoint int `json:"reorder_point"`
+	SafetyStock  int `json:"safety_stock"`
+
+	// PurchaseOrderID is the draft raised for the shortfall, if any
+	PurchaseOrderID *uint      `json:"purchase_order_id,omitempty"`
+	AcknowledgedBy  *uint      `json:"acknowledged_by,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "PENDING"
+	ReviewStatusApproved ReviewStatus = "APPROVED"
+	ReviewStatusRejected ReviewStatus = "REJECTED"
+)
+
+type Review struct {
+	gorm.Model
+	ProductID    uint         `gorm:"uniqueIndex:idx_review_product_user;not null" json:"product_id"`
+	Product      *Product     `json:"product,omitempty"`
+	UserID       uint         `gorm:"uniqueIndex:idx_review_product_user;not null" json:"user_id"`
+	User         *User        `json:"-"`
+	ReviewerName string       `json:"reviewer_name"`
+	OrderItemID  *uint        `json:"order_item_id,omitempty"`
+	Rating       int          `gorm:"not null" json:"rating"` // 1-5 stars
+	Title        string       `json:"title"`
+	Body         string       `json:"body"`
+	Status       ReviewStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+
+	// Set when the reviewer has a delivered order for the product
+	IsVerifiedPurchase bool `gorm:"default:false" json:"is_verified_purchase"`
+
+	// Votes
+	HelpfulCount    int `gorm:"default:0" json:"helpful_count"`
+	NotHelpfulCount int `gorm:"default:0" json:"not_helpful_count"`
+
+	// Moderation
+	ModeratedByID  *uint      `json:"moderated_by_id,omitempty"`
+	ModeratedAt    *time.Time `json:"moderated_at,omitempty"`
+	ModerationNote string     `json:"moderation_note,omitempty"`
+
+	Images []ReviewImage `json:"images"`
+}
+
+type ReviewImage struct {
+	gorm.Model
+	ReviewID uint   `json:"review_id"`
+	URL      string `gorm:"not null" json:"url"`
+	AltText  string `json:"alt_text"`
+}
+
+type ReviewVote struct {
+	gorm.Model
+	ReviewID  uint `gorm:"uniqueIndex:idx_review_vote_user;not null" json:"review_id"`
+	UserID    uint `gorm:"uniqueIndex:idx_review_vote_user;not null" json:"user_id"`
+	IsHelpful bool `json:"is_helpful"`
+}
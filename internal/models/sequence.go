@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+type SequenceType string
+
+const (
+	SequenceTypeOrder         SequenceType = "ORDER"
+	SequenceTypePurchaseOrder SequenceType = "PURCHASE_ORDER"
+	SequenceTypeContract      SequenceType = "CONTRACT"
+	SequenceTypeInvoice       SequenceType = "INVOICE"
+)
+
+type SequenceReset string
+
+const (
+	SequenceResetNever   SequenceReset = "NEVER"
+	SequenceResetYearly  SequenceReset = "YEARLY"
+	SequenceResetMonthly SequenceReset = "MONTHLY"
+)
+
+// NumberSequence numbers one type of document. Pattern tokens are {YYYY},
+// {YY}, {MM}, {DD} and {seq} or {seq:06} for a zero-padded counter.
+type NumberSequence struct {
+	ID        uint          `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Type      SequenceType  `gorm:"type:varchar(30);uniqueIndex;not null" json:"type"`
+	Pattern   string        `gorm:"not null" json:"pattern"`
+	Reset     SequenceReset `gorm:"type:varchar(10);not null;default:'YEARLY'" json:"reset"`
+
+	// Period is the year or month LastValue counts within
+	Period    string `gorm:"type:varchar(7)" json:"period"`
+	LastValue int64  `gorm:"not null;default:0" json:"last_value"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "gorm.io/gorm"
+
+type ShippingRateBasis string
+
+const (
+	// ShippingRateBasisWeight bands rates by total weight in kg
+	ShippingRateBasisWeight ShippingRateBasis = "WEIGHT"
+	// ShippingRateBasisValue bands rates by goods value in the base currency
+	ShippingRateBasisValue ShippingRateBasis = "VALUE"
+	// ShippingRateBasisItems bands rates by the number of items
+	ShippingRateBasisItems ShippingRateBasis = "ITEMS"
+)
+
+// ShippingMethod is a delivery service customers can choose. It is offered
+// in the zones it has rates for.
+type ShippingMethod struct {
+	gorm.Model
+	Code        string            `gorm:"type:varchar(30);uniqueIndex;not null" json:"code"`
+	Name        string            `gorm:"not null" json:"name"`
+	Description string            `json:"description"`
+	Carrier     string            `json:"carrier"`
+	IsActive    bool              `gorm:"default:true" json:"is_active"`
+	RateBasis   ShippingRateBasis `gorm:"type:varchar(10);not null;default:'WEIGHT'" json:"rate_basis"`
+	SortOrder   int               `gorm:"default:0" json:"sort_order"`
+
+	// FreeShippingThreshold is the goods value, in the base currency, from
+	// which the method is free
+	FreeShippingThreshold *float64 `json:"free_shipping_threshold,omitempty"`
+
+	// Delivery estimate in working days from dispatch
+	MinDeliveryDays int `gorm:"default:0" json:"min_delivery_days"`
+	MaxDeliveryDays int `gorm:"default:0" json:"max_delivery_days"`
+
+	// TaxClassID taxes the shipping charge; nil uses the default class
+	TaxClassID *uint `json:"tax_class_id,omitempty"`
+
+	Rates []ShippingRate `json:"rates,omitempty"`
+}
+
+// ShippingZone is an area shipping is priced for
+type ShippingZone struct {
+	gorm.Model
+	Name      string                 `gorm:"not null" json:"name"`
+	Locations []ShippingZoneLocation `gorm:"foreignKey:ZoneID" json:"locations"`
+}
+
+// ShippingZoneLocation puts a country, or the postcodes in it starting
+// with PostcodePrefix, in a zone. The longest matching prefix wins.
+type ShippingZoneLocation struct {
+	ID             uint   `gorm:"primarykey" json:"id"`
+	ZoneID         uint   `gorm:"index;not null" json:"zone_id"`
+	Country        string `gorm:"type:varchar(2);index;not null" json:"country"`
+	PostcodePrefix string `gorm:"type:varchar(20)" json:"postcode_prefix,omitempty"`
+}
+
+// ShippingRate prices a method in a zone for one band of its basis, from
+// MinValue up to but not including MaxValue. Price is in the base currency.
+type ShippingRate struct {
+	gorm.Model
+	MethodID uint          `gorm:"index;not null" json:"method_id"`
+	ZoneID   uint          `gorm:"index;not null" json:"zone_id"`
+	Zone     *ShippingZone `json:"zone,omitempty"`
+	MinValue float64       `gorm:"not null;default:0" json:"min_value"`
+	MaxValue *float64      `json:"max_value,omitempty"`
+	Price    float64       `gorm:"not null" json:"price"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type StockCountStatus string
+
+const (
+	StockCountStatusOpen      StockCountStatus = "OPEN"
+	StockCountStatusSubmitted StockCountStatus = "SUBMITTED"
+	StockCountStatusApproved  StockCountStatus = "APPROVED"
+	StockCountStatusCancelled StockCountStatus = "CANCELLED"
+)
+
+// StockCount is a stocktake or cycle count session over one warehouse,
+// optionally narrowed to categories or an ABC class. The warehouse keeps
+// trading while it is counted: each line is compared with the book
+// quantity at the time it was counted.
+type StockCount struct {
+	gorm.Model
+	WarehouseID uint             `gorm:"index;not null" json:"warehouse_id"`
+	Warehouse   Warehouse        `json:"warehouse"`
+	Status      StockCountStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	Notes       string           `json:"notes"`
+
+	// Scope
+	Categories []Category `gorm:"many2many:stock_count_categories;" json:"categories,omitempty"`
+	ABCClass   string     `gorm:"type:varchar(1)" json:"abc_class,omitempty"`
+
+	// RecountThreshold is the variance, as a percentage of the book
+	// quantity, above which a line must be counted again
+	RecountThreshold float64 `gorm:"not null" json:"recount_threshold"`
+
+	Lines []StockCountLine `gorm:"foreignKey:CountID" json:"lines,omitempty"`
+
+	CreatedByID  *uint      `json:"created_by_id,omitempty"`
+	SubmittedAt  *time.Time `json:"submitted_at,omitempty"`
+	ApprovedByID *uint      `json:"approved_by_id,omitempty"`
+	ApprovedAt   *time.Time `json:"approved_at,omitempty"`
+}
+
+// StockCountLine is the count of one inventory item. Counts are blind:
+// ExpectedQuantity and the variance are hidden until the session is
+// submitted.
+type StockCountLine struct {
+	gorm.Model
+	CountID         uint      `gorm:"index;not null" json:"count_id"`
+	InventoryItemID uint      `gorm:"index;not null" json:"inventory_item_id"`
+	ProductID       uint      `gorm:"index;not null" json:"product_id"`
+	Product         Product   `json:"product"`
+	BatchNumber     string    `json:"batch_number"`
+	ExpiryDate      time.Time `json:"expiry_date"`
+
+	// FirstCount is kept when a variance above the threshold asks for a
+	// recount; CountedQuantity is the count that stands
+	FirstCount       *int       `json:"first_count,omitempty"`
+	CountedQuantity  *int       `json:"counted_quantity,omitempty"`
+	ExpectedQuantity *int       `json:"expected_quantity,omitempty"`
+	NeedsRecount     bool       `gorm:"default:false" json:"needs_recount"`
+	CountedByID      *uint      `json:"counted_by_id,omitempty"`
+	CountedAt        *time.Time `json:"counted_at,omitempty"`
+
+	// Variance is counted less expected. Its value at CostPrice is fixed on
+	// submission and again on approval.
+	Variance      *int    `json:"variance,omitempty"`
+	UnitCost      float64 `json:"unit_cost"`
+	VarianceValue float64 `json:"variance_value"`
+
+	Reason       AdjustmentReason `gorm:"type:varchar(30)" json:"reason,omitempty"`
+	AdjustmentID *uint            `json:"adjustment_id,omitempty"`
+}
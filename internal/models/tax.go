@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaxClass groups products taxed alike, such as standard, reduced or
+// zero-rated goods. Products without a class use the default class.
+type TaxClass struct {
+	gorm.Model
+	Code        string `gorm:"type:varchar(30);uniqueIndex;not null" json:"code"`
+	Name        string `gorm:"not null" json:"name"`
+	Description string `json:"description"`
+	IsDefault   bool   `gorm:"default:false" json:"is_default"`
+
+	Rates []TaxRate `json:"rates,omitempty"`
+}
+
+type TaxTreatment string
+
+const (
+	TaxTreatmentStandard      TaxTreatment = "STANDARD"
+	TaxTreatmentZeroRated     TaxTreatment = "ZERO_RATED"
+	TaxTreatmentExempt        TaxTreatment = "EXEMPT"
+	TaxTreatmentReverseCharge TaxTreatment = "REVERSE_CHARGE"
+	// TaxTreatmentOutOfScope is used where no rate is configured
+	TaxTreatmentOutOfScope TaxTreatment = "OUT_OF_SCOPE"
+)
+
+// TaxRate is a class's rate in a country, or in one region of it, for a
+// period. Region-specific rates win over the country-wide one.
+type TaxRate struct {
+	gorm.Model
+	TaxClassID uint         `gorm:"index;not null" json:"tax_class_id"`
+	Country    string       `gorm:"type:varchar(2);index;not null" json:"country"`
+	Region     string       `gorm:"type:varchar(100)" json:"region,omitempty"`
+	Name       string       `json:"name"`
+	Rate       float64      `gorm:"not null;default:0" json:"rate"` // percent
+	Treatment  TaxTreatment `gorm:"type:varchar(20);not null;default:'STANDARD'" json:"treatment"`
+
+	EffectiveFrom time.Time  `gorm:"not null" json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+}
+
+// LineTax is the tax worked out for a document line. TaxRate is the
+// percentage charged, so zero for zero-rated, exempt and reverse-charge
+// lines; NetAmount is the line excluding tax.
+type LineTax struct {
+	TaxClassID   *uint        `json:"tax_class_id,omitempty"`
+	TaxTreatment TaxTreatment `gorm:"type:varchar(20)" json:"tax_treatment,omitempty"`
+	TaxRate      float64      `json:"tax_rate"`
+	NetAmount    float64      `json:"net_amount"`
+	TaxAmount    float64      `json:"tax_amount"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TransferStatus string
+
+const (
+	TransferStatusDraft             TransferStatus = "DRAFT"
+	TransferStatusInTransit         TransferStatus = "IN_TRANSIT"
+	TransferStatusPartiallyReceived TransferStatus = "PARTIALLY_RECEIVED"
+	TransferStatusReceived          TransferStatus = "RECEIVED"
+	TransferStatusCancelled         TransferStatus = "CANCELLED"
+)
+
+// StockTransfer moves stock from one warehouse to another. Dispatch takes
+// the stock out of the source; it is in transit until received at the
+// destination or written off as a discrepancy.
+type StockTransfer struct {
+	gorm.Model
+	SourceWarehouseID      uint           `gorm:"index;not null" json:"source_warehouse_id"`
+	SourceWarehouse        Warehouse      `json:"source_warehouse"`
+	DestinationWarehouseID uint           `gorm:"index;not null" json:"destination_warehouse_id"`
+	DestinationWarehouse   Warehouse      `json:"destination_warehouse"`
+	Status                 TransferStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	Notes                  string         `json:"notes"`
+
+	// Shipping
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+
+	Lines     []StockTransferLine         `gorm:"foreignKey:TransferID" json:"lines"`
+	History   []StockTransferStatusChange `gorm:"foreignKey:TransferID" json:"history,omitempty"`
+	Documents []Document                  `gorm:"many2many:stock_transfer_documents;" json:"documents"`
+
+	// Dates
+	ExpectedDate *time.Time `json:"expected_date"`
+	DispatchedAt *time.Time `json:"dispatched_at"`
+	ReceivedAt   *time.Time `json:"received_at"`
+}
+
+// StockTransferLine is one batch moving between warehouses. A line asked
+// for by product alone is split into one line per batch on dispatch, so
+// batch number and expiry travel with the stock.
+type StockTransferLine struct {
+	gorm.Model
+	TransferID uint    `gorm:"index;not null" json:"transfer_id"`
+	ProductID  uint    `gorm:"not null" json:"product_id"`
+	Product    Product `json:"product"`
+	Quantity   int     `gorm:"not null" json:"quantity"`
+
+	// Source batch, chosen on dispatch unless given up front
+	SourceInventoryItemID *uint     `json:"source_inventory_item_id,omitempty"`
+	BatchNumber           string    `json:"batch_number"`
+	ExpiryDate            time.Time `json:"expiry_date"`
+
+	DispatchedQuantity int `gorm:"default:0" json:"dispatched_quantity"`
+	ReceivedQuantity   int `gorm:"default:0" json:"received_quantity"`
+	// DiscrepancyQuantity is dispatched stock that will not arrive in
+	// sellable condition, as recorded in Discrepancies
+	DiscrepancyQuantity int `gorm:"default:0" json:"discrepancy_quantity"`
+
+	DestinationInventoryItemID *uint                      `json:"destination_inventory_item_id,omitempty"`
+	Discrepancies              []StockTransferDiscrepancy `gorm:"foreignKey:LineID" json:"discrepancies,omitempty"`
+}
+
+// InTransit is the dispatched quantity not yet received or written off
+func (l *StockTransferLine) InTransit() int {
+	return l.DispatchedQuantity - l.ReceivedQuantity - l.DiscrepancyQuantity
+}
+
+type DiscrepancyReason string
+
+const (
+	DiscrepancyReasonShort   DiscrepancyReason = "SHORT"
+	DiscrepancyReasonDamaged DiscrepancyReason = "DAMAGED"
+)
+
+// StockTransferDiscrepancy records dispatched units that did not arrive, or
+// arrived unsellable
+type StockTransferDiscrepancy struct {
+	gorm.Model
+	LineID       uint              `gorm:"index;not null" json:"line_id"`
+	Quantity     int               `gorm:"not null" json:"quantity"`
+	Reason       DiscrepancyReason `gorm:"type:varchar(20);not null" json:"reason"`
+	Note         string            `json:"note"`
+	RecordedByID *uint             `json:"recorded_by_id,omitempty"`
+}
+
+// StockTransferStatusChange is one entry in a transfer's status history
+type StockTransferStatusChange struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	TransferID  uint           `gorm:"index;not null" json:"transfer_id"`
+	FromStatus  TransferStatus `gorm:"type:varchar(20)" json:"from_status,omitempty"`
+	ToStatus    TransferStatus `gorm:"type:varchar(20);not null" json:"to_status"`
+	ChangedByID *uint          `json:"changed_by_id,omitempty"`
+	Note        string         `json:"note,omitempty"`
+}
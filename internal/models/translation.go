@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Translations hold per-locale copies of catalog text. The fields on the
+// translated record itself are in the default locale.
+
+type ProductTranslation struct {
+	gorm.Model
+	ProductID   uint   `gorm:"uniqueIndex:idx_product_translation_locale;not null" json:"product_id"`
+	Locale      string `gorm:"type:varchar(10);uniqueIndex:idx_product_translation_locale;not null" json:"locale"`
+	Name        string `gorm:"not null" json:"name"`
+	Description string `json:"description"`
+}
+
+type CategoryTranslation struct {
+	gorm.Model
+	CategoryID  uint   `gorm:"uniqueIndex:idx_category_translation_locale;not null" json:"category_id"`
+	Locale      string `gorm:"type:varchar(10);uniqueIndex:idx_category_translation_locale;not null" json:"locale"`
+	Name        string `gorm:"not null" json:"name"`
+	Description string `json:"description"`
+}
+
+type ProductSpecificationTranslation struct {
+	gorm.Model
+	SpecificationID uint   `gorm:"uniqueIndex:idx_specification_translation_locale;not null" json:"specification_id"`
+	Locale          string `gorm:"type:varchar(10);uniqueIndex:idx_specification_translation_locale;not null" json:"locale"`
+	Name            string `gorm:"not null" json:"name"`
+	Value           string `json:"value"` // text and enum specs only
+}
+
+// ProductSearchDocument is the full-text index of a product in one locale,
+// built with that locale's text search configuration
+type ProductSearchDocument struct {
+	ID        uint   `gorm:"primarykey"`
+	ProductID uint   `gorm:"uniqueIndex:idx_product_search_locale;not null"`
+	Locale    string `gorm:"type:varchar(10);uniqueIndex:idx_product_search_locale;not null"`
+	Document  string `gorm:"type:tsvector;index:idx_product_search_document,type:gin"`
+	UpdatedAt time.Time
+}
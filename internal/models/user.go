@@ -45,6 +45,13 @@ type Company struct {
 	CreditLimit        float64 `json:"credit_limit"`
 	PaymentTerms       int     `json:"payment_terms"` // in days
 
+	// MinShelfLifeDays is the least remaining shelf life, in days, that
+	// perishable stock shipped to this company must have
+	MinShelfLifeDays int `gorm:"default:0" json:"min_shelf_life_days"`
+
+	// PriceListID is the price list the company buys from, if any
+	PriceListID *uint `gorm:"index" json:"price_list_id,omitempty"`
+
 	// Address
 	AddressID uint `json:"address_id"`
 
@@ -62,6 +69,10 @@ type Address struct {
 	Country        string `gorm:"not null" json:"country"`
 	IsDefault      bool   `gorm:"default:false" json:"is_default"`
 
-	// Relations
-	UserID uint `json:"user_id"`
+	// Coordinates, used to find the nearest warehouse
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+
+	// Relations; nil for warehouse and supplier addresses
+	UserID *uint `json:"user_id"`
 }
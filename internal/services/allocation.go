@@ -0,0 +1,364 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AllocationStrategy string
+
+const (
+	// AllocationStrategyFewestShipments covers the order from as few
+	// warehouses as possible
+	AllocationStrategyFewestShipments AllocationStrategy = "fewest_shipments"
+	// AllocationStrategyNearest prefers warehouses closest to the shipping
+	// address
+	AllocationStrategyNearest AllocationStrategy = "nearest"
+	// AllocationStrategyPriority follows Warehouse.Priority
+	AllocationStrategyPriority AllocationStrategy = "priority"
+)
+
+var ErrUnknownStrategy = errors.New("unknown allocation strategy")
+
+// StockDemand is a quantity of one product to allocate. Key lets callers
+// match results back to their own lines.
+type StockDemand struct {
+	Key       int  `json:"-"`
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+// Allocation is part of a demand served from one inventory item
+type Allocation struct {
+	InventoryItemID uint `json:"inventory_item_id"`
+	WarehouseID     uint `json:"warehouse_id"`
+	Quantity        int  `json:"quantity"`
+}
+
+type DemandAllocation struct {
+	StockDemand
+	Allocations []Allocation `json:"allocations"`
+	Shortfall   int          `json:"shortfall"`
+}
+
+// AllocationOptions control how PlanAllocation chooses stock
+type AllocationOptions struct {
+	Strategy    AllocationStrategy
+	Destination *models.Address
+	// MinShelfLifeDays skips perishable batches expiring sooner than this
+	MinShelfLifeDays int
+	Now              time.Time
+	// Lock the chosen inventory rows FOR UPDATE, for plans about to be
+	// reserved
+	Lock bool
+}
+
+type AllocationPlan struct {
+	Strategy     AllocationStrategy `json:"strategy"`
+	Demands      []DemandAllocation `json:"lines"`
+	WarehouseIDs []uint             `json:"warehouse_ids"`
+}
+
+// Complete reports whether every demand was fully allocated
+func (p *AllocationPlan) Complete() bool {
+	for _, demand := range p.Demands {
+		if demand.Shortfall > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// allocationStrategies maps a strategy to how it orders warehouses and
+// whether it re-picks the best warehouse after each one is used
+var allocationStrategies = map[AllocationStrategy]bool{
+	AllocationStrategyFewestShipments: true,
+	AllocationStrategyNearest:         false,
+	AllocationStrategyPriority:        false,
+}
+
+// ParseAllocationStrategy validates value, defaulting to priority
+func ParseAllocationStrategy(value string) (AllocationStrategy, error) {
+	if value == "" {
+		return AllocationStrategyPriority, nil
+	}
+	strategy := AllocationStrategy(strings.ToLower(value))
+	if _, ok := allocationStrategies[strategy]; !ok {
+		return "", ErrUnknownStrategy
+	}
+	return strategy, nil
+}
+
+// stockPool is the free stock that can serve a plan, by warehouse and
+// product, with batches in the order they should be used
+type stockPool struct {
+	warehouses []models.Warehouse
+	batches    map[uint]map[uint][]*models.InventoryItem
+	free       map[uint]int
+}
+
+func (p *stockPool) available(warehouseID, productID uint) int {
+	var total int
+	for _, item := range p.batches[warehouseID][productID] {
+		total += p.free[item.ID]
+	}
+	return total
+}
+
+// take allocates up to quantity of a product from one warehouse
+func (p *stockPool) take(warehouseID, productID uint, quantity int) []Allocation {
+	var allocations []Allocation
+	for _, item := range p.batches[warehouseID][productID] {
+		if quantity == 0 {
+			break
+		}
+		n := p.free[item.ID]
+		if n > quantity {
+			n = quantity
+		}
+		if n == 0 {
+			continue
+		}
+		p.free[item.ID] -= n
+		quantity -= n
+		allocations = append(allocations, Allocation{InventoryItemID: item.ID, WarehouseID: warehouseID, Quantity: n})
+	}
+	return allocations
+}
+
+// loadStockPool reads the sellable, unreserved stock for the given
+// products in active warehouses. Batches are used first-expired-first-out,
+// with non-perishable stock last.
+func loadStockPool(db *gorm.DB, productIDs []uint, opts AllocationOptions) (*stockPool, error) {
+	var items []models.InventoryItem
+	query := db.Joins("JOIN warehouses ON warehouses.id = inventory_items.warehouse_id AND warehouses.deleted_at IS NULL AND warehouses.is_active").
+		Scopes(sellableStock(opts.Now.AddDate(0, 0, opts.MinShelfLifeDays))).
+		Where("inventory_items.product_id IN ? AND inventory_items.quantity > inventory_items.reserved", productIDs).
+		Order("inventory_items.id")
+	// Rows are locked in id order whatever order they are used in
+	if opts.Lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "inventory_items"}})
+	}
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	pool := &stockPool{
+		batches: make(map[uint]map[uint][]*models.InventoryItem),
+		free:    make(map[uint]int, len(items)),
+	}
+	var warehouseIDs []uint
+	for i := range items {
+		item := &items[i]
+		if pool.batches[item.WarehouseID] == nil {
+			pool.batches[item.WarehouseID] = make(map[uint][]*models.InventoryItem)
+			warehouseIDs = append(warehouseIDs, item.WarehouseID)
+		}
+		pool.batches[item.WarehouseID][item.ProductID] = append(pool.batches[item.WarehouseID][item.ProductID], item)
+		pool.free[item.ID] = item.Quantity - item.Reserved
+	}
+	for _, products := range pool.batches {
+		for _, batches := range products {
+			sort.SliceStable(batches, func(i, j int) bool { return fefoLess(batches[i], batches[j]) })
+		}
+	}
+
+	if len(warehouseIDs) > 0 {
+		if err := db.Preload("Address").Where("id IN ?", warehouseIDs).Find(&pool.warehouses).Error; err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// PlanAllocation decides which inventory items serve each demand. Demands
+// that cannot be met in full are allocated as far as possible and report a
+// Shortfall.
+func PlanAllocation(db *gorm.DB, demands []StockDemand, opts AllocationOptions) (*AllocationPlan, error) {
+	strategy := opts.Strategy
+	repick, ok := allocationStrategies[strategy]
+	if !ok {
+		return nil, ErrUnknownStrategy
+	}
+
+	var productIDs []uint
+	seen := make(map[uint]bool)
+	for _, demand := range demands {
+		if !seen[demand.ProductID] {
+			seen[demand.ProductID] = true
+			productIDs = append(productIDs, demand.ProductID)
+		}
+	}
+
+	plan := &AllocationPlan{Strategy: strategy, Demands: make([]DemandAllocation, len(demands)), WarehouseIDs: []uint{}}
+	for i, demand := range demands {
+		plan.Demands[i] = DemandAllocation{StockDemand: demand, Allocations: []Allocation{}, Shortfall: demand.Quantity}
+	}
+	if len(productIDs) == 0 {
+		return plan, nil
+	}
+
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	pool, err := loadStockPool(db, productIDs, opts)
+	if err != nil {
+		return nil, err
+	}
+	ranked := rankWarehouses(pool.warehouses, strategy, opts.Destination)
+
+	used := make(map[uint]bool)
+	for len(ranked) > 0 {
+		next := 0
+		if repick {
+			next = bestCoveringWarehouse(pool, ranked, plan.Demands)
+			if next < 0 {
+				break
+			}
+		}
+		warehouse := ranked[next]
+		ranked = append(ranked[:next], ranked[next+1:]...)
+
+		for i := range plan.Demands {
+			demand := &plan.Demands[i]
+			if demand.Shortfall == 0 {
+				continue
+			}
+			allocations := pool.take(warehouse.ID, demand.ProductID, demand.Shortfall)
+			for _, allocation := range allocations {
+				demand.Shortfall -= allocation.Quantity
+				demand.Allocations = append(demand.Allocations, allocation)
+			}
+			if len(allocations) > 0 && !used[warehouse.ID] {
+				used[warehouse.ID] = true
+				plan.WarehouseIDs = append(plan.WarehouseIDs, warehouse.ID)
+			}
+		}
+		if plan.Complete() {
+			break
+		}
+	}
+
+	return plan, nil
+}
+
+// bestCoveringWarehouse returns the index of the ranked warehouse that can
+// supply the most outstanding units, preferring earlier ranks on ties, or
+// -1 when none can supply anything
+func bestCoveringWarehouse(pool *stockPool, ranked []models.Warehouse, demands []DemandAllocation) int {
+	best, bestUnits := -1, 0
+	for i, warehouse := range ranked {
+		// Demands for the same product share the warehouse's stock
+		free := make(map[uint]int)
+		var units int
+		for _, demand := range demands {
+			if demand.Shortfall == 0 {
+				continue
+			}
+			if _, ok := free[demand.ProductID]; !ok {
+				free[demand.ProductID] = pool.available(warehouse.ID, demand.ProductID)
+			}
+			n := free[demand.ProductID]
+			if n > demand.Shortfall {
+				n = demand.Shortfall
+			}
+			free[demand.ProductID] -= n
+			units += n
+		}
+		if units > bestUnits {
+			best, bestUnits = i, units
+		}
+	}
+	return best
+}
+
+// rankWarehouses orders warehouses by preference for the strategy. Ties,
+// and warehouses without coordinates for nearest, fall back to priority.
+func rankWarehouses(warehouses []models.Warehouse, strategy AllocationStrategy, destination *models.Address) []models.Warehouse {
+	ranked := append([]models.Warehouse(nil), warehouses...)
+	byPriority := func(a, b models.Warehouse) bool {
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.ID < b.ID
+	}
+
+	if strategy != AllocationStrategyNearest || destination == nil {
+		sort.SliceStable(ranked, func(i, j int) bool { return byPriority(ranked[i], ranked[j]) })
+		return ranked
+	}
+
+	distance := func(w models.Warehouse) float64 {
+		if destination.Latitude != nil && destination.Longitude != nil &&
+			w.Address.Latitude != nil && w.Address.Longitude != nil {
+			return haversineKm(*destination.Latitude, *destination.Longitude, *w.Address.Latitude, *w.Address.Longitude)
+		}
+		// Without coordinates a warehouse in the same country is assumed
+		// closer than one abroad
+		if strings.EqualFold(w.Address.Country, destination.Country) {
+			return math.MaxFloat64 / 2
+		}
+		return math.MaxFloat64
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		di, dj := distance(ranked[i]), distance(ranked[j])
+		if di != dj {
+			return di < dj
+		}
+		return byPriority(ranked[i], ranked[j])
+	})
+	return ranked
+}
+
+// fefoLess orders batches by expiry, earliest first, with non-perishable
+// batches after all perishable ones
+func fefoLess(a, b *models.InventoryItem) bool {
+	if a.Perishable() != b.Perishable() {
+		return a.Perishable()
+	}
+	if !a.ExpiryDate.Equal(b.ExpiryDate) {
+		return a.ExpiryDate.Before(b.ExpiryDate)
+	}
+	return a.ID < b.ID
+}
+
+// haversineKm is the great-circle distance between two points
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// ReserveAllocation reserves one planned allocation against its inventory
+// item. The check and the increment are a single conditional update, so
+// concurrent reservations cannot take the same units twice even when the
+// plan was made without locks.
+func ReserveAllocation(tx *gorm.DB, productID uint, allocation Allocation, ref MovementRef) error {
+	result := tx.Model(&models.InventoryItem{}).
+		Where("id = ? AND status = ? AND quantity - reserved >= ?", allocation.InventoryItemID, models.InventoryStatusActive, allocation.Quantity).
+		Update("reserved", gorm.Expr("reserved + ?", allocation.Quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var inventory models.InventoryItem
+	if err := tx.First(&inventory, allocation.InventoryItemID).Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return &StockError{ProductID: productID, Requested: allocation.Quantity, Available: inventory.Quantity - inventory.Reserved}
+	}
+	_, err := recordMovement(tx, &inventory, models.MovementTypeReservation, 0, allocation.Quantity, ref)
+	return err
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// availabilityCache keeps computed availability per stocked product.
+// Bundles are worked out from their components' entries. Entries are
+// dropped once the transaction that moved stock commits.
+type availabilityCache struct {
+	mu      sync.RWMutex
+	entries map[uint]*models.ProductAvailability
+}
+
+var availability = &availabilityCache{entries: make(map[uint]*models.ProductAvailability)}
+
+func (c *availabilityCache) get(productID uint, now time.Time) (*models.ProductAvailability, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[productID]
+	if !ok || now.Sub(entry.ComputedAt) >= settings.AvailabilityCacheTTL {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *availabilityCache) put(entries map[uint]*models.ProductAvailability) {
+	if settings.AvailabilityCacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for productID, entry := range entries {
+		c.entries[productID] = entry
+	}
+}
+
+// InvalidateAvailability drops cached availability for the products
+func InvalidateAvailability(productIDs ...uint) {
+	availability.mu.Lock()
+	defer availability.mu.Unlock()
+	for _, productID := range productIDs {
+		delete(availability.entries, productID)
+	}
+}
+
+// invalidateAvailabilityAfterCommit drops cached availability for the
+// products once tx commits. Dropping it earlier would let a read racing the
+// commit cache the old figure again.
+func invalidateAvailabilityAfterCommit(tx *gorm.DB, productIDs ...uint) {
+	AfterCommit(tx, func() { InvalidateAvailability(productIDs...) })
+}
+
+// InvalidateAllAvailability drops all cached availability, for changes
+// such as a warehouse closing that affect every product
+func InvalidateAllAvailability() {
+	availability.mu.Lock()
+	defer availability.mu.Unlock()
+	availability.entries = make(map[uint]*models.ProductAvailability)
+}
+
+// inboundPromiseStatuses are the purchase order statuses customers are
+// promised stock from. Drafts are only suggestions.
+var inboundPromiseStatuses = []models.POStatus{
+	models.POStatusPending,
+	models.POStatusApproved,
+	models.POStatusOrdered,
+	models.POStatusShipped,
+}
+
+// ProductAvailabilities returns availability for the given products, keyed
+// by product ID. Unknown products are left out.
+func ProductAvailabilities(db *gorm.DB, productIDs []uint, now time.Time) (map[uint]*models.ProductAvailability, error) {
+	result := make(map[uint]*models.ProductAvailability, len(productIDs))
+	if len(productIDs) == 0 {
+		return result, nil
+	}
+
+	var products []models.Product
+	if err := db.Select("id", "is_bundle").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	var bundleIDs []uint
+	stocked := make(map[uint]bool)
+	for _, product := range products {
+		if product.IsBundle {
+			bundleIDs = append(bundleIDs, product.ID)
+		} else {
+			stocked[product.ID] = true
+		}
+	}
+	var components []models.BundleComponent
+	if len(bundleIDs) > 0 {
+		if err := db.Where("bundle_id IN ?", bundleIDs).Order("id").Find(&components).Error; err != nil {
+			return nil, err
+		}
+		for _, component := range components {
+			stocked[component.ComponentID] = true
+		}
+	}
+
+	entries := make(map[uint]*models.ProductAvailability, len(stocked))
+	var missing []uint
+	for productID := range stocked {
+		if entry, ok := availability.get(productID, now); ok {
+			entries[productID] = entry
+		} else {
+			missing = append(missing, productID)
+		}
+	}
+	if len(missing) > 0 {
+		computed, err := computeAvailability(db, missing, now)
+		if err != nil {
+			return nil, err
+		}
+		availability.put(computed)
+		for productID, entry := range computed {
+			entries[productID] = entry
+		}
+	}
+
+	byBundle := make(map[uint][]models.BundleComponent)
+	for _, component := range components {
+		byBundle[component.BundleID] = append(byBundle[component.BundleID], component)
+	}
+	for _, product := range products {
+		if product.IsBundle {
+			result[product.ID] = bundleAvailability(product.ID, byBundle[product.ID], entries, now)
+		} else {
+			result[product.ID] = entries[product.ID]
+		}
+	}
+	return result, nil
+}
+
+// AttachAvailability fills in Availability on each product
+func AttachAvailability(db *gorm.DB, products []models.Product) error {
+	productIDs := make([]uint, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	availabilities, err := ProductAvailabilities(db, productIDs, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Availability = availabilities[products[i].ID]
+	}
+	return nil
+}
+
+type warehouseStock struct {
+	ProductID   uint
+	WarehouseID uint
+	OnHand      int
+	Reserved    int
+}
+
+type inboundRow struct {
+	ProductID    uint
+	ReferenceID  uint
+	WarehouseID  *uint
+	Quantity     int
+	ExpectedDate *time.Time
+}
+
+// computeAvailability reads stock, open purchase orders and transfers in
+// transit for plain products
+func computeAvailability(db *gorm.DB, productIDs []uint, now time.Time) (map[uint]*models.ProductAvailability, error) {
+	entries := make(map[uint]*models.ProductAvailability, len(productIDs))
+	for _, productID := range productIDs {
+		entries[productID] = &models.ProductAvailability{
+			ProductID:  productID,
+			Inbound:    []models.InboundStock{},
+			ComputedAt: now,
+		}
+	}
+
+	var stock []warehouseStock
+	if err := db.Model(&models.InventoryItem{}).
+		Select("inventory_items.product_id, inventory_items.warehouse_id, SUM(inventory_items.quantity) AS on_hand, SUM(inventory_items.reserved) AS reserved").
+		Joins("JOIN warehouses ON warehouses.id = inventory_items.warehouse_id AND warehouses.deleted_at IS NULL AND warehouses.is_active").
+		Scopes(sellableStock(now)).
+		Where("inventory_items.product_id IN ?", productIDs).
+		Group("inventory_items.product_id, inventory_items.warehouse_id").
+		Order("inventory_items.warehouse_id").
+		Scan(&stock).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range stock {
+		entry := entries[row.ProductID]
+		available := row.OnHand - row.Reserved
+		entry.OnHand += row.OnHand
+		entry.Reserved += row.Reserved
+		entry.Available += available
+		entry.Warehouses = append(entry.Warehouses, models.WarehouseAvailability{
+			WarehouseID: row.WarehouseID,
+			OnHand:      row.OnHand,
+			Reserved:    row.Reserved,
+			Available:   available,
+		})
+	}
+
+	var purchases []inboundRow
+	if err := db.Model(&models.POItem{}).
+		Select(`po_items.product_id, purchase_orders.id AS reference_id, purchase_orders.warehouse_id,
+			SUM(GREATEST(po_items.quantity - po_items.received_quantity, 0)) AS quantity,
+			NULLIF(purchase_orders.expected_date, ?) AS expected_date`, time.Time{}).
+		Joins("JOIN purchase_orders ON purchase_orders.id = po_items.po_id AND purchase_orders.deleted_at IS NULL").
+		Joins("LEFT JOIN warehouses ON warehouses.id = purchase_orders.warehouse_id").
+		Where("po_items.product_id IN ? AND purchase_orders.status IN ?", productIDs, inboundPromiseStatuses).
+		Where("purchase_orders.warehouse_id IS NULL OR (warehouses.deleted_at IS NULL AND warehouses.is_active)").
+		Group("po_items.product_id, purchase_orders.id, purchase_orders.warehouse_id, purchase_orders.expected_date").
+		Having("SUM(GREATEST(po_items.quantity - po_items.received_quantity, 0)) > 0").
+		Scan(&purchases).Error; err != nil {
+		return nil, err
+	}
+	var transfers []inboundRow
+	if err := db.Model(&models.StockTransferLine{}).
+		Select(`stock_transfer_lines.product_id, stock_transfers.id AS reference_id, stock_transfers.destination_warehouse_id AS warehouse_id,
+			SUM(stock_transfer_lines.dispatched_quantity - stock_transfer_lines.received_quantity - stock_transfer_lines.discrepancy_quantity) AS quantity,
+			stock_transfers.expected_date`).
+		Joins("JOIN stock_transfers ON stock_transfers.id = stock_transfer_lines.transfer_id AND stock_transfers.deleted_at IS NULL").
+		Joins("JOIN warehouses ON warehouses.id = stock_transfers.destination_warehouse_id AND warehouses.deleted_at IS NULL AND warehouses.is_active").
+		Where("stock_transfer_lines.product_id IN ? AND stock_transfers.status IN ?", productIDs,
+			[]models.TransferStatus{models.TransferStatusInTransit, models.TransferStatusPartiallyReceived}).
+		Group("stock_transfer_lines.product_id, stock_transfers.id, stock_transfers.destination_warehouse_id, stock_transfers.expected_date").
+		Having("SUM(stock_transfer_lines.dispatched_quantity - stock_transfer_lines.received_quantity - stock_transfer_lines.discrepancy_quantity) > 0").
+		Scan(&transfers).Error; err != nil {
+		return nil, err
+	}
+
+	addInbound := func(rows []inboundRow, source models.InboundSource) {
+		for _, row := range rows {
+			entry := entries[row.ProductID]
+			entry.Inbound = append(entry.Inbound, models.InboundStock{
+				Source:       source,
+				ReferenceID:  row.ReferenceID,
+				WarehouseID:  row.WarehouseID,
+				Quantity:     row.Quantity,
+				ExpectedDate: row.ExpectedDate,
+			})
+			entry.InboundQuantity += row.Quantity
+		}
+	}
+	addInbound(purchases, models.InboundSourcePurchaseOrder)
+	addInbound(transfers, models.InboundSourceTransfer)
+
+	today := startOfDay(now)
+	for _, entry := range entries {
+		// Soonest first, undated last
+		sort.SliceStable(entry.Inbound, func(i, j int) bool {
+			a, b := entry.Inbound[i].ExpectedDate, entry.Inbound[j].ExpectedDate
+			if a == nil || b == nil {
+				return a != nil
+			}
+			return a.Before(*b)
+		})
+		entry.InStock = entry.Available > 0
+		if entry.InStock {
+			entry.EarliestShipDate = &today
+			continue
+		}
+		if len(entry.Inbound) > 0 && entry.Inbound[0].ExpectedDate != nil {
+			shipDate := startOfDay(*entry.Inbound[0].ExpectedDate)
+			if shipDate.Before(today) {
+				shipDate = today
+			}
+			entry.EarliestShipDate = &shipDate
+		}
+	}
+	return entries, nil
+}
+
+// bundleAvailability counts the whole bundles the components make up
+func bundleAvailability(bundleID uint, components []models.BundleComponent, entries map[uint]*models.ProductAvailability, now time.Time) *models.ProductAvailability {
+	bundle := &models.ProductAvailability{
+		ProductID:  bundleID,
+		IsBundle:   true,
+		Inbound:    []models.InboundStock{},
+		ComputedAt: now,
+	}
+	for i, component := range components {
+		entry := entries[component.ComponentID]
+		onHand, available := entry.OnHand/component.Quantity, entry.Available/component.Quantity
+		if i == 0 || onHand < bundle.OnHand {
+			bundle.OnHand = onHand
+		}
+		if i == 0 || available < bundle.Available {
+			bundle.Available = available
+		}
+	}
+	if bundle.Available < 0 {
+		bundle.Available = 0
+	}
+	bundle.Reserved = bundle.OnHand - bundle.Available
+	bundle.InStock = bundle.Available > 0
+	if bundle.InStock {
+		today := startOfDay(now)
+		bundle.EarliestShipDate = &today
+	}
+	return bundle
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
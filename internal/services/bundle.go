@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// BundleLine is one component of an ordered bundle line
+type BundleLine struct {
+	ProductID   uint
+	Quantity    int
+	UnitPrice   float64
+	TotalAmount float64
+}
+
+// bundleUnitPrice prices a SUM_MINUS_DISCOUNT bundle from its components
+func bundleUnitPrice(db *gorm.DB, bundle *models.Product, currency string, rate float64, b2b bool) (float64, error) {
+	components, err := bundleComponents(db, bundle)
+	if err != nil {
+		return 0, err
+	}
+	if len(components) == 0 {
+		return 0, ErrInvalidBundle
+	}
+
+	var sum float64
+	for _, component := range components {
+		var product models.Product
+		if err := db.First(&product, component.ComponentID).Error; err != nil {
+			return 0, err
+		}
+		price, err := ProductUnitPrice(db, &product, currency, rate, b2b)
+		if err != nil {
+			return 0, err
+		}
+		sum += price * float64(component.Quantity)
+	}
+
+	return RoundMoney(sum * (1 - bundle.BundleDiscountPercent/100)), nil
+}
+
+// SplitBundleLine spreads a bundle line total across its components in
+// proportion to their list prices, so invoices can show what each
+// component was sold for. Rounding differences land on the last component.
+func SplitBundleLine(db *gorm.DB, bundle *models.Product, quantity int, lineTotal float64, currency string, rate float64, b2b bool) ([]BundleLine, error) {
+	components, err := bundleComponents(db, bundle)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, ErrInvalidBundle
+	}
+
+	lines := make([]BundleLine, len(components))
+	var listTotal float64
+	for i, component := range components {
+		var product models.Product
+		if err := db.First(&product, component.ComponentID).Error; err != nil {
+			return nil, err
+		}
+		price, err := ProductUnitPrice(db, &product, currency, rate, b2b)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = BundleLine{
+			ProductID:   component.ComponentID,
+			Quantity:    component.Quantity * quantity,
+			TotalAmount: price * float64(component.Quantity*quantity),
+		}
+		listTotal += lines[i].TotalAmount
+	}
+
+	remaining := lineTotal
+	for i := range lines {
+		share := lineTotal / float64(len(lines))
+		if listTotal > 0 {
+			share = lineTotal * lines[i].TotalAmount / listTotal
+		}
+		if i == len(lines)-1 {
+			share = remaining
+		}
+		lines[i].TotalAmount = RoundMoney(share)
+		lines[i].UnitPrice = RoundMoney(lines[i].TotalAmount / float64(lines[i].Quantity))
+		remaining -= lines[i].TotalAmount
+	}
+
+	return lines, nil
+}
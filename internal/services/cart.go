@@ -0,0 +1,647 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCartNotFound  = errors.New("cart not found")
+	ErrCartEmpty     = errors.New("cart is empty")
+	ErrCartNoAddress = errors.New("cart has no shipping address")
+	ErrCartChanged   = errors.New("cart total has changed")
+)
+
+// CartOwner is who a cart request is for: a logged-in user, or the holder
+// of an anonymous cart's token
+type CartOwner struct {
+	UserID *uint
+	Token  string
+}
+
+// FindCart returns the owner's active cart. A token only finds anonymous
+// carts; once a cart belongs to a user, only they can open it. lock takes
+// the cart FOR UPDATE.
+func FindCart(db *gorm.DB, owner CartOwner, lock bool) (*models.Cart, error) {
+	query := db.Where("status = ?", models.CartStatusActive)
+	switch {
+	case owner.UserID != nil:
+		query = query.Where("user_id = ?", *owner.UserID)
+	case owner.Token != "":
+		query = query.Where("token = ? AND user_id IS NULL", owner.Token)
+	default:
+		return nil, ErrCartNotFound
+	}
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var cart models.Cart
+	if err := query.Order("id").First(&cart).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCartNotFound
+		}
+		return nil, err
+	}
+	if err := loadCartLines(db, &cart); err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// OpenCart returns the owner's cart, starting one if they have none
+func OpenCart(tx *gorm.DB, owner CartOwner) (*models.Cart, error) {
+	cart, err := FindCart(tx, owner, true)
+	if !errors.Is(err, ErrCartNotFound) {
+		return cart, err
+	}
+
+	token, err := newCartToken()
+	if err != nil {
+		return nil, err
+	}
+	cart = &models.Cart{Token: token, UserID: owner.UserID, Status: models.CartStatusActive}
+	// A user's cart may have been started concurrently; use that one
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(cart).Error; err != nil {
+		return nil, err
+	}
+	if cart.ID == 0 {
+		return FindCart(tx, owner, true)
+	}
+	return cart, nil
+}
+
+// SetCartItem puts quantity of the product in the cart, or adds it to what
+// is there when add is set. A quantity of zero removes the product.
+func SetCartItem(tx *gorm.DB, cart *models.Cart, productID uint, quantity int, add bool) error {
+	var item *models.CartItem
+	for i := range cart.Items {
+		if cart.Items[i].ProductID == productID {
+			item = &cart.Items[i]
+		}
+	}
+	if add && item != nil {
+		quantity += item.Quantity
+	}
+
+	if quantity <= 0 {
+		if item == nil {
+			return nil
+		}
+		if err := tx.Delete(item).Error; err != nil {
+			return err
+		}
+		return loadCartLines(tx, cart)
+	}
+
+	var product models.Product
+	if err := tx.Select("id", "is_active").First(&product, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+		}
+		return err
+	}
+	if !product.IsActive {
+		return fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+
+	if item == nil {
+		item = &models.CartItem{CartID: cart.ID, ProductID: productID}
+	}
+	item.Quantity = quantity
+	if err := tx.Save(item).Error; err != nil {
+		return err
+	}
+	return loadCartLines(tx, cart)
+}
+
+// MergeCart moves the anonymous cart with token into the user's cart when
+// they log in. Quantities of products in both are added together. If the
+// user has no cart, the anonymous one becomes theirs. An unknown or
+// already merged token leaves the user's cart as it is.
+func MergeCart(tx *gorm.DB, token string, userID uint) (*models.Cart, error) {
+	owner := CartOwner{UserID: &userID}
+	anonymous, err := FindCart(tx, CartOwner{Token: token}, true)
+	if errors.Is(err, ErrCartNotFound) {
+		return OpenCart(tx, owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := FindCart(tx, owner, true)
+	if errors.Is(err, ErrCartNotFound) {
+		anonymous.UserID = &userID
+		if err := tx.Model(anonymous).Update("user_id", userID).Error; err != nil {
+			return nil, err
+		}
+		return anonymous, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range anonymous.Items {
+		if err := SetCartItem(tx, cart, item.ProductID, item.Quantity, true); err != nil {
+			if errors.Is(err, ErrProductNotFound) {
+				continue
+			}
+			return nil, err
+		}
+	}
+	for _, coupon := range anonymous.Coupons {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.CartCoupon{CartID: cart.ID, Code: coupon.Code}).Error; err != nil {
+			return nil, err
+		}
+	}
+	updates := map[string]interface{}{}
+	if cart.Currency == "" && anonymous.Currency != "" {
+		updates["currency"] = anonymous.Currency
+	}
+	if cart.ShippingAddressID == nil && cart.Country == "" && anonymous.Country != "" {
+		updates["country"] = anonymous.Country
+		updates["postal_code"] = anonymous.PostalCode
+	}
+	if cart.ShippingMethod == "" && anonymous.ShippingMethod != "" {
+		updates["shipping_method"] = anonymous.ShippingMethod
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(cart).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Model(anonymous).Update("status", models.CartStatusMerged).Error; err != nil {
+		return nil, err
+	}
+	return FindCart(tx, owner, false)
+}
+
+// CartSettings change where a cart ships and what it is priced in. Nil
+// fields are left as they are; empty strings clear them.
+type CartSettings struct {
+	Currency          *string
+	ShippingAddressID *uint
+	Country           *string
+	PostalCode        *string
+	ShippingMethod    *string
+}
+
+// UpdateCart applies the settings. A saved address must be the cart
+// owner's; choosing one replaces an estimated country and postcode.
+func UpdateCart(tx *gorm.DB, cart *models.Cart, settings CartSettings) error {
+	updates := map[string]interface{}{}
+	if settings.Currency != nil {
+		currency := ""
+		if *settings.Currency != "" {
+			resolved, err := ResolveCurrency(tx, *settings.Currency)
+			if err != nil {
+				return err
+			}
+			currency = resolved
+		}
+		if currency != cart.Currency {
+			updates["currency"] = currency
+			// Prices shown in the old currency are not comparable
+			if err := tx.Model(&models.CartItem{}).Where("cart_id = ?", cart.ID).
+				UpdateColumn("unit_price", 0).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if settings.ShippingAddressID != nil {
+		if *settings.ShippingAddressID == 0 {
+			updates["shipping_address_id"] = nil
+		} else {
+			var address models.Address
+			if cart.UserID == nil || tx.Where("id = ? AND user_id = ?", *settings.ShippingAddressID, *cart.UserID).
+				First(&address).Error != nil {
+				return ErrAddressNotFound
+			}
+			updates["shipping_address_id"] = address.ID
+			updates["country"] = ""
+			updates["postal_code"] = ""
+		}
+	}
+	if settings.Country != nil {
+		updates["country"] = strings.ToUpper(strings.TrimSpace(*settings.Country))
+	}
+	if settings.PostalCode != nil {
+		updates["postal_code"] = strings.TrimSpace(*settings.PostalCode)
+	}
+	if settings.ShippingMethod != nil {
+		updates["shipping_method"] = strings.TrimSpace(*settings.ShippingMethod)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := tx.Model(cart).Updates(updates).Error; err != nil {
+		return err
+	}
+	return tx.First(cart, cart.ID).Error
+}
+
+// AddCartCoupon enters a coupon code on the cart and prices it, refusing
+// the code with a CouponError if it does not apply
+func AddCartCoupon(tx *gorm.DB, cart *models.Cart, code string, now time.Time) (*CartPricing, error) {
+	code = NormalizeCouponCode(code)
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.CartCoupon{CartID: cart.ID, Code: code}).Error; err != nil {
+		return nil, err
+	}
+	if err := loadCartLines(tx, cart); err != nil {
+		return nil, err
+	}
+
+	pricing, err := PriceCart(tx, cart, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range pricing.Warnings {
+		if warning.Type == CartWarningCouponRemoved && warning.Code == code {
+			return nil, &CouponError{Code: code, Reason: warning.reason}
+		}
+	}
+	return pricing, SaveCartPricing(tx, cart, pricing)
+}
+
+// SaveCartPricing remembers the prices the customer was shown and drops
+// the coupons that no longer apply, once the cart is being changed anyway
+func SaveCartPricing(tx *gorm.DB, cart *models.Cart, pricing *CartPricing) error {
+	for _, warning := range pricing.Warnings {
+		if warning.Type != CartWarningCouponRemoved {
+			continue
+		}
+		if err := tx.Where("cart_id = ? AND code = ?", cart.ID, warning.Code).Delete(&models.CartCoupon{}).Error; err != nil {
+			return err
+		}
+		cart.Coupons = removeCartCoupon(cart.Coupons, warning.Code)
+	}
+
+	prices := make(map[uint]float64, len(pricing.Lines))
+	for _, line := range pricing.Lines {
+		prices[line.ProductID] = line.UnitPrice
+	}
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		price, ok := prices[item.ProductID]
+		if !ok || price == item.UnitPrice {
+			continue
+		}
+		if err := tx.Model(item).UpdateColumn("unit_price", price).Error; err != nil {
+			return err
+		}
+		item.UnitPrice = price
+	}
+	return nil
+}
+
+// RemoveCartCoupon takes a coupon code off the cart
+func RemoveCartCoupon(tx *gorm.DB, cart *models.Cart, code string) error {
+	if err := tx.Where("cart_id = ? AND code = ?", cart.ID, NormalizeCouponCode(code)).
+		Delete(&models.CartCoupon{}).Error; err != nil {
+		return err
+	}
+	return loadCartLines(tx, cart)
+}
+
+type CartWarningType string
+
+const (
+	CartWarningPriceChanged        CartWarningType = "PRICE_CHANGED"
+	CartWarningOutOfStock          CartWarningType = "OUT_OF_STOCK"
+	CartWarningInsufficientStock   CartWarningType = "INSUFFICIENT_STOCK"
+	CartWarningUnavailable         CartWarningType = "UNAVAILABLE"
+	CartWarningCouponRemoved       CartWarningType = "COUPON_REMOVED"
+	CartWarningShippingUnavailable CartWarningType = "SHIPPING_UNAVAILABLE"
+)
+
+// CartWarning is something the customer should know before checking out
+type CartWarning struct {
+	Type      CartWarningType `json:"type"`
+	ProductID uint            `json:"product_id,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	Message   string          `json:"message"`
+	Available *int            `json:"available,omitempty"`
+
+	reason string
+}
+
+// CartLine is a cart item as it would be ordered now
+type CartLine struct {
+	ProductID         uint     `json:"product_id"`
+	Name              string   `json:"name"`
+	SKU               string   `json:"sku"`
+	Quantity          int      `json:"quantity"`
+	UnitPrice         float64  `json:"unit_price"`
+	PreviousUnitPrice *float64 `json:"previous_unit_price,omitempty"`
+	TotalAmount       float64  `json:"total_amount"`
+	DiscountAmount    float64  `json:"discount_amount"`
+	NetAmount         float64  `json:"net_amount"`
+	TaxAmount         float64  `json:"tax_amount"`
+}
+
+// CartPricing is what the cart would cost if it were checked out now.
+// Amounts are as on an order: TotalAmount is net of discounts and tax.
+type CartPricing struct {
+	Currency         string             `json:"currency"`
+	PricesIncludeTax bool               `json:"prices_include_tax"`
+	Lines            []CartLine         `json:"lines"`
+	Subtotal         float64            `json:"subtotal"`
+	DiscountAmount   float64            `json:"discount_amount"`
+	TotalAmount      float64            `json:"total_amount"`
+	ShippingMethod   string             `json:"shipping_method,omitempty"`
+	ShippingAmount   float64            `json:"shipping_amount"`
+	TaxAmount        float64            `json:"tax_amount"`
+	FinalAmount      float64            `json:"final_amount"`
+	Promotions       []AppliedPromotion `json:"promotions"`
+	ShippingQuotes   []ShippingQuote    `json:"shipping_quotes,omitempty"`
+	Warnings         []CartWarning      `json:"warnings"`
+}
+
+// PriceCart prices the cart the way PlaceOrder would, with its
+// promotions, tax and shipping, and warns about stock and price changes.
+// Coupons that no longer apply are left out of the price. It writes
+// nothing; SaveCartPricing keeps what was shown. Without a destination,
+// tax is estimated as for a domestic sale.
+func PriceCart(tx *gorm.DB, cart *models.Cart, now time.Time) (*CartPricing, error) {
+	pricing := &CartPricing{Lines: []CartLine{}, Promotions: []AppliedPromotion{}, Warnings: []CartWarning{}}
+
+	user := models.User{UserType: models.UserTypeB2C}
+	if cart.UserID != nil {
+		if err := tx.Select("id", "company_id", "user_type").First(&user, *cart.UserID).Error; err != nil {
+			return nil, err
+		}
+	}
+	address := &models.Address{Country: cart.Country, PostalCode: cart.PostalCode}
+	if cart.ShippingAddressID != nil {
+		if err := tx.First(address, *cart.ShippingAddressID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	destination := address.Country != ""
+	if !destination {
+		address.Country = settings.TaxOriginCountry
+	}
+
+	// Leave out products that can no longer be bought
+	input := PlaceOrderInput{
+		UserID:         user.ID,
+		Currency:       cart.Currency,
+		ShippingMethod: cart.ShippingMethod,
+	}
+	items := make([]*models.CartItem, 0, len(cart.Items))
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		if item.Product == nil || !item.Product.IsActive {
+			pricing.Warnings = append(pricing.Warnings, CartWarning{
+				Type:      CartWarningUnavailable,
+				ProductID: item.ProductID,
+				Message:   "This product is no longer available",
+			})
+			continue
+		}
+		items = append(items, item)
+		input.Lines = append(input.Lines, OrderLine{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	for _, coupon := range cart.Coupons {
+		input.CouponCodes = append(input.CouponCodes, coupon.Code)
+	}
+	if !destination || len(input.Lines) == 0 {
+		input.ShippingMethod = ""
+	}
+
+	// Drop whatever stops the cart being priced: coupons that no longer
+	// apply, and a shipping method that cannot deliver it
+	var priced *pricedOrder
+	for {
+		var err error
+		priced, err = priceOrder(tx, input, &user, address, now, false)
+		var couponErr *CouponError
+		switch {
+		case errors.As(err, &couponErr):
+			input.CouponCodes = removeCode(input.CouponCodes, couponErr.Code)
+			pricing.Warnings = append(pricing.Warnings, CartWarning{
+				Type:    CartWarningCouponRemoved,
+				Code:    couponErr.Code,
+				Message: "Coupon removed: " + couponErr.Reason,
+				reason:  couponErr.Reason,
+			})
+			continue
+		case errors.Is(err, ErrShippingMethodUnavailable):
+			pricing.Warnings = append(pricing.Warnings, CartWarning{
+				Type:    CartWarningShippingUnavailable,
+				Code:    input.ShippingMethod,
+				Message: "The chosen shipping method cannot deliver this cart",
+			})
+			input.ShippingMethod = ""
+			continue
+		case err != nil:
+			return nil, err
+		}
+		break
+	}
+
+	order := priced.order
+	pricing.Currency = order.Currency
+	pricing.PricesIncludeTax = order.PricesIncludeTax
+	pricing.DiscountAmount = order.DiscountAmount
+	pricing.TotalAmount = order.TotalAmount
+	pricing.ShippingMethod = order.ShippingMethod
+	pricing.ShippingAmount = order.ShippingAmount
+	pricing.TaxAmount = order.TaxAmount
+	pricing.FinalAmount = order.FinalAmount
+	pricing.Promotions = append(pricing.Promotions, priced.discounts.Applied...)
+
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	availabilities, err := ProductAvailabilities(tx, productIDs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var goodsValue float64
+	for i, item := range items {
+		line := priced.items[i]
+		cartLine := CartLine{
+			ProductID:      item.ProductID,
+			Name:           item.Product.Name,
+			SKU:            item.Product.SKU,
+			Quantity:       item.Quantity,
+			UnitPrice:      line.UnitPrice,
+			TotalAmount:    line.TotalAmount,
+			DiscountAmount: line.DiscountAmount,
+			NetAmount:      line.NetAmount,
+			TaxAmount:      line.TaxAmount,
+		}
+		pricing.Subtotal += line.TotalAmount
+		goodsValue += line.TotalAmount - line.DiscountAmount
+
+		// Changing the cart currency forgets the prices shown, so it is
+		// not reported as a price change
+		if item.UnitPrice != line.UnitPrice && item.UnitPrice != 0 {
+			previous := item.UnitPrice
+			cartLine.PreviousUnitPrice = &previous
+			pricing.Warnings = append(pricing.Warnings, CartWarning{
+				Type:      CartWarningPriceChanged,
+				ProductID: item.ProductID,
+				Message:   fmt.Sprintf("Price changed from %.2f to %.2f", previous, line.UnitPrice),
+			})
+		}
+
+		if entry := availabilities[item.ProductID]; entry != nil && entry.Available < item.Quantity {
+			available := entry.Available
+			if available < 0 {
+				available = 0
+			}
+			warning := CartWarning{
+				Type:      CartWarningInsufficientStock,
+				ProductID: item.ProductID,
+				Message:   fmt.Sprintf("Only %d available", available),
+				Available: &available,
+			}
+			if available == 0 {
+				warning.Type = CartWarningOutOfStock
+				warning.Message = "Out of stock"
+			}
+			pricing.Warnings = append(pricing.Warnings, warning)
+		}
+		pricing.Lines = append(pricing.Lines, cartLine)
+	}
+	pricing.Subtotal = RoundMoney(pricing.Subtotal)
+
+	if destination && len(input.Lines) > 0 {
+		quotes, err := ShippingQuotes(tx, Shipment{
+			Lines:      input.Lines,
+			Country:    address.Country,
+			PostalCode: address.PostalCode,
+			Value:      ToBase(goodsValue, order.ExchangeRate),
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+		for i := range quotes {
+			quotes[i].Price = RoundMoney(quotes[i].Price * order.ExchangeRate)
+			if priced.discounts.FreeShipping {
+				quotes[i].Price, quotes[i].Free = 0, true
+			}
+		}
+		pricing.ShippingQuotes = quotes
+	}
+	return pricing, nil
+}
+
+// CheckoutInput is what checkout needs beyond the cart
+type CheckoutInput struct {
+	PaymentMethod    string
+	CustomerNotes    string
+	Strategy         AllocationStrategy
+	MinShelfLifeDays int
+
+	// ExpectedFinalAmount, when set, refuses the order with ErrCartChanged
+	// if the cart no longer costs what the customer was shown
+	ExpectedFinalAmount *float64
+}
+
+// CheckoutCart places an order for the user's cart and closes the cart,
+// in the caller's transaction. The cart row is locked first, so a second
+// checkout of the same cart waits and then finds no cart.
+func CheckoutCart(tx *gorm.DB, userID uint, input CheckoutInput, now time.Time) (*models.Order, error) {
+	cart, err := FindCart(tx, CartOwner{UserID: &userID}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Items) == 0 {
+		return nil, ErrCartEmpty
+	}
+	if cart.ShippingAddressID == nil {
+		return nil, ErrCartNoAddress
+	}
+
+	lines := make([]OrderLine, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		if item.Product == nil || !item.Product.IsActive {
+			return nil, fmt.Errorf("%w: %d", ErrProductNotFound, item.ProductID)
+		}
+		lines = append(lines, OrderLine{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	codes := make([]string, 0, len(cart.Coupons))
+	for _, coupon := range cart.Coupons {
+		codes = append(codes, coupon.Code)
+	}
+
+	order, err := PlaceOrder(tx, PlaceOrderInput{
+		UserID:            userID,
+		ShippingAddressID: *cart.ShippingAddressID,
+		ShippingMethod:    cart.ShippingMethod,
+		PaymentMethod:     input.PaymentMethod,
+		CustomerNotes:     input.CustomerNotes,
+		Currency:          cart.Currency,
+		Strategy:          input.Strategy,
+		MinShelfLifeDays:  input.MinShelfLifeDays,
+		CouponCodes:       codes,
+		Lines:             lines,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if input.ExpectedFinalAmount != nil && RoundMoney(*input.ExpectedFinalAmount) != order.FinalAmount {
+		return nil, fmt.Errorf("%w: expected %.2f, now %.2f", ErrCartChanged, *input.ExpectedFinalAmount, order.FinalAmount)
+	}
+
+	if err := tx.Model(cart).Updates(map[string]interface{}{
+		"status":         models.CartStatusConverted,
+		"order_id":       order.ID,
+		"checked_out_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func loadCartLines(db *gorm.DB, cart *models.Cart) error {
+	cart.Items = nil
+	if err := db.Preload("Product").Where("cart_id = ?", cart.ID).Order("id").Find(&cart.Items).Error; err != nil {
+		return err
+	}
+	cart.Coupons = nil
+	return db.Where("cart_id = ?", cart.ID).Order("code").Find(&cart.Coupons).Error
+}
+
+func newCartToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func removeCode(codes []string, code string) []string {
+	kept := make([]string, 0, len(codes))
+	for _, c := range codes {
+		if c != code {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+func removeCartCoupon(coupons []models.CartCoupon, code string) []models.CartCoupon {
+	kept := make([]models.CartCoupon, 0, len(coupons))
+	for _, coupon := range coupons {
+		if coupon.Code != code {
+			kept = append(kept, coupon)
+		}
+	}
+	return kept
+}
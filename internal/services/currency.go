@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown or inactive currency")
+	ErrNoExchangeRate  = errors.New("no exchange rate for currency")
+)
+
+// BaseCurrency is the currency prices and accounts are kept in
+func BaseCurrency() string {
+	return settings.BaseCurrency
+}
+
+// RoundMoney rounds an amount to two decimal places
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// ToBase converts an amount in a transaction currency to the base currency
+func ToBase(amount, rate float64) float64 {
+	if rate == 0 {
+		return amount
+	}
+	return RoundMoney(amount / rate)
+}
+
+// ResolveCurrency validates a currency code, defaulting to the base currency
+func ResolveCurrency(db *gorm.DB, code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || code == BaseCurrency() {
+		return BaseCurrency(), nil
+	}
+
+	var count int64
+	if err := db.Model(&models.Currency{}).
+		Where("code = ? AND is_active", code).
+		Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", ErrUnknownCurrency
+	}
+	return code, nil
+}
+
+// ExchangeRateAt returns how many units of quote one unit of base bought at
+// the given time. A rate stored the other way round is inverted.
+func ExchangeRateAt(db *gorm.DB, base, quote string, at time.Time) (float64, error) {
+	if base == quote {
+		return 1, nil
+	}
+
+	var direct, inverse models.ExchangeRate
+	directErr := db.Where("base_currency = ? AND quote_currency = ? AND effective_at <= ?", base, quote, at).
+		Order("effective_at DESC, id DESC").
+		First(&direct).Error
+	if directErr != nil && !errors.Is(directErr, gorm.ErrRecordNotFound) {
+		return 0, directErr
+	}
+	inverseErr := db.Where("base_currency = ? AND quote_currency = ? AND effective_at <= ?", quote, base, at).
+		Order("effective_at DESC, id DESC").
+		First(&inverse).Error
+	if inverseErr != nil && !errors.Is(inverseErr, gorm.ErrRecordNotFound) {
+		return 0, inverseErr
+	}
+
+	switch {
+	case directErr == nil && (inverseErr != nil || !inverse.EffectiveAt.After(direct.EffectiveAt)):
+		return direct.Rate, nil
+	case inverseErr == nil && inverse.Rate != 0:
+		return 1 / inverse.Rate, nil
+	}
+	return 0, ErrNoExchangeRate
+}
+
+// ProductUnitPrice is the selling price of product in currency. A stored
+// override wins; otherwise the base-currency price is converted at rate.
+// Bundles priced from their components sum the component prices instead.
+func ProductUnitPrice(db *gorm.DB, product *models.Product, currency string, rate float64, b2b bool) (float64, error) {
+	if product.IsBundle && product.BundlePricing == models.BundlePricingSumMinusDiscount {
+		return bundleUnitPrice(db, product, currency, rate, b2b)
+	}
+
+	basePrice := product.BasePrice
+	if b2b && product.B2BPrice > 0 {
+		basePrice = product.B2BPrice
+	}
+	if currency == BaseCurrency() {
+		return basePrice, nil
+	}
+
+	var override models.ProductPrice
+	err := db.Where("product_id = ? AND currency = ?", product.ID, currency).First(&override).Error
+	if err == nil {
+		if b2b && override.B2BPrice != nil {
+			return *override.B2BPrice, nil
+		}
+		return override.BasePrice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	return RoundMoney(basePrice * rate), nil
+}
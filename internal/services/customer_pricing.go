@@ -0,0 +1,71 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CustomerPricing resolves what a customer pays for a product. Business
+// customers get B2B prices; their company's price list and active
+// contracts set negotiated prices, the contract winning.
+type CustomerPricing struct {
+	B2B    bool
+	prices map[uint]float64 // negotiated base-currency prices by product
+}
+
+// NewCustomerPricing loads the negotiated prices in force at at for user
+func NewCustomerPricing(db *gorm.DB, user *models.User, at time.Time) (*CustomerPricing, error) {
+	pricing := &CustomerPricing{
+		B2B:    user.UserType == models.UserTypeB2B || user.CompanyID != nil,
+		prices: make(map[uint]float64),
+	}
+	if user.CompanyID == nil {
+		return pricing, nil
+	}
+
+	var company models.Company
+	if err := db.Select("id", "price_list_id").First(&company, *user.CompanyID).Error; err != nil {
+		return nil, err
+	}
+	if company.PriceListID != nil {
+		var items []models.PriceListItem
+		err := db.Joins("JOIN price_lists ON price_lists.id = price_list_items.price_list_id AND price_lists.deleted_at IS NULL").
+			Where("price_lists.id = ? AND price_lists.is_active", *company.PriceListID).
+			Where("(price_lists.starts_at IS NULL OR price_lists.starts_at <= ?) AND (price_lists.ends_at IS NULL OR price_lists.ends_at > ?)", at, at).
+			Find(&items).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			pricing.prices[item.ProductID] = item.Price
+		}
+	}
+
+	// Later contracts override earlier ones for the same product
+	var items []models.ContractItem
+	err := db.Joins("JOIN contracts ON contracts.id = contract_items.contract_id AND contracts.deleted_at IS NULL").
+		Where("contracts.company_id = ? AND contracts.status = ? AND contract_items.is_active", *user.CompanyID, models.ContractStatusActive).
+		Where("contracts.start_date <= ? AND (contracts.end_date IS NULL OR contracts.end_date = ? OR contracts.end_date > ?)", at, time.Time{}, at).
+		Order("contracts.start_date, contracts.id").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		pricing.prices[item.ProductID] = item.UnitPrice
+	}
+	return pricing, nil
+}
+
+// UnitPrice is what the customer pays for product in currency
+func (p *CustomerPricing) UnitPrice(db *gorm.DB, product *models.Product, currency string, rate float64) (float64, error) {
+	if price, ok := p.prices[product.ID]; ok {
+		if currency == BaseCurrency() {
+			return price, nil
+		}
+		return RoundMoney(price * rate), nil
+	}
+	return ProductUnitPrice(db, product, currency, rate, p.B2B)
+}
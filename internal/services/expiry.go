@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExpireBatches marks active batches whose expiry date has passed as
+// expired. Each batch is changed through an adjustment so the status change
+// is recorded against the EXPIRED reason, and its reservations move to
+// other batches.
+func ExpireBatches(db *gorm.DB, now time.Time) error {
+	var due []models.InventoryItem
+	if err := db.Select("id").
+		Where("status = ? AND expiry_date > ? AND expiry_date <= ?", models.InventoryStatusActive, time.Time{}, now).
+		Order("expiry_date, id").
+		Find(&due).Error; err != nil {
+		return err
+	}
+
+	// Batches whose reservations cannot move stay active, to be sorted out
+	// by hand, and are reported once the rest have expired
+	var stranded []error
+	for _, item := range due {
+		err := Transaction(db, func(tx *gorm.DB) error {
+			return expireBatch(tx, item.ID, now)
+		})
+		if errors.Is(err, ErrReservationsStranded) {
+			stranded = append(stranded, fmt.Errorf("inventory item %d: %w", item.ID, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Join(stranded...)
+}
+
+func expireBatch(tx *gorm.DB, id uint, now time.Time) error {
+	var item models.InventoryItem
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", models.InventoryStatusActive).
+		First(&item, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // changed or being changed elsewhere
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _, err = AdjustStock(tx, item.ID, StockAdjustment{
+		Status: models.InventoryStatusExpired,
+		Reason: models.AdjustmentReasonExpired,
+		Note:   "Expired on " + item.ExpiryDate.Format("2006-01-02"),
+	})
+	return err
+}
+
+// NearExpiryBatch is an active batch that expires within the report window
+type NearExpiryBatch struct {
+	InventoryItemID uint      `json:"inventory_item_id"`
+	ProductID       uint      `json:"product_id"`
+	ProductSKU      string    `json:"product_sku"`
+	ProductName     string    `json:"product_name"`
+	BatchNumber     string    `json:"batch_number"`
+	ExpiryDate      time.Time `json:"expiry_date"`
+	DaysLeft        int       `json:"days_left"`
+	Quantity        int       `json:"quantity"`
+	Reserved        int       `json:"reserved"`
+	CostValue       float64   `json:"cost_value"`
+}
+
+// NearExpiryWarehouse groups a warehouse's near-expiry batches
+type NearExpiryWarehouse struct {
+	WarehouseID   uint              `json:"warehouse_id"`
+	WarehouseName string            `json:"warehouse_name"`
+	Batches       []NearExpiryBatch `json:"batches"`
+	Quantity      int               `json:"quantity"`
+	CostValue     float64           `json:"cost_value"`
+}
+
+// NearExpiryReport lists active batches with stock that expire within days
+// of now, by warehouse and soonest first. Value is at product cost price.
+func NearExpiryReport(db *gorm.DB, now time.Time, days int, warehouseID *uint) ([]NearExpiryWarehouse, error) {
+	var items []models.InventoryItem
+	query := db.Preload("Product").Preload("Warehouse").
+		Where("status = ? AND quantity > 0", models.InventoryStatusActive).
+		Where("expiry_date > ? AND expiry_date <= ?", time.Time{}, now.AddDate(0, 0, days)).
+		Order("expiry_date, id")
+	if warehouseID != nil {
+		query = query.Where("warehouse_id = ?", *warehouseID)
+	}
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	byWarehouse := make(map[uint]*NearExpiryWarehouse)
+	for _, item := range items {
+		group, ok := byWarehouse[item.WarehouseID]
+		if !ok {
+			group = &NearExpiryWarehouse{
+				WarehouseID:   item.WarehouseID,
+				WarehouseName: item.Warehouse.Name,
+				Batches:       []NearExpiryBatch{},
+			}
+			byWarehouse[item.WarehouseID] = group
+		}
+
+		value := RoundMoney(item.Product.CostPrice * float64(item.Quantity))
+		group.Batches = append(group.Batches, NearExpiryBatch{
+			InventoryItemID: item.ID,
+			ProductID:       item.ProductID,
+			ProductSKU:      item.Product.SKU,
+			ProductName:     item.Product.Name,
+			BatchNumber:     item.BatchNumber,
+			ExpiryDate:      item.ExpiryDate,
+			DaysLeft:        int(item.ExpiryDate.Sub(now).Hours() / 24),
+			Quantity:        item.Quantity,
+			Reserved:        item.Reserved,
+			CostValue:       value,
+		})
+		group.Quantity += item.Quantity
+		group.CostValue = RoundMoney(group.CostValue + value)
+	}
+
+	report := make([]NearExpiryWarehouse, 0, len(byWarehouse))
+	for _, group := range byWarehouse {
+		report = append(report, *group)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].WarehouseName < report[j].WarehouseName })
+	return report, nil
+}
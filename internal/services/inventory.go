@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOutOfStock        = errors.New("product out of stock")
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// StockError reports a product that cannot be supplied in full. It matches
+// ErrOutOfStock when nothing is available and ErrInsufficientStock
+// otherwise.
+type StockError struct {
+	ProductID uint
+	Requested int
+	Available int
+}
+
+func (e *StockError) Error() string {
+	return fmt.Sprintf("product %d: requested %d, available %d", e.ProductID, e.Requested, e.Available)
+}
+
+func (e *StockError) Unwrap() error {
+	if e.Available <= 0 {
+		return ErrOutOfStock
+	}
+	return ErrInsufficientStock
+}
+
+// AvailableQuantity is on-hand stock less reservations across the
+// product's sellable inventory items
+func AvailableQuantity(db *gorm.DB, productID uint) (int, error) {
+	var available int
+	err := db.Model(&models.InventoryItem{}).
+		Scopes(sellableStock(time.Now())).
+		Select("COALESCE(SUM(inventory_items.quantity - inventory_items.reserved), 0)").
+		Where("inventory_items.product_id = ?", productID).
+		Scan(&available).Error
+	return available, err
+}
+
+// sellableStock limits a query to active inventory items that are not
+// perishable or do not expire before notBefore
+func sellableStock(notBefore time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("inventory_items.status = ?", models.InventoryStatusActive).
+			Where("(inventory_items.expiry_date IS NULL OR inventory_items.expiry_date = ? OR inventory_items.expiry_date > ?)",
+				time.Time{}, notBefore)
+	}
+}
+
+// ProductAvailableQuantity is AvailableQuantity for plain products. A
+// bundle is available as many times as its scarcest component allows.
+func ProductAvailableQuantity(db *gorm.DB, product *models.Product) (int, error) {
+	if !product.IsBundle {
+		return AvailableQuantity(db, product.ID)
+	}
+
+	components, err := bundleComponents(db, product)
+	if err != nil {
+		return 0, err
+	}
+	if len(components) == 0 {
+		return 0, nil
+	}
+
+	available := -1
+	for _, component := range components {
+		stock, err := AvailableQuantity(db, component.ComponentID)
+		if err != nil {
+			return 0, err
+		}
+		if fits := stock / component.Quantity; available < 0 || fits < available {
+			available = fits
+		}
+	}
+	if available < 0 {
+		available = 0
+	}
+	return available, nil
+}
+
+func bundleComponents(db *gorm.DB, product *models.Product) ([]models.BundleComponent, error) {
+	if product.BundleComponents != nil {
+		return product.BundleComponents, nil
+	}
+	var components []models.BundleComponent
+	err := db.Where("bundle_id = ?", product.ID).Order("id").Find(&components).Error
+	return components, err
+}
+
+var (
+	ErrNegativeStock  = errors.New("adjustment would make stock negative")
+	ErrBelowReserved  = errors.New("adjustment would leave less stock than is reserved")
+	ErrUnknownReason  = errors.New("unknown adjustment reason")
+	ErrNothingToApply = errors.New("adjustment changes nothing")
+)
+
+// StockAdjustment is a manual change to one inventory item. Status is left
+// alone when empty.
+type StockAdjustment struct {
+	QuantityChange int
+	Status         string
+	Reason         models.AdjustmentReason
+	Note           string
+	AdjustedByID   *uint
+}
+
+// AdjustStock applies a manual adjustment to an inventory item under a row
+// lock and records it
+func AdjustStock(tx *gorm.DB, inventoryItemID uint, adjustment StockAdjustment) (*models.InventoryItem, *models.InventoryAdjustment, error) {
+	if !validAdjustmentReason(adjustment.Reason) {
+		return nil, nil, ErrUnknownReason
+	}
+
+	var inventory models.InventoryItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&inventory, inventoryItemID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	status := inventory.Status
+	if adjustment.Status != "" {
+		status = adjustment.Status
+	}
+	if adjustment.QuantityChange == 0 && status == inventory.Status {
+		return nil, nil, ErrNothingToApply
+	}
+
+	quantity := inventory.Quantity + adjustment.QuantityChange
+	if quantity < 0 {
+		return nil, nil, ErrNegativeStock
+	}
+	if quantity < inventory.Reserved {
+		return nil, nil, ErrBelowReserved
+	}
+
+	record := models.InventoryAdjustment{
+		InventoryItemID: inventory.ID,
+		QuantityBefore:  inventory.Quantity,
+		QuantityAfter:   quantity,
+		QuantityChange:  adjustment.QuantityChange,
+		Reason:          adjustment.Reason,
+		Note:            adjustment.Note,
+		AdjustedByID:    adjustment.AdjustedByID,
+	}
+	if status != inventory.Status {
+		record.StatusBefore = inventory.Status
+		record.StatusAfter = status
+	}
+
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if status != inventory.Status {
+		leavingSale := inventory.Status == models.InventoryStatusActive
+		if err := tx.Model(&inventory).Update("status", status).Error; err != nil {
+			return nil, nil, err
+		}
+		invalidateAvailabilityAfterCommit(tx, inventory.ProductID)
+		// Expired or damaged stock must not be picked for open orders
+		if leavingSale && inventory.Reserved > 0 {
+			if err := moveReservations(tx, &inventory, adjustment.AdjustedByID, time.Now()); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if adjustment.QuantityChange != 0 {
+		movementType := models.MovementTypeAdjustment
+		if adjustment.Reason == models.AdjustmentReasonGoodsReceived || adjustment.Reason == models.AdjustmentReasonInitialStock {
+			movementType = models.MovementTypeReceipt
+		}
+		if _, err := moveStock(tx, &inventory, movementType, adjustment.QuantityChange, 0, MovementRef{
+			Type:   ReferenceTypeAdjustment,
+			ID:     record.ID,
+			UserID: adjustment.AdjustedByID,
+			Note:   string(adjustment.Reason),
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &inventory, &record, nil
+}
+
+func validAdjustmentReason(reason models.AdjustmentReason) bool {
+	for _, known := range models.AdjustmentReasons {
+		if reason == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderNotFound    = errors.New("order not found")
+	ErrInvoiceExists    = errors.New("order already invoiced")
+	ErrOrderNotBillable = errors.New("cancelled orders cannot be invoiced")
+)
+
+// IssueInvoice bills an order in full. The invoice number is drawn in tx,
+// so invoice numbers stay gap-free.
+func IssueInvoice(tx *gorm.DB, orderID uint, now time.Time) (*models.Invoice, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if order.Status == models.OrderStatusCancelled {
+		return nil, ErrOrderNotBillable
+	}
+
+	var invoiced int64
+	if err := tx.Model(&models.Invoice{}).Where("order_id = ? AND status <> ?", order.ID, "cancelled").
+		Count(&invoiced).Error; err != nil {
+		return nil, err
+	}
+	if invoiced > 0 {
+		return nil, ErrInvoiceExists
+	}
+
+	// Due on the company's payment terms, or on issue
+	dueDate := now
+	if order.CompanyID != nil {
+		var company models.Company
+		if err := tx.Select("payment_terms").First(&company, *order.CompanyID).Error; err != nil {
+			return nil, err
+		}
+		dueDate = now.AddDate(0, 0, company.PaymentTerms)
+	}
+
+	invoice := models.Invoice{
+		OrderID:       order.ID,
+		IssueDate:     now,
+		DueDate:       dueDate,
+		Amount:        order.FinalAmount,
+		TaxAmount:     order.TaxAmount,
+		Currency:      order.Currency,
+		ExchangeRate:  order.ExchangeRate,
+		BaseCurrency:  order.BaseCurrency,
+		BaseAmount:    order.BaseFinalAmount,
+		BaseTaxAmount: order.BaseTaxAmount,
+		Status:        "pending",
+		PaymentMethod: order.PaymentMethod,
+	}
+	if order.PaymentStatus == models.PaymentStatusPaid {
+		invoice.Status = "paid"
+		invoice.PaymentDate = order.PaymentDate
+	}
+
+	var err error
+	if invoice.InvoiceNumber, err = NextNumber(tx, models.SequenceTypeInvoice, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
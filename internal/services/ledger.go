@@ -0,0 +1,105 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReferenceTypeOrder      = "Order"
+	ReferenceTypeAdjustment = "InventoryAdjustment"
+	ReferenceTypeReconcile  = "Reconciliation"
+	ReferenceTypeTransfer   = "StockTransfer"
+)
+
+// MovementRef identifies the document and user behind a stock movement
+type MovementRef struct {
+	Type   string
+	ID     uint
+	UserID *uint
+	Note   string
+}
+
+// moveStock changes an inventory item's balances by the given deltas and
+// appends the matching ledger entry. Every stock write goes through here.
+func moveStock(tx *gorm.DB, item *models.InventoryItem, movementType models.MovementType, quantityDelta, reservedDelta int, ref MovementRef) (*models.InventoryMovement, error) {
+	if err := tx.Model(item).Updates(map[string]interface{}{
+		"quantity": gorm.Expr("quantity + ?", quantityDelta),
+		"reserved": gorm.Expr("reserved + ?", reservedDelta),
+	}).Error; err != nil {
+		return nil, err
+	}
+	item.Quantity += quantityDelta
+	item.Reserved += reservedDelta
+	return recordMovement(tx, item, movementType, quantityDelta, reservedDelta, ref)
+}
+
+// recordMovement appends a ledger entry for balance changes already written
+// to the item
+func recordMovement(tx *gorm.DB, item *models.InventoryItem, movementType models.MovementType, quantityDelta, reservedDelta int, ref MovementRef) (*models.InventoryMovement, error) {
+	movement := models.InventoryMovement{
+		InventoryItemID: item.ID,
+		ProductID:       item.ProductID,
+		WarehouseID:     item.WarehouseID,
+		Type:            movementType,
+		QuantityDelta:   quantityDelta,
+		ReservedDelta:   reservedDelta,
+		ReferenceType:   ref.Type,
+		UserID:          ref.UserID,
+		Note:            ref.Note,
+	}
+	if ref.ID != 0 {
+		movement.ReferenceID = &ref.ID
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	invalidateAvailabilityAfterCommit(tx, item.ProductID)
+	return &movement, nil
+}
+
+// LedgerDrift is an inventory item whose stored balances disagree with
+// its ledger
+type LedgerDrift struct {
+	InventoryItemID uint `json:"inventory_item_id"`
+	ProductID       uint `json:"product_id"`
+	WarehouseID     uint `json:"warehouse_id"`
+	Quantity        int  `json:"quantity"`
+	Reserved        int  `json:"reserved"`
+	LedgerQuantity  int  `json:"ledger_quantity"`
+	LedgerReserved  int  `json:"ledger_reserved"`
+}
+
+// ReconcileLedger compares every inventory item, including deleted ones,
+// with the sum of its movements
+func ReconcileLedger(db *gorm.DB) ([]LedgerDrift, error) {
+	var drifts []LedgerDrift
+	err := db.Raw(`SELECT i.id AS inventory_item_id, i.product_id, i.warehouse_id, i.quantity, i.reserved,
+			COALESCE(SUM(m.quantity_delta), 0) AS ledger_quantity,
+			COALESCE(SUM(m.reserved_delta), 0) AS ledger_reserved
+		FROM inventory_items i
+		LEFT JOIN inventory_movements m ON m.inventory_item_id = i.id
+		GROUP BY i.id
+		HAVING i.quantity <> COALESCE(SUM(m.quantity_delta), 0)
+			OR i.reserved <> COALESCE(SUM(m.reserved_delta), 0)
+		ORDER BY i.id`).Scan(&drifts).Error
+	return drifts, err
+}
+
+// RecordOpeningBalance appends the movement that brings an item's ledger in
+// line with its stored balances. It is meant for stock that predates the
+// ledger; drift on items with history should be investigated instead.
+func RecordOpeningBalance(tx *gorm.DB, drift LedgerDrift) error {
+	movement := models.InventoryMovement{
+		InventoryItemID: drift.InventoryItemID,
+		ProductID:       drift.ProductID,
+		WarehouseID:     drift.WarehouseID,
+		Type:            models.MovementTypeAdjustment,
+		QuantityDelta:   drift.Quantity - drift.LedgerQuantity,
+		ReservedDelta:   drift.Reserved - drift.LedgerReserved,
+		ReferenceType:   ReferenceTypeReconcile,
+		Note:            "Opening balance",
+	}
+	return tx.Create(&movement).Error
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+	"marketprogo/pkg/i18n"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// searchConfigs maps languages to PostgreSQL text search configurations.
+// Anything else is indexed with the language-neutral "simple" config.
+var searchConfigs = map[string]string{
+	"ar": "arabic",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fr": "french",
+	"it": "italian",
+}
+
+// DefaultLocale is the locale catalog records are written in
+func DefaultLocale() string {
+	return i18n.Normalize(settings.DefaultLocale)
+}
+
+// SupportedLocales lists every locale content can be served in
+func SupportedLocales() []string {
+	locales := make([]string, 0, len(settings.SupportedLocales))
+	for _, locale := range settings.SupportedLocales {
+		if locale = i18n.Normalize(locale); locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// IsTranslatableLocale reports whether translations may be stored for locale
+func IsTranslatableLocale(locale string) bool {
+	if locale == DefaultLocale() {
+		return false
+	}
+	for _, supported := range SupportedLocales() {
+		if supported == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchConfig returns the text search configuration for a locale
+func SearchConfig(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	if config, ok := searchConfigs[language]; ok {
+		return config
+	}
+	return "simple"
+}
+
+// resolveLocale walks the fallback chain and returns the first locale that
+// has a translation, or the default locale when the base record wins
+func resolveLocale(chain []string, has func(locale string) bool) string {
+	for _, locale := range chain {
+		if locale == DefaultLocale() {
+			break
+		}
+		if has(locale) {
+			return locale
+		}
+	}
+	return DefaultLocale()
+}
+
+// LocalizeProducts replaces product, category and specification text in
+// place with the best translation available for the fallback chain
+func LocalizeProducts(db *gorm.DB, products []models.Product, chain []string) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	productIDs := make([]uint, 0, len(products))
+	var categoryIDs, specIDs []uint
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+		for _, category := range product.Categories {
+			categoryIDs = append(categoryIDs, category.ID)
+		}
+		for _, spec := range product.Specifications {
+			specIDs = append(specIDs, spec.ID)
+		}
+	}
+
+	var productTranslations []models.ProductTranslation
+	if err := db.Where("product_id IN ? AND locale IN ?", productIDs, chain).Find(&productTranslations).Error; err != nil {
+		return err
+	}
+	byProduct := make(map[uint]map[string]models.ProductTranslation)
+	for _, t := range productTranslations {
+		if byProduct[t.ProductID] == nil {
+			byProduct[t.ProductID] = make(map[string]models.ProductTranslation)
+		}
+		byProduct[t.ProductID][t.Locale] = t
+	}
+
+	categories := make(map[uint]map[string]models.CategoryTranslation)
+	if len(categoryIDs) > 0 {
+		var translations []models.CategoryTranslation
+		if err := db.Where("category_id IN ? AND locale IN ?", categoryIDs, chain).Find(&translations).Error; err != nil {
+			return err
+		}
+		for _, t := range translations {
+			if categories[t.CategoryID] == nil {
+				categories[t.CategoryID] = make(map[string]models.CategoryTranslation)
+			}
+			categories[t.CategoryID][t.Locale] = t
+		}
+	}
+
+	specs := make(map[uint]map[string]models.ProductSpecificationTranslation)
+	if len(specIDs) > 0 {
+		var translations []models.ProductSpecificationTranslation
+		if err := db.Where("specification_id IN ? AND locale IN ?", specIDs, chain).Find(&translations).Error; err != nil {
+			return err
+		}
+		for _, t := range translations {
+			if specs[t.SpecificationID] == nil {
+				specs[t.SpecificationID] = make(map[string]models.ProductSpecificationTranslation)
+			}
+			specs[t.SpecificationID][t.Locale] = t
+		}
+	}
+
+	for i := range products {
+		product := &products[i]
+
+		translations := byProduct[product.ID]
+		product.Locale = resolveLocale(chain, func(locale string) bool { _, ok := translations[locale]; return ok })
+		if t, ok := translations[product.Locale]; ok {
+			product.Name = t.Name
+			if t.Description != "" {
+				product.Description = t.Description
+			}
+		}
+
+		for j := range product.Categories {
+			category := &product.Categories[j]
+			translations := categories[category.ID]
+			locale := resolveLocale(chain, func(locale string) bool { _, ok := translations[locale]; return ok })
+			if t, ok := translations[locale]; ok {
+				category.Name = t.Name
+				if t.Description != "" {
+					category.Description = t.Description
+				}
+			}
+		}
+
+		for j := range product.Specifications {
+			spec := &product.Specifications[j]
+			translations := specs[spec.ID]
+			locale := resolveLocale(chain, func(locale string) bool { _, ok := translations[locale]; return ok })
+			if t, ok := translations[locale]; ok {
+				spec.Name = t.Name
+				if t.Value != "" && spec.Type == models.SpecificationTypeText {
+					spec.Value = t.Value
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// IndexProduct rebuilds the product's search documents, one per supported
+// locale, each stemmed with that locale's text search configuration.
+// Locales without a translation index the fallback text.
+func IndexProduct(tx *gorm.DB, productID uint) error {
+	var product models.Product
+	if err := tx.Unscoped().First(&product, productID).Error; err != nil {
+		return err
+	}
+	if product.DeletedAt.Valid {
+		return tx.Where("product_id = ?", productID).Delete(&models.ProductSearchDocument{}).Error
+	}
+
+	var translations []models.ProductTranslation
+	if err := tx.Where("product_id = ?", productID).Find(&translations).Error; err != nil {
+		return err
+	}
+	byLocale := make(map[string]models.ProductTranslation, len(translations))
+	for _, t := range translations {
+		byLocale[t.Locale] = t
+	}
+
+	supported := SupportedLocales()
+	for _, locale := range supported {
+		chain := i18n.FallbackChain([]string{locale}, supported, DefaultLocale())
+		name, description := product.Name, product.Description
+		if t, ok := byLocale[resolveLocale(chain, func(l string) bool { _, ok := byLocale[l]; return ok })]; ok {
+			name = t.Name
+			if t.Description != "" {
+				description = t.Description
+			}
+		}
+
+		config := SearchConfig(locale)
+		if err := tx.Exec(`INSERT INTO product_search_documents (product_id, locale, document, updated_at)
+			VALUES (?, ?,
+				setweight(to_tsvector('simple', ?), 'A') ||
+				setweight(to_tsvector(?::regconfig, ?), 'A') ||
+				setweight(to_tsvector(?::regconfig, ?), 'B'),
+				NOW())
+			ON CONFLICT (product_id, locale)
+			DO UPDATE SET document = EXCLUDED.document, updated_at = EXCLUDED.updated_at`,
+			product.ID, locale,
+			product.SKU+" "+product.Barcode,
+			config, name,
+			config, description,
+		).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReindexProducts rebuilds the search documents of every product
+func ReindexProducts(db *gorm.DB) (int, error) {
+	var ids []uint
+	if err := db.Model(&models.Product{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := IndexProduct(db, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// SearchProductIDs is a subquery of products matching a search in the
+// given locale's index
+func SearchProductIDs(db *gorm.DB, query, locale string) *gorm.DB {
+	return db.Model(&models.ProductSearchDocument{}).
+		Select("product_id").
+		Where("locale = ? AND document @@ plainto_tsquery(?::regconfig, ?)", locale, SearchConfig(locale), query)
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Notify writes an in-app notification for a user as part of tx, so it is
+// only sent if the change it reports commits
+func Notify(tx *gorm.DB, userID uint, notificationType models.NotificationType, title, message, referenceType string, referenceID uint) error {
+	if userID == 0 {
+		return nil
+	}
+	notification := models.Notification{
+		UserID:        userID,
+		Type:          notificationType,
+		Title:         title,
+		Message:       message,
+		ReferenceType: referenceType,
+	}
+	if referenceID != 0 {
+		notification.ReferenceID = &referenceID
+	}
+	return tx.Create(&notification).Error
+}
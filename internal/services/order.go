@@ -0,0 +1,448 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrProductNotFound  = errors.New("product not found")
+	ErrAddressNotFound  = errors.New("shipping address not found")
+	ErrContractNotFound = errors.New("contract not found")
+)
+
+// OrderLine is a product and quantity a customer asks for
+type OrderLine struct {
+	ProductID uint
+	Quantity  int
+}
+
+// PlaceOrderInput is everything needed to place an order
+type PlaceOrderInput struct {
+	UserID            uint
+	ShippingAddressID uint
+	ShippingMethod    string // code of the shipping method to charge
+	PaymentMethod     string
+	CustomerNotes     string
+	Currency          string // defaults to the base currency
+	Strategy          AllocationStrategy
+	MinShelfLifeDays  int
+	CouponCodes       []string
+	Lines             []OrderLine
+}
+
+// PlaceOrder prices and discounts the lines, allocates stock across
+// warehouses and reserves it, and writes the order with its items and
+// allocations. It must run inside a transaction; the caller commits,
+// preferably through Transact. Stock rows are locked in inventory item
+// order, so concurrent orders queue rather than deadlock, and never
+// oversell.
+func PlaceOrder(tx *gorm.DB, input PlaceOrderInput) (*models.Order, error) {
+	if input.ShippingMethod == "" {
+		return nil, fmt.Errorf("%w: none chosen", ErrShippingMethodUnavailable)
+	}
+	var address models.Address
+	if err := tx.Where("user_id = ?", input.UserID).First(&address, input.ShippingAddressID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, err
+	}
+	var user models.User
+	if err := tx.Select("id", "company_id", "user_type").First(&user, input.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	// Coupons are locked before stock, and held until the order is written
+	orderDate := time.Now()
+	priced, err := priceOrder(tx, input, &user, &address, orderDate, true)
+	if err != nil {
+		return nil, err
+	}
+	order, items := priced.order, priced.items
+
+	plan, err := PlanAllocation(tx, priced.demands, AllocationOptions{
+		Strategy:         input.Strategy,
+		Destination:      &address,
+		MinShelfLifeDays: input.MinShelfLifeDays,
+		Now:              orderDate,
+		Lock:             true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, demand := range plan.Demands {
+		if demand.Shortfall > 0 {
+			return nil, &StockError{ProductID: demand.ProductID, Requested: demand.Quantity, Available: demand.Quantity - demand.Shortfall}
+		}
+	}
+
+	// Number and write the order once its stock is locked, so the order
+	// sequence is held only for the rest of the transaction. Stock
+	// movements below reference the order.
+	order.OrderNumber, err = NextNumber(tx, models.SequenceTypeOrder, orderDate)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+	if err := recordOrderStatus(tx, &order, models.OrderStatusFieldStatus, "", string(order.Status), StatusChange{UserID: &input.UserID}); err != nil {
+		return nil, err
+	}
+	if err := RedeemPromotions(tx, &order, priced.discounts, priced.shippingDiscount); err != nil {
+		return nil, err
+	}
+
+	// Reserve in inventory item order, the order the plan locked them in
+	type reservation struct {
+		productID  uint
+		allocation Allocation
+	}
+	var reservations []reservation
+	for _, demand := range plan.Demands {
+		for _, allocation := range demand.Allocations {
+			reservations = append(reservations, reservation{demand.ProductID, allocation})
+		}
+	}
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].allocation.InventoryItemID < reservations[j].allocation.InventoryItemID
+	})
+	ref := MovementRef{Type: ReferenceTypeOrder, ID: order.ID, UserID: &input.UserID}
+	for _, r := range reservations {
+		if err := ReserveAllocation(tx, r.productID, r.allocation, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	allocations := make(map[int][]Allocation, len(plan.Demands))
+	for _, demand := range plan.Demands {
+		allocations[demand.Key] = demand.Allocations
+		if len(demand.Allocations) == 1 {
+			line, component := splitDemandKey(demand.Key)
+			if component < 0 {
+				items[line].InventoryItemID = &demand.Allocations[0].InventoryItemID
+			} else {
+				items[line].Components[component].InventoryItemID = &demand.Allocations[0].InventoryItemID
+			}
+		}
+	}
+
+	for i := range items {
+		items[i].OrderID = order.ID
+		if err := tx.Create(&items[i]).Error; err != nil {
+			return nil, err
+		}
+
+		records := allocationRecords(&items[i], nil, allocations[demandKey(i, -1)])
+		for j := range items[i].Components {
+			component := &items[i].Components[j]
+			records = append(records, allocationRecords(&items[i], component, allocations[demandKey(i, j)])...)
+		}
+		if len(records) > 0 {
+			if err := tx.Create(&records).Error; err != nil {
+				return nil, err
+			}
+		}
+		items[i].Allocations = records
+
+		if len(priced.discounts.Lines[i]) > 0 {
+			shares := make([]models.OrderItemDiscount, 0, len(priced.discounts.Lines[i]))
+			for _, discount := range priced.discounts.Lines[i] {
+				shares = append(shares, models.OrderItemDiscount{
+					OrderItemID: items[i].ID,
+					PromotionID: discount.PromotionID,
+					CouponID:    discount.CouponID,
+					Amount:      discount.Amount,
+				})
+			}
+			if err := tx.Create(&shares).Error; err != nil {
+				return nil, err
+			}
+			items[i].Discounts = shares
+		}
+	}
+
+	order.Items = items
+	return &order, nil
+}
+
+// pricedOrder is an order priced, discounted and taxed, before any stock
+// is allocated or anything is written
+type pricedOrder struct {
+	order            models.Order
+	items            []models.OrderItem
+	demands          []StockDemand
+	discounts        *Discounts
+	shippingDiscount float64
+}
+
+// priceOrder prices the lines for user, shipped to address. Shipping is
+// left out when no method is chosen. lock takes the coupons FOR UPDATE.
+func priceOrder(tx *gorm.DB, input PlaceOrderInput, user *models.User, address *models.Address, at time.Time, lock bool) (*pricedOrder, error) {
+	currency, err := ResolveCurrency(tx, input.Currency)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := ExchangeRateAt(tx, BaseCurrency(), currency, at)
+	if err != nil {
+		return nil, err
+	}
+	taxContext, err := OrderTaxContext(tx, user.CompanyID, address, at)
+	if err != nil {
+		return nil, err
+	}
+	taxes := NewTaxCalculator(tx, taxContext)
+	pricing, err := NewCustomerPricing(tx, user, at)
+	if err != nil {
+		return nil, err
+	}
+
+	order := models.Order{
+		UserID:             input.UserID,
+		CompanyID:          user.CompanyID,
+		Status:             models.OrderStatusPending,
+		PaymentStatus:      models.PaymentStatusPending,
+		ShippingAddressID:  address.ID,
+		PaymentMethod:      input.PaymentMethod,
+		CustomerNotes:      input.CustomerNotes,
+		OrderDate:          at,
+		Currency:           currency,
+		ExchangeRate:       rate,
+		BaseCurrency:       BaseCurrency(),
+		AllocationStrategy: string(input.Strategy),
+		PricesIncludeTax:   taxContext.PricesIncludeTax,
+	}
+
+	// Price each line. Stock demands are keyed by line index, and by
+	// component index within bundle lines.
+	items := make([]models.OrderItem, 0, len(input.Lines))
+	taxClasses := make([]*uint, 0, len(input.Lines))
+	discountLines := make([]DiscountLine, 0, len(input.Lines))
+	var demands []StockDemand
+	for i, line := range input.Lines {
+		var product models.Product
+		if err := tx.First(&product, line.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %d", ErrProductNotFound, line.ProductID)
+			}
+			return nil, err
+		}
+
+		unitPrice, err := pricing.UnitPrice(tx, &product, currency, rate)
+		if err != nil {
+			return nil, err
+		}
+		item := models.OrderItem{
+			ProductID:   product.ID,
+			Quantity:    line.Quantity,
+			UnitPrice:   unitPrice,
+			TotalAmount: RoundMoney(unitPrice * float64(line.Quantity)),
+		}
+		if product.IsBundle {
+			components, err := SplitBundleLine(tx, &product, line.Quantity, item.TotalAmount, currency, rate, pricing.B2B)
+			if err != nil {
+				return nil, err
+			}
+			for j, component := range components {
+				item.Components = append(item.Components, models.OrderItemComponent{
+					ProductID:   component.ProductID,
+					Quantity:    component.Quantity,
+					UnitPrice:   component.UnitPrice,
+					TotalAmount: component.TotalAmount,
+				})
+				demands = append(demands, StockDemand{Key: demandKey(i, j), ProductID: component.ProductID, Quantity: component.Quantity})
+			}
+		} else {
+			demands = append(demands, StockDemand{Key: demandKey(i, -1), ProductID: product.ID, Quantity: line.Quantity})
+		}
+
+		items = append(items, item)
+		taxClasses = append(taxClasses, product.TaxClassID)
+		discountLines = append(discountLines, DiscountLine{ProductID: product.ID, Quantity: line.Quantity, TotalAmount: item.TotalAmount})
+	}
+
+	discounts, err := ApplyPromotions(tx, DiscountInput{
+		UserID:       input.UserID,
+		CustomerType: user.UserType,
+		CouponCodes:  input.CouponCodes,
+		Lines:        discountLines,
+		Rate:         rate,
+		At:           at,
+		Lock:         lock,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Tax each line on what is left after discounts. Bundles are taxed on
+	// their own class, not their components'.
+	var goodsValue float64
+	for i := range items {
+		item := &items[i]
+		item.DiscountAmount = discounts.LineAmount(i)
+		if item.LineTax, err = taxes.Line(taxClasses[i], RoundMoney(item.TotalAmount-item.DiscountAmount)); err != nil {
+			return nil, err
+		}
+		goodsValue += item.TotalAmount - item.DiscountAmount
+		order.TotalAmount += item.NetAmount
+		order.TaxAmount += item.TaxAmount
+		order.DiscountAmount += item.DiscountAmount
+	}
+
+	var shippingDiscount float64
+	if input.ShippingMethod != "" {
+		quote, err := QuoteShipping(tx, input.ShippingMethod, Shipment{
+			Lines:      input.Lines,
+			Country:    address.Country,
+			PostalCode: address.PostalCode,
+			Value:      ToBase(goodsValue, rate),
+		}, at)
+		if err != nil {
+			return nil, err
+		}
+		shippingPrice := RoundMoney(quote.Price * rate)
+		if discounts.FreeShipping {
+			shippingDiscount, shippingPrice = shippingPrice, 0
+			order.DiscountAmount += shippingDiscount
+		}
+		shipping, err := taxes.Line(quote.TaxClassID, shippingPrice)
+		if err != nil {
+			return nil, err
+		}
+		order.ShippingMethod = quote.Code
+		order.ShippingAmount = shipping.NetAmount
+		order.ShippingTaxAmount = shipping.TaxAmount
+		order.TaxAmount += shipping.TaxAmount
+		order.EstimatedDeliveryFrom = &quote.EstimatedDeliveryFrom
+		order.EstimatedDeliveryTo = &quote.EstimatedDeliveryTo
+	}
+
+	order.TotalAmount = RoundMoney(order.TotalAmount)
+	order.TaxAmount = RoundMoney(order.TaxAmount)
+	order.DiscountAmount = RoundMoney(order.DiscountAmount)
+	order.FinalAmount = RoundMoney(order.TotalAmount + order.ShippingAmount + order.TaxAmount)
+	order.BaseTotalAmount = ToBase(order.TotalAmount, rate)
+	order.BaseShippingAmount = ToBase(order.ShippingAmount, rate)
+	order.BaseTaxAmount = ToBase(order.TaxAmount, rate)
+	order.BaseDiscountAmount = ToBase(order.DiscountAmount, rate)
+	order.BaseFinalAmount = ToBase(order.FinalAmount, rate)
+
+	return &pricedOrder{
+		order:            order,
+		items:            items,
+		demands:          demands,
+		discounts:        discounts,
+		shippingDiscount: shippingDiscount,
+	}, nil
+}
+
+// PreviewAllocation shows how lines would be allocated without reserving
+// anything. Bundle lines are expanded into their components.
+func PreviewAllocation(db *gorm.DB, lines []OrderLine, opts AllocationOptions) (*AllocationPlan, error) {
+	var demands []StockDemand
+	for i, line := range lines {
+		var product models.Product
+		if err := db.First(&product, line.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %d", ErrProductNotFound, line.ProductID)
+			}
+			return nil, err
+		}
+		if !product.IsBundle {
+			demands = append(demands, StockDemand{Key: demandKey(i, -1), ProductID: product.ID, Quantity: line.Quantity})
+			continue
+		}
+
+		components, err := bundleComponents(db, &product)
+		if err != nil {
+			return nil, err
+		}
+		if len(components) == 0 {
+			return nil, ErrInvalidBundle
+		}
+		for j, component := range components {
+			demands = append(demands, StockDemand{Key: demandKey(i, j), ProductID: component.ComponentID, Quantity: component.Quantity * line.Quantity})
+		}
+	}
+
+	opts.Lock = false
+	return PlanAllocation(db, demands, opts)
+}
+
+func allocationRecords(item *models.OrderItem, component *models.OrderItemComponent, allocations []Allocation) []models.OrderItemAllocation {
+	records := make([]models.OrderItemAllocation, 0, len(allocations))
+	for _, allocation := range allocations {
+		record := models.OrderItemAllocation{
+			OrderItemID:     item.ID,
+			ProductID:       item.ProductID,
+			InventoryItemID: allocation.InventoryItemID,
+			WarehouseID:     allocation.WarehouseID,
+			Quantity:        allocation.Quantity,
+			Status:          models.AllocationStatusReserved,
+		}
+		if component != nil {
+			record.OrderItemComponentID = &component.ID
+			record.ProductID = component.ProductID
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// MinShelfLifeDays is the shelf life a customer's perishable stock must
+// have left: the contract's when it sets one, otherwise the company's. A
+// zero userID means an unknown customer. The contract must be one of the
+// customer's company's.
+func MinShelfLifeDays(db *gorm.DB, userID uint, contractID *uint) (int, error) {
+	var user models.User
+	if userID != 0 {
+		if err := db.First(&user, userID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, err
+		}
+	}
+
+	if contractID != nil {
+		if user.CompanyID == nil {
+			return 0, ErrContractNotFound
+		}
+		var contract models.Contract
+		if err := db.Preload("Company").Where("company_id = ?", *user.CompanyID).
+			First(&contract, *contractID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, ErrContractNotFound
+			}
+			return 0, err
+		}
+		if contract.MinShelfLifeDays != nil {
+			return *contract.MinShelfLifeDays, nil
+		}
+		if contract.Company != nil {
+			return contract.Company.MinShelfLifeDays, nil
+		}
+	}
+
+	if user.CompanyID == nil {
+		return 0, nil
+	}
+	var company models.Company
+	if err := db.First(&company, *user.CompanyID).Error; err != nil {
+		return 0, err
+	}
+	return company.MinShelfLifeDays, nil
+}
+
+// demandKey packs a line index and a component index (-1 for plain lines)
+// into a StockDemand key
+func demandKey(line, component int) int {
+	return line<<16 | (component + 1)
+}
+
+func splitDemandKey(key int) (line, component int) {
+	return key >> 16, key&0xffff - 1
+}
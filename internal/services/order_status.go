@@ -0,0 +1,255 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTransition = errors.New("status change not allowed")
+	ErrUnknownStatus     = errors.New("unknown status")
+)
+
+// orderTransitions is the order status graph. Cancelled and returned
+// orders are final.
+var orderTransitions = map[models.OrderStatus][]models.OrderStatus{
+	models.OrderStatusPending:    {models.OrderStatusProcessing, models.OrderStatusCancelled},
+	models.OrderStatusProcessing: {models.OrderStatusShipped, models.OrderStatusCancelled},
+	models.OrderStatusShipped:    {models.OrderStatusDelivered, models.OrderStatusReturned},
+	models.OrderStatusDelivered:  {models.OrderStatusReturned},
+}
+
+// paymentTransitions is the payment status graph. A failed payment can be
+// retried; refunds are final.
+var paymentTransitions = map[models.PaymentStatus][]models.PaymentStatus{
+	models.PaymentStatusPending: {models.PaymentStatusPaid, models.PaymentStatusFailed},
+	models.PaymentStatusFailed:  {models.PaymentStatusPaid, models.PaymentStatusPending},
+	models.PaymentStatusPaid:    {models.PaymentStatusRefunded},
+}
+
+// orderGuards refuse a transition the graph allows when the order is not
+// ready for it. A guard returns the reason, or "" to allow it.
+var orderGuards = map[models.OrderStatus]func(order *models.Order) string{
+	models.OrderStatusShipped: func(order *models.Order) string {
+		if prepaid(order) && order.PaymentStatus != models.PaymentStatusPaid {
+			return "prepaid orders must be paid before they ship"
+		}
+		return ""
+	},
+}
+
+// onAccountPaymentMethods are paid after delivery, on the customer's terms
+var onAccountPaymentMethods = map[string]bool{
+	"invoice":    true,
+	"on_account": true,
+}
+
+func prepaid(order *models.Order) bool {
+	return !onAccountPaymentMethods[strings.ToLower(order.PaymentMethod)]
+}
+
+// TransitionError is a refused status change. Allowed lists the states the
+// order can move to now.
+type TransitionError struct {
+	Field   models.OrderStatusField
+	From    string
+	To      string
+	Reason  string
+	Allowed []string
+}
+
+func (e *TransitionError) Error() string {
+	message := fmt.Sprintf("cannot change %s from %s to %s", strings.ToLower(string(e.Field)), e.From, e.To)
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+	return message
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// AllowedOrderStatuses are the statuses the order can move to now, guards
+// included
+func AllowedOrderStatuses(order *models.Order) []models.OrderStatus {
+	allowed := []models.OrderStatus{}
+	for _, next := range orderTransitions[order.Status] {
+		if guard, ok := orderGuards[next]; ok && guard(order) != "" {
+			continue
+		}
+		allowed = append(allowed, next)
+	}
+	return allowed
+}
+
+// AllowedPaymentStatuses are the payment statuses the order can move to now
+func AllowedPaymentStatuses(order *models.Order) []models.PaymentStatus {
+	return append([]models.PaymentStatus{}, paymentTransitions[order.PaymentStatus]...)
+}
+
+// StatusChange asks for a new order status, payment status or both. Empty
+// fields are left alone.
+type StatusChange struct {
+	Status        models.OrderStatus
+	PaymentStatus models.PaymentStatus
+	Reason        string
+	UserID        *uint
+}
+
+// ChangeOrderStatus moves a locked order through the status graphs and
+// runs the side effects: stock follows the order status, dates are set,
+// the history is written and the customer is notified. The payment status
+// changes first, so an order can be paid and shipped in one change.
+func ChangeOrderStatus(tx *gorm.DB, order *models.Order, change StatusChange, now time.Time) error {
+	if change.PaymentStatus != "" && change.PaymentStatus != order.PaymentStatus {
+		if err := changePaymentStatus(tx, order, change, now); err != nil {
+			return err
+		}
+	}
+	if change.Status != "" && change.Status != order.Status {
+		if err := changeOrderStatus(tx, order, change, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func changePaymentStatus(tx *gorm.DB, order *models.Order, change StatusChange, now time.Time) error {
+	if !knownPaymentStatus(change.PaymentStatus) {
+		return fmt.Errorf("%w: %s", ErrUnknownStatus, change.PaymentStatus)
+	}
+	if !containsStatus(paymentTransitions[order.PaymentStatus], change.PaymentStatus) {
+		return &TransitionError{
+			Field:   models.OrderStatusFieldPaymentStatus,
+			From:    string(order.PaymentStatus),
+			To:      string(change.PaymentStatus),
+			Allowed: statusStrings(AllowedPaymentStatuses(order)),
+		}
+	}
+
+	from := order.PaymentStatus
+	updates := map[string]interface{}{"payment_status": change.PaymentStatus}
+	if change.PaymentStatus == models.PaymentStatusPaid && order.PaymentDate == nil {
+		order.PaymentDate = &now
+		updates["payment_date"] = now
+	}
+	order.PaymentStatus = change.PaymentStatus
+	if err := tx.Model(order).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if err := recordOrderStatus(tx, order, models.OrderStatusFieldPaymentStatus, string(from), string(order.PaymentStatus), change); err != nil {
+		return err
+	}
+	return Notify(tx, order.UserID, models.NotificationTypePaymentStatus,
+		fmt.Sprintf("Payment %s for order %s", strings.ToLower(string(order.PaymentStatus)), order.OrderNumber),
+		change.Reason, ReferenceTypeOrder, order.ID)
+}
+
+func changeOrderStatus(tx *gorm.DB, order *models.Order, change StatusChange, now time.Time) error {
+	if !knownOrderStatus(change.Status) {
+		return fmt.Errorf("%w: %s", ErrUnknownStatus, change.Status)
+	}
+	refuse := func(reason string) error {
+		return &TransitionError{
+			Field:   models.OrderStatusFieldStatus,
+			From:    string(order.Status),
+			To:      string(change.Status),
+			Reason:  reason,
+			Allowed: statusStrings(AllowedOrderStatuses(order)),
+		}
+	}
+	if !containsStatus(orderTransitions[order.Status], change.Status) {
+		return refuse("")
+	}
+	if guard, ok := orderGuards[change.Status]; ok {
+		if reason := guard(order); reason != "" {
+			return refuse(reason)
+		}
+	}
+
+	from := order.Status
+	updates := map[string]interface{}{"status": change.Status}
+	if change.Status == models.OrderStatusShipped && order.ShippedDate == nil {
+		order.ShippedDate = &now
+		updates["shipped_date"] = now
+	}
+	if change.Status == models.OrderStatusDelivered && order.DeliveredDate == nil {
+		order.DeliveredDate = &now
+		updates["delivered_date"] = now
+	}
+	order.Status = change.Status
+	if err := tx.Model(order).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	// Give back coupon uses before touching stock, keeping the lock order
+	// PlaceOrder uses
+	if order.Status == models.OrderStatusCancelled {
+		if err := ReleasePromotions(tx, order.ID, now); err != nil {
+			return err
+		}
+	}
+	// Release, issue or put back the order's stock to match its status
+	if err := SyncReservations(tx, order, change.UserID); err != nil {
+		return err
+	}
+	if err := recordOrderStatus(tx, order, models.OrderStatusFieldStatus, string(from), string(order.Status), change); err != nil {
+		return err
+	}
+	return Notify(tx, order.UserID, models.NotificationTypeOrderStatus,
+		fmt.Sprintf("Order %s is %s", order.OrderNumber, strings.ToLower(string(order.Status))),
+		change.Reason, ReferenceTypeOrder, order.ID)
+}
+
+// recordOrderStatus appends to the order's status history
+func recordOrderStatus(tx *gorm.DB, order *models.Order, field models.OrderStatusField, from, to string, change StatusChange) error {
+	return tx.Create(&models.OrderStatusHistory{
+		OrderID:     order.ID,
+		Field:       field,
+		FromStatus:  from,
+		ToStatus:    to,
+		ChangedByID: change.UserID,
+		Reason:      change.Reason,
+	}).Error
+}
+
+func knownOrderStatus(status models.OrderStatus) bool {
+	switch status {
+	case models.OrderStatusPending, models.OrderStatusProcessing, models.OrderStatusShipped,
+		models.OrderStatusDelivered, models.OrderStatusCancelled, models.OrderStatusReturned:
+		return true
+	}
+	return false
+}
+
+func knownPaymentStatus(status models.PaymentStatus) bool {
+	switch status {
+	case models.PaymentStatusPending, models.PaymentStatusPaid, models.PaymentStatusFailed, models.PaymentStatusRefunded:
+		return true
+	}
+	return false
+}
+
+func containsStatus[S ~string](statuses []S, status S) bool {
+	for _, candidate := range statuses {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
+func statusStrings[S ~string](statuses []S) []string {
+	out := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		out = append(out, string(status))
+	}
+	return out
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNoPriceRecorded = errors.New("no price recorded for that date")
+
+type Prices struct {
+	BasePrice float64 `json:"base_price"`
+	B2BPrice  float64 `json:"b2b_price"`
+	CostPrice float64 `json:"cost_price"`
+}
+
+// PriceChange describes who or what changed a product's prices
+type PriceChange struct {
+	Source                 models.PriceChangeSource
+	Reason                 string
+	ChangedByID            *uint
+	ScheduledPriceChangeID *uint
+	EffectiveAt            time.Time
+}
+
+// CurrentPrices returns the prices currently set on product
+func CurrentPrices(product *models.Product) Prices {
+	return Prices{
+		BasePrice: product.BasePrice,
+		B2BPrice:  product.B2BPrice,
+		CostPrice: product.CostPrice,
+	}
+}
+
+// RecordPriceChange writes a history row when the prices differ. Callers
+// that save the product themselves use this directly.
+func RecordPriceChange(tx *gorm.DB, productID uint, old, new Prices, change PriceChange) error {
+	if old == new && change.Source != models.PriceChangeSourceCreated {
+		return nil
+	}
+	if change.EffectiveAt.IsZero() {
+		change.EffectiveAt = time.Now()
+	}
+
+	return tx.Create(&models.PriceHistory{
+		ProductID:              productID,
+		OldBasePrice:           old.BasePrice,
+		NewBasePrice:           new.BasePrice,
+		OldB2BPrice:            old.B2BPrice,
+		NewB2BPrice:            new.B2BPrice,
+		OldCostPrice:           old.CostPrice,
+		NewCostPrice:           new.CostPrice,
+		Source:                 change.Source,
+		Reason:                 change.Reason,
+		EffectiveAt:            change.EffectiveAt,
+		ScheduledPriceChangeID: change.ScheduledPriceChangeID,
+		ChangedByID:            change.ChangedByID,
+	}).Error
+}
+
+// ChangePrices updates the product's prices and records the change
+func ChangePrices(tx *gorm.DB, product *models.Product, prices Prices, change PriceChange) error {
+	old := CurrentPrices(product)
+	if old == prices {
+		return nil
+	}
+
+	if err := tx.Model(product).Updates(map[string]interface{}{
+		"base_price": prices.BasePrice,
+		"b2b_price":  prices.B2BPrice,
+		"cost_price": prices.CostPrice,
+	}).Error; err != nil {
+		return err
+	}
+	product.BasePrice, product.B2BPrice, product.CostPrice = prices.BasePrice, prices.B2BPrice, prices.CostPrice
+
+	return RecordPriceChange(tx, product.ID, old, prices, change)
+}
+
+// PriceAt answers what a product cost at a point in time, using the price
+// history. Products that predate the history fall back to their first
+// recorded old prices, or to their current prices when never changed.
+func PriceAt(db *gorm.DB, product *models.Product, at time.Time) (Prices, *models.PriceHistory, error) {
+	var entry models.PriceHistory
+	err := db.Where("product_id = ? AND effective_at <= ?", product.ID, at).
+		Order("effective_at DESC, id DESC").
+		First(&entry).Error
+	if err == nil {
+		return Prices{entry.NewBasePrice, entry.NewB2BPrice, entry.NewCostPrice}, &entry, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Prices{}, nil, err
+	}
+
+	err = db.Where("product_id = ?", product.ID).
+		Order("effective_at ASC, id ASC").
+		First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if product.CreatedAt.After(at) {
+			return Prices{}, nil, ErrNoPriceRecorded
+		}
+		return CurrentPrices(product), nil, nil
+	}
+	if err != nil {
+		return Prices{}, nil, err
+	}
+	if entry.Source == models.PriceChangeSourceCreated {
+		return Prices{}, nil, ErrNoPriceRecorded
+	}
+
+	return Prices{entry.OldBasePrice, entry.OldB2BPrice, entry.OldCostPrice}, nil, nil
+}
+
+// ApplyScheduledPriceChanges starts due price changes and ends expired
+// sales. Rows are locked with SKIP LOCKED so several instances can run it.
+func ApplyScheduledPriceChanges(db *gorm.DB, now time.Time) error {
+	var due []models.ScheduledPriceChange
+	if err := db.Where("status = ? AND starts_at <= ?", models.ScheduledPriceStatusPending, now).
+		Order("starts_at, id").
+		Find(&due).Error; err != nil {
+		return err
+	}
+	for _, change := range due {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return startScheduledPriceChange(tx, change.ID, now)
+		}); err != nil {
+			return err
+		}
+	}
+
+	var ended []models.ScheduledPriceChange
+	if err := db.Where("status = ? AND ends_at <= ?", models.ScheduledPriceStatusActive, now).
+		Order("ends_at, id").
+		Find(&ended).Error; err != nil {
+		return err
+	}
+	for _, change := range ended {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return EndSale(tx, change.ID, now)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startScheduledPriceChange(tx *gorm.DB, id uint, now time.Time) error {
+	var change models.ScheduledPriceChange
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", models.ScheduledPriceStatusPending).
+		First(&change, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // picked up elsewhere or cancelled
+	}
+	if err != nil {
+		return err
+	}
+
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, change.ProductID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Model(&change).Update("status", models.ScheduledPriceStatusCancelled).Error
+		}
+		return err
+	}
+
+	old := CurrentPrices(&product)
+	prices := old
+	if change.BasePrice != nil {
+		prices.BasePrice = *change.BasePrice
+	}
+	if change.B2BPrice != nil {
+		prices.B2BPrice = *change.B2BPrice
+	}
+	if change.CostPrice != nil {
+		prices.CostPrice = *change.CostPrice
+	}
+
+	source := models.PriceChangeSourceScheduled
+	status := models.ScheduledPriceStatusCompleted
+	updates := map[string]interface{}{"applied_at": now}
+	if change.IsSale() {
+		source = models.PriceChangeSourceSaleStart
+		status = models.ScheduledPriceStatusActive
+		updates["previous_base_price"] = old.BasePrice
+		updates["previous_b2b_price"] = old.B2BPrice
+		updates["previous_cost_price"] = old.CostPrice
+	}
+	updates["status"] = status
+
+	if err := ChangePrices(tx, &product, prices, PriceChange{
+		Source:                 source,
+		Reason:                 change.Reason,
+		ChangedByID:            change.CreatedByID,
+		ScheduledPriceChangeID: &change.ID,
+		EffectiveAt:            now,
+	}); err != nil {
+		return err
+	}
+
+	return tx.Model(&change).Updates(updates).Error
+}
+
+// EndSale restores the prices a sale replaced. Prices edited by hand while
+// the sale ran are left alone.
+func EndSale(tx *gorm.DB, id uint, now time.Time) error {
+	var change models.ScheduledPriceChange
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", models.ScheduledPriceStatusActive).
+		First(&change, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, change.ProductID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	} else if err == nil {
+		prices := CurrentPrices(&product)
+		revert := func(current *float64, sale, previous *float64) {
+			if sale != nil && previous != nil && *current == *sale {
+				*current = *previous
+			}
+		}
+		revert(&prices.BasePrice, change.BasePrice, change.PreviousBasePrice)
+		revert(&prices.B2BPrice, change.B2BPrice, change.PreviousB2BPrice)
+		revert(&prices.CostPrice, change.CostPrice, change.PreviousCostPrice)
+
+		if err := ChangePrices(tx, &product, prices, PriceChange{
+			Source:                 models.PriceChangeSourceSaleEnd,
+			Reason:                 change.Reason,
+			ChangedByID:            change.CreatedByID,
+			ScheduledPriceChangeID: &change.ID,
+			EffectiveAt:            now,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&change).Updates(map[string]interface{}{
+		"status":      models.ScheduledPriceStatusCompleted,
+		"reverted_at": now,
+	}).Error
+}
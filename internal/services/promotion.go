@@ -0,0 +1,498 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCouponRejected = errors.New("coupon cannot be used")
+
+// CouponError is a coupon code refused for Reason
+type CouponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *CouponError) Error() string {
+	return fmt.Sprintf("coupon %s: %s", e.Code, e.Reason)
+}
+
+func (e *CouponError) Unwrap() error {
+	return ErrCouponRejected
+}
+
+// DiscountLine is a priced line promotions are applied to. TotalAmount is
+// in the order currency.
+type DiscountLine struct {
+	ProductID   uint
+	Quantity    int
+	TotalAmount float64
+}
+
+// DiscountInput is a priced basket and the coupons entered for it
+type DiscountInput struct {
+	UserID       uint
+	CustomerType models.UserType
+	CouponCodes  []string
+	Lines        []DiscountLine
+	Rate         float64 // order currency per unit of the base currency
+	At           time.Time
+
+	// Lock takes the coupons FOR UPDATE, so usage limits hold until the
+	// redemptions are written
+	Lock bool
+}
+
+// LineDiscount is one promotion's share of a line's discount
+type LineDiscount struct {
+	PromotionID uint
+	CouponID    *uint
+	Amount      float64
+}
+
+// AppliedPromotion is a promotion that discounted the basket. Amount is
+// what it took off the lines, in the order currency.
+type AppliedPromotion struct {
+	PromotionID  uint                 `json:"promotion_id"`
+	CouponID     *uint                `json:"coupon_id,omitempty"`
+	Code         string               `json:"code,omitempty"`
+	Name         string               `json:"name"`
+	Type         models.PromotionType `json:"type"`
+	Amount       float64              `json:"amount"`
+	FreeShipping bool                 `json:"free_shipping,omitempty"`
+}
+
+// Discounts are the promotions applied to a basket, with each line's
+// share of them
+type Discounts struct {
+	Applied      []AppliedPromotion `json:"applied"`
+	Lines        [][]LineDiscount   `json:"-"`
+	FreeShipping bool               `json:"free_shipping"`
+}
+
+// LineAmount is the total discount on line i
+func (d *Discounts) LineAmount(i int) float64 {
+	var amount float64
+	for _, discount := range d.Lines[i] {
+		amount += discount.Amount
+	}
+	return RoundMoney(amount)
+}
+
+// Total is the discount across all lines
+func (d *Discounts) Total() float64 {
+	var total float64
+	for i := range d.Lines {
+		total += d.LineAmount(i)
+	}
+	return RoundMoney(total)
+}
+
+type promotionCandidate struct {
+	promotion *models.Promotion
+	coupon    *models.Coupon
+}
+
+// ApplyPromotions works out the discounts on a basket. Automatic
+// promotions the basket does not qualify for are skipped; a coupon that
+// cannot be used is refused with a CouponError. Promotions apply highest
+// priority first, each to what earlier ones left of the lines.
+func ApplyPromotions(db *gorm.DB, input DiscountInput) (*Discounts, error) {
+	var goodsValue float64
+	for _, line := range input.Lines {
+		goodsValue += line.TotalAmount
+	}
+	goodsValue = ToBase(goodsValue, input.Rate)
+
+	candidates, err := couponCandidates(db, input, goodsValue)
+	if err != nil {
+		return nil, err
+	}
+	var automatic []models.Promotion
+	if err := activePromotions(db, input.At).Where("NOT requires_coupon").
+		Preload("Products").Preload("Categories").Find(&automatic).Error; err != nil {
+		return nil, err
+	}
+	for i := range automatic {
+		if promotionIneligible(&automatic[i], input.CustomerType, goodsValue, input.At) == "" {
+			candidates = append(candidates, promotionCandidate{promotion: &automatic[i]})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].promotion.Priority != candidates[j].promotion.Priority {
+			return candidates[i].promotion.Priority > candidates[j].promotion.Priority
+		}
+		return candidates[i].promotion.ID < candidates[j].promotion.ID
+	})
+
+	scope, err := newPromotionScope(db, input.Lines)
+	if err != nil {
+		return nil, err
+	}
+	return applyPromotionCandidates(candidates, scope, input.Rate)
+}
+
+// applyPromotionCandidates applies the candidates in order. Once one
+// applies, later ones only apply while every promotion so far and the
+// candidate are stackable; a coupon that cannot apply is refused.
+func applyPromotionCandidates(candidates []promotionCandidate, scope *promotionScope, rate float64) (*Discounts, error) {
+	lines := scope.lines
+	discounts := &Discounts{Lines: make([][]LineDiscount, len(lines))}
+	remaining := make([]float64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.TotalAmount
+	}
+	stackable := true
+	for _, candidate := range candidates {
+		promotion := candidate.promotion
+		if len(discounts.Applied) > 0 && (!stackable || !promotion.Stackable) {
+			if candidate.coupon != nil {
+				return nil, &CouponError{Code: candidate.coupon.Code, Reason: "cannot be combined with other promotions"}
+			}
+			continue
+		}
+
+		eligible := scope.eligible(promotion)
+		amounts := promotionAmounts(promotion, lines, eligible, remaining, rate)
+		applied := AppliedPromotion{PromotionID: promotion.ID, Name: promotion.Name, Type: promotion.Type}
+		if candidate.coupon != nil {
+			applied.CouponID = &candidate.coupon.ID
+			applied.Code = candidate.coupon.Code
+		}
+		for i, amount := range amounts {
+			if amount <= 0 {
+				continue
+			}
+			remaining[i] = RoundMoney(remaining[i] - amount)
+			applied.Amount += amount
+			discounts.Lines[i] = append(discounts.Lines[i], LineDiscount{PromotionID: promotion.ID, CouponID: applied.CouponID, Amount: amount})
+		}
+		applied.Amount = RoundMoney(applied.Amount)
+		if promotion.Type == models.PromotionTypeFreeShipping && len(eligible) > 0 {
+			applied.FreeShipping = true
+			discounts.FreeShipping = true
+		}
+		if applied.Amount == 0 && !applied.FreeShipping {
+			if candidate.coupon != nil {
+				return nil, &CouponError{Code: candidate.coupon.Code, Reason: "no items in the order qualify"}
+			}
+			continue
+		}
+
+		stackable = stackable && promotion.Stackable
+		discounts.Applied = append(discounts.Applied, applied)
+	}
+	return discounts, nil
+}
+
+// RedeemPromotions records the discounts applied to a placed order and
+// uses up its coupons. The coupons must have been locked by
+// ApplyPromotions. shippingDiscount is the shipping charge waived.
+func RedeemPromotions(tx *gorm.DB, order *models.Order, discounts *Discounts, shippingDiscount float64) error {
+	for _, applied := range discounts.Applied {
+		amount := applied.Amount
+		if applied.FreeShipping {
+			amount = RoundMoney(amount + shippingDiscount)
+			shippingDiscount = 0
+		}
+		redemption := models.PromotionRedemption{
+			PromotionID:        applied.PromotionID,
+			CouponID:           applied.CouponID,
+			OrderID:            order.ID,
+			UserID:             order.UserID,
+			DiscountAmount:     amount,
+			BaseDiscountAmount: ToBase(amount, order.ExchangeRate),
+		}
+		if err := tx.Create(&redemption).Error; err != nil {
+			return err
+		}
+		if applied.CouponID != nil {
+			if err := tx.Model(&models.Coupon{}).Where("id = ?", *applied.CouponID).
+				UpdateColumn("usage_count", gorm.Expr("usage_count + 1")).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReleasePromotions gives back the coupon uses of a cancelled order
+func ReleasePromotions(tx *gorm.DB, orderID uint, now time.Time) error {
+	var redemptions []models.PromotionRedemption
+	if err := tx.Where("order_id = ? AND released_at IS NULL", orderID).Find(&redemptions).Error; err != nil {
+		return err
+	}
+	for _, redemption := range redemptions {
+		if redemption.CouponID != nil {
+			if err := tx.Model(&models.Coupon{}).Where("id = ? AND usage_count > 0", *redemption.CouponID).
+				UpdateColumn("usage_count", gorm.Expr("usage_count - 1")).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&redemption).Update("released_at", now).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeCouponCode is how coupon codes are stored and looked up
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// couponCandidates looks up the coupons entered, in code order so
+// concurrent orders lock them in the same order
+func couponCandidates(db *gorm.DB, input DiscountInput, goodsValue float64) ([]promotionCandidate, error) {
+	codes := make([]string, 0, len(input.CouponCodes))
+	seen := make(map[string]bool)
+	for _, code := range input.CouponCodes {
+		code = NormalizeCouponCode(code)
+		if code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	var candidates []promotionCandidate
+	promotions := make(map[uint]bool)
+	for _, code := range codes {
+		query := db.Where("code = ?", code)
+		if input.Lock {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		var coupon models.Coupon
+		if err := query.First(&coupon).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, &CouponError{Code: code, Reason: "unknown code"}
+			}
+			return nil, err
+		}
+
+		var promotion models.Promotion
+		if err := db.Preload("Products").Preload("Categories").First(&promotion, coupon.PromotionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, &CouponError{Code: code, Reason: "unknown code"}
+			}
+			return nil, err
+		}
+		if !coupon.IsActive {
+			return nil, &CouponError{Code: code, Reason: "no longer valid"}
+		}
+		if reason := promotionIneligible(&promotion, input.CustomerType, goodsValue, input.At); reason != "" {
+			return nil, &CouponError{Code: code, Reason: reason}
+		}
+		if promotions[promotion.ID] {
+			return nil, &CouponError{Code: code, Reason: "another code for the same promotion was entered"}
+		}
+		if coupon.UsageLimit != nil && coupon.UsageCount >= *coupon.UsageLimit {
+			return nil, &CouponError{Code: code, Reason: "usage limit reached"}
+		}
+		if coupon.UsageLimitPerCustomer != nil {
+			var used int64
+			if err := db.Model(&models.PromotionRedemption{}).
+				Where("coupon_id = ? AND user_id = ? AND released_at IS NULL", coupon.ID, input.UserID).
+				Count(&used).Error; err != nil {
+				return nil, err
+			}
+			if used >= int64(*coupon.UsageLimitPerCustomer) {
+				return nil, &CouponError{Code: code, Reason: "already used the maximum number of times"}
+			}
+		}
+
+		promotions[promotion.ID] = true
+		candidates = append(candidates, promotionCandidate{promotion: &promotion, coupon: &coupon})
+	}
+	return candidates, nil
+}
+
+func activePromotions(db *gorm.DB, at time.Time) *gorm.DB {
+	return db.Where("is_active AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", at, at)
+}
+
+// promotionIneligible is why the buyer cannot have the promotion, or ""
+func promotionIneligible(promotion *models.Promotion, customerType models.UserType, goodsValue float64, at time.Time) string {
+	switch {
+	case !promotion.IsActive:
+		return "no longer valid"
+	case promotion.StartsAt != nil && at.Before(*promotion.StartsAt):
+		return "not valid yet"
+	case promotion.EndsAt != nil && !at.Before(*promotion.EndsAt):
+		return "expired"
+	case promotion.CustomerType != "" && promotion.CustomerType != customerType:
+		return fmt.Sprintf("only for %s customers", promotion.CustomerType)
+	case goodsValue < promotion.MinSpend:
+		return fmt.Sprintf("requires a minimum spend of %.2f %s", promotion.MinSpend, BaseCurrency())
+	}
+	return ""
+}
+
+// promotionAmounts is what the promotion takes off each line, given what
+// is left of them
+func promotionAmounts(promotion *models.Promotion, lines []DiscountLine, eligible []int, remaining []float64, rate float64) []float64 {
+	amounts := make([]float64, len(lines))
+	switch promotion.Type {
+	case models.PromotionTypePercentage:
+		for _, i := range eligible {
+			amounts[i] = RoundMoney(remaining[i] * promotion.Value / 100)
+		}
+
+	case models.PromotionTypeFixedAmount:
+		var base float64
+		for _, i := range eligible {
+			base += remaining[i]
+		}
+		total := RoundMoney(promotion.Value * rate)
+		if total > base {
+			total = base
+		}
+		allocateDiscount(amounts, total, eligible, remaining, base)
+
+	case models.PromotionTypeBuyXGetY:
+		group := promotion.BuyQuantity + promotion.GetQuantity
+		if promotion.GetQuantity <= 0 || group <= 0 {
+			break
+		}
+		// Discount the cheapest units, at what is left of their price
+		type unit struct {
+			line  int
+			price float64
+		}
+		var units []unit
+		for _, i := range eligible {
+			if lines[i].Quantity <= 0 {
+				continue
+			}
+			price := remaining[i] / float64(lines[i].Quantity)
+			for n := 0; n < lines[i].Quantity; n++ {
+				units = append(units, unit{i, price})
+			}
+		}
+		sort.SliceStable(units, func(a, b int) bool { return units[a].price < units[b].price })
+		free := len(units) / group * promotion.GetQuantity
+		for _, u := range units[:free] {
+			amounts[u.line] += u.price * promotion.Value / 100
+		}
+		for _, i := range eligible {
+			amounts[i] = RoundMoney(amounts[i])
+			if amounts[i] > remaining[i] {
+				amounts[i] = remaining[i]
+			}
+		}
+	}
+	return amounts
+}
+
+// allocateDiscount spreads total over the lines in proportion to what is
+// left of them. The last line takes the rounding difference.
+func allocateDiscount(amounts []float64, total float64, lines []int, remaining []float64, base float64) {
+	if total <= 0 || base <= 0 {
+		return
+	}
+	allocated := 0.0
+	for n, i := range lines {
+		share := RoundMoney(total * remaining[i] / base)
+		if n == len(lines)-1 {
+			share = RoundMoney(total - allocated)
+		}
+		if share > remaining[i] {
+			share = remaining[i]
+		}
+		amounts[i] = share
+		allocated += share
+	}
+}
+
+// promotionScope finds the lines a promotion applies to
+type promotionScope struct {
+	db         *gorm.DB
+	lines      []DiscountLine
+	categories map[uint][]uint // categories of each line's product
+	parents    map[uint]*uint
+}
+
+func newPromotionScope(db *gorm.DB, lines []DiscountLine) (*promotionScope, error) {
+	scope := &promotionScope{db: db, lines: lines}
+	return scope, scope.load()
+}
+
+func (s *promotionScope) load() error {
+	productIDs := make([]uint, 0, len(s.lines))
+	for _, line := range s.lines {
+		productIDs = append(productIDs, line.ProductID)
+	}
+	var links []struct {
+		ProductID  uint
+		CategoryID uint
+	}
+	if len(productIDs) > 0 {
+		if err := s.db.Table("product_categories").Select("product_id, category_id").
+			Where("product_id IN ?", productIDs).Scan(&links).Error; err != nil {
+			return err
+		}
+	}
+	s.categories = make(map[uint][]uint)
+	for _, link := range links {
+		s.categories[link.ProductID] = append(s.categories[link.ProductID], link.CategoryID)
+	}
+
+	var categories []models.Category
+	if err := s.db.Select("id", "parent_id").Find(&categories).Error; err != nil {
+		return err
+	}
+	s.parents = make(map[uint]*uint, len(categories))
+	for _, category := range categories {
+		s.parents[category.ID] = category.ParentID
+	}
+	return nil
+}
+
+// eligible lists the lines in the promotion's scope. A category promotion
+// covers products in its categories' subcategories too.
+func (s *promotionScope) eligible(promotion *models.Promotion) []int {
+	var lines []int
+	for i, line := range s.lines {
+		switch promotion.Scope {
+		case models.PromotionScopeProduct:
+			for _, product := range promotion.Products {
+				if product.ID == line.ProductID {
+					lines = append(lines, i)
+					break
+				}
+			}
+		case models.PromotionScopeCategory:
+			if s.inCategories(line.ProductID, promotion.Categories) {
+				lines = append(lines, i)
+			}
+		default:
+			lines = append(lines, i)
+		}
+	}
+	return lines
+}
+
+func (s *promotionScope) inCategories(productID uint, categories []models.Category) bool {
+	wanted := make(map[uint]bool, len(categories))
+	for _, category := range categories {
+		wanted[category.ID] = true
+	}
+	for _, categoryID := range s.categories[productID] {
+		// Walk up to the root; the depth guard stops at a cycle
+		id := &categoryID
+		for depth := 0; id != nil && depth < 50; depth++ {
+			if wanted[*id] {
+				return true
+			}
+			id = s.parents[*id]
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     float64
+		lines     []int
+		remaining []float64
+		want      []float64
+	}{
+		{
+			name:      "rounding remainder goes on the last line",
+			total:     10,
+			lines:     []int{0, 1, 2},
+			remaining: []float64{10, 10, 10},
+			want:      []float64{3.33, 3.33, 3.34},
+		},
+		{
+			name:      "split in proportion to what is left",
+			total:     10,
+			lines:     []int{0, 1, 2},
+			remaining: []float64{20, 9, 5},
+			want:      []float64{5.88, 2.65, 1.47},
+		},
+		{
+			name:      "lines outside the promotion get nothing",
+			total:     6,
+			lines:     []int{0, 2},
+			remaining: []float64{10, 50, 20},
+			want:      []float64{2, 0, 4},
+		},
+		{
+			name:      "whole amount left takes every line to zero",
+			total:     34,
+			lines:     []int{0, 1, 2},
+			remaining: []float64{20, 9, 5},
+			want:      []float64{20, 9, 5},
+		},
+		{
+			name:      "nothing to allocate",
+			total:     0,
+			lines:     []int{0, 1},
+			remaining: []float64{10, 10},
+			want:      []float64{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base float64
+			for _, i := range tt.lines {
+				base += tt.remaining[i]
+			}
+			amounts := make([]float64, len(tt.remaining))
+			allocateDiscount(amounts, tt.total, tt.lines, tt.remaining, base)
+			if !reflect.DeepEqual(amounts, tt.want) {
+				t.Errorf("allocateDiscount(%v) = %v, want %v", tt.total, amounts, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromotionAmounts(t *testing.T) {
+	lines := []DiscountLine{
+		{ProductID: 1, Quantity: 2, TotalAmount: 20},
+		{ProductID: 2, Quantity: 3, TotalAmount: 9},
+		{ProductID: 3, Quantity: 1, TotalAmount: 5},
+	}
+	all := []int{0, 1, 2}
+
+	tests := []struct {
+		name      string
+		promotion models.Promotion
+		eligible  []int
+		remaining []float64
+		rate      float64
+		want      []float64
+	}{
+		{
+			name:      "percentage off each line",
+			promotion: models.Promotion{Type: models.PromotionTypePercentage, Value: 10},
+			eligible:  all,
+			want:      []float64{2, 0.9, 0.5},
+		},
+		{
+			name:      "percentage off eligible lines only",
+			promotion: models.Promotion{Type: models.PromotionTypePercentage, Value: 10},
+			eligible:  []int{1},
+			want:      []float64{0, 0.9, 0},
+		},
+		{
+			name:      "percentage of what earlier promotions left",
+			promotion: models.Promotion{Type: models.PromotionTypePercentage, Value: 10},
+			eligible:  all,
+			remaining: []float64{10, 9, 5},
+			want:      []float64{1, 0.9, 0.5},
+		},
+		{
+			name:      "fixed amount split across lines",
+			promotion: models.Promotion{Type: models.PromotionTypeFixedAmount, Value: 10},
+			eligible:  all,
+			want:      []float64{5.88, 2.65, 1.47},
+		},
+		{
+			name:      "fixed amount converted to the order currency",
+			promotion: models.Promotion{Type: models.PromotionTypeFixedAmount, Value: 5},
+			eligible:  all,
+			rate:      2,
+			want:      []float64{5.88, 2.65, 1.47},
+		},
+		{
+			name:      "fixed amount capped at the eligible lines",
+			promotion: models.Promotion{Type: models.PromotionTypeFixedAmount, Value: 100},
+			eligible:  all,
+			want:      []float64{20, 9, 5},
+		},
+		{
+			name:      "buy two get the cheapest free",
+			promotion: models.Promotion{Type: models.PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Value: 100},
+			eligible:  all,
+			want:      []float64{0, 6, 0},
+		},
+		{
+			name:      "buy two get the cheapest half price",
+			promotion: models.Promotion{Type: models.PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Value: 50},
+			eligible:  all,
+			want:      []float64{0, 3, 0},
+		},
+		{
+			name:      "free shipping takes nothing off the lines",
+			promotion: models.Promotion{Type: models.PromotionTypeFreeShipping},
+			eligible:  all,
+			want:      []float64{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining := tt.remaining
+			if remaining == nil {
+				remaining = []float64{20, 9, 5}
+			}
+			rate := tt.rate
+			if rate == 0 {
+				rate = 1
+			}
+			amounts := promotionAmounts(&tt.promotion, lines, tt.eligible, remaining, rate)
+			if !reflect.DeepEqual(amounts, tt.want) {
+				t.Errorf("promotionAmounts = %v, want %v", amounts, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPromotionCandidates(t *testing.T) {
+	promotion := func(id uint, value float64, stackable bool) *models.Promotion {
+		return &models.Promotion{Model: gorm.Model{ID: id}, Type: models.PromotionTypePercentage, Value: value, Stackable: stackable}
+	}
+	// elsewhere only covers a product not in the basket
+	elsewhere := promotion(9, 10, false)
+	elsewhere.Scope = models.PromotionScopeProduct
+	elsewhere.Products = []models.Product{{Model: gorm.Model{ID: 99}}}
+	coupon := &models.Coupon{Code: "SAVE10"}
+
+	tests := []struct {
+		name       string
+		candidates []promotionCandidate
+		wantIDs    []uint
+		wantTotal  float64
+		wantReason string
+	}{
+		{
+			name:       "stackable promotions apply one after another",
+			candidates: []promotionCandidate{{promotion: promotion(1, 10, true)}, {promotion: promotion(2, 10, true)}},
+			wantIDs:    []uint{1, 2},
+			wantTotal:  19,
+		},
+		{
+			name:       "non-stackable promotion keeps later ones off",
+			candidates: []promotionCandidate{{promotion: promotion(1, 10, false)}, {promotion: promotion(2, 10, true)}},
+			wantIDs:    []uint{1},
+			wantTotal:  10,
+		},
+		{
+			name:       "non-stackable promotion cannot join applied ones",
+			candidates: []promotionCandidate{{promotion: promotion(1, 10, true)}, {promotion: promotion(2, 20, false)}},
+			wantIDs:    []uint{1},
+			wantTotal:  10,
+		},
+		{
+			name:       "promotion that takes nothing does not block others",
+			candidates: []promotionCandidate{{promotion: elsewhere}, {promotion: promotion(2, 20, false)}},
+			wantIDs:    []uint{2},
+			wantTotal:  20,
+		},
+		{
+			name:       "coupon that cannot be combined is refused",
+			candidates: []promotionCandidate{{promotion: promotion(1, 10, false)}, {promotion: promotion(2, 10, true), coupon: coupon}},
+			wantReason: "cannot be combined with other promotions",
+		},
+		{
+			name:       "coupon for no item in the basket is refused",
+			candidates: []promotionCandidate{{promotion: elsewhere, coupon: coupon}},
+			wantReason: "no items in the order qualify",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := &promotionScope{lines: []DiscountLine{{ProductID: 1, Quantity: 1, TotalAmount: 100}}}
+			discounts, err := applyPromotionCandidates(tt.candidates, scope, 1)
+			if tt.wantReason != "" {
+				var couponErr *CouponError
+				if !errors.As(err, &couponErr) || !errors.Is(err, ErrCouponRejected) || couponErr.Reason != tt.wantReason {
+					t.Fatalf("err = %v, want coupon refused: %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPromotionCandidates: %v", err)
+			}
+
+			var ids []uint
+			for _, applied := range discounts.Applied {
+				ids = append(ids, applied.PromotionID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("applied %v, want %v", ids, tt.wantIDs)
+			}
+			if total := discounts.Total(); total != tt.wantTotal {
+				t.Errorf("total discount %v, want %v", total, tt.wantTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	affinityJobName   = "frequently-bought-together"
+	affinityBatchSize = 500
+)
+
+// MineFrequentlyBoughtTogether folds orders placed since the last run into
+// the product affinity counts. Each batch and its checkpoint are committed
+// together, so an interrupted run picks up where it stopped.
+func MineFrequentlyBoughtTogether(db *gorm.DB, now time.Time) error {
+	for {
+		processed, err := mineAffinityBatch(db, now)
+		if err != nil {
+			return err
+		}
+		if processed < affinityBatchSize {
+			return nil
+		}
+	}
+}
+
+func mineAffinityBatch(db *gorm.DB, now time.Time) (int, error) {
+	var processed int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		checkpoint := models.JobCheckpoint{Name: affinityJobName}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&checkpoint).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&checkpoint, "name = ?", affinityJobName).Error; err != nil {
+			return err
+		}
+
+		var orderIDs []uint
+		if err := tx.Model(&models.Order{}).
+			Where("id > ? AND status <> ?", checkpoint.LastID, models.OrderStatusCancelled).
+			Order("id").
+			Limit(affinityBatchSize).
+			Pluck("id", &orderIDs).Error; err != nil {
+			return err
+		}
+		processed = len(orderIDs)
+		if processed == 0 {
+			return nil
+		}
+
+		var lines []struct {
+			OrderID   uint
+			ProductID uint
+		}
+		if err := tx.Model(&models.OrderItem{}).
+			Distinct("order_id", "product_id").
+			Where("order_id IN ?", orderIDs).
+			Order("order_id, product_id").
+			Scan(&lines).Error; err != nil {
+			return err
+		}
+
+		baskets := make(map[uint][]uint)
+		for _, line := range lines {
+			baskets[line.OrderID] = append(baskets[line.OrderID], line.ProductID)
+		}
+
+		orderCounts := make(map[uint]int)
+		pairCounts := make(map[[2]uint]int)
+		for _, products := range baskets {
+			for _, a := range products {
+				orderCounts[a]++
+				for _, b := range products {
+					if a != b {
+						pairCounts[[2]uint{a, b}]++
+					}
+				}
+			}
+		}
+
+		if err := upsertOrderStats(tx, orderCounts, now); err != nil {
+			return err
+		}
+		if err := upsertAffinities(tx, pairCounts, now); err != nil {
+			return err
+		}
+		if err := refreshAffinityScores(tx, orderCounts); err != nil {
+			return err
+		}
+
+		return tx.Model(&checkpoint).Updates(map[string]interface{}{
+			"last_id":    orderIDs[len(orderIDs)-1],
+			"updated_at": now,
+		}).Error
+	})
+	return processed, err
+}
+
+func upsertOrderStats(tx *gorm.DB, counts map[uint]int, now time.Time) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	stats := make([]models.ProductOrderStat, 0, len(counts))
+	for productID, count := range counts {
+		stats = append(stats, models.ProductOrderStat{ProductID: productID, OrderCount: count, UpdatedAt: now})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ProductID < stats[j].ProductID })
+
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "product_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "order_count"}, Value: gorm.Expr("product_order_stats.order_count + EXCLUDED.order_count")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("EXCLUDED.updated_at")},
+		},
+	}).CreateInBatches(&stats, 200).Error
+}
+
+func upsertAffinities(tx *gorm.DB, counts map[[2]uint]int, now time.Time) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	affinities := make([]models.ProductAffinity, 0, len(counts))
+	for pair, count := range counts {
+		affinities = append(affinities, models.ProductAffinity{
+			ProductID:        pair[0],
+			RelatedProductID: pair[1],
+			CoOrderCount:     count,
+			UpdatedAt:        now,
+		})
+	}
+	sort.Slice(affinities, func(i, j int) bool {
+		if affinities[i].ProductID != affinities[j].ProductID {
+			return affinities[i].ProductID < affinities[j].ProductID
+		}
+		return affinities[i].RelatedProductID < affinities[j].RelatedProductID
+	})
+
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "product_id"}, {Name: "related_product_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "co_order_count"}, Value: gorm.Expr("product_affinities.co_order_count + EXCLUDED.co_order_count")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("EXCLUDED.updated_at")},
+		},
+	}).CreateInBatches(&affinities, 200).Error
+}
+
+// refreshAffinityScores recomputes scores for every product whose order
+// count moved, since that changes the denominator for all its pairs
+func refreshAffinityScores(tx *gorm.DB, counts map[uint]int) error {
+	productIDs := make([]uint, 0, len(counts))
+	for productID := range counts {
+		productIDs = append(productIDs, productID)
+	}
+	return tx.Exec(`UPDATE product_affinities AS a
+		SET score = a.co_order_count::float8 / s.order_count
+		FROM product_order_stats AS s
+		WHERE s.product_id = a.product_id AND s.order_count > 0 AND a.product_id IN ?`, productIDs).Error
+}
+
+// FrequentlyBoughtTogether returns the products most often ordered with
+// productID, ignoring pairs seen fewer than minOrders times
+func FrequentlyBoughtTogether(db *gorm.DB, productID uint, minOrders, limit int) ([]models.ProductAffinity, error) {
+	var affinities []models.ProductAffinity
+	err := db.Where("product_id = ? AND co_order_count >= ?", productID, minOrders).
+		Order("score DESC, co_order_count DESC, related_product_id").
+		Limit(limit).
+		Find(&affinities).Error
+	return affinities, err
+}
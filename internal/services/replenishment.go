@@ -0,0 +1,290 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// inboundPOStatuses are the purchase order statuses whose unreceived
+// quantities count as inbound. Drafts count so a suggestion is not raised
+// twice while a buyer reviews it.
+var inboundPOStatuses = []models.POStatus{
+	models.POStatusDraft,
+	models.POStatusPending,
+	models.POStatusApproved,
+	models.POStatusOrdered,
+	models.POStatusShipped,
+}
+
+type stockKey struct {
+	ProductID   uint
+	WarehouseID uint
+}
+
+type supplierKey struct {
+	SupplierID  uint
+	WarehouseID uint
+}
+
+type stockLevel struct {
+	stockKey
+	Quantity int
+}
+
+// reorderLine is a quantity to order for one reorder rule
+type reorderLine struct {
+	rule     models.ReorderRule
+	quantity int
+}
+
+// CheckStockLevels compares each active reorder rule with available and
+// inbound stock. It raises, updates or resolves the rule's stock alert, and
+// puts rules at or below their reorder point into one draft purchase order
+// per preferred supplier and warehouse.
+func CheckStockLevels(db *gorm.DB, now time.Time) error {
+	var rules []models.ReorderRule
+	if err := db.Joins("JOIN products ON products.id = reorder_rules.product_id AND products.deleted_at IS NULL").
+		Joins("JOIN warehouses ON warehouses.id = reorder_rules.warehouse_id AND warehouses.deleted_at IS NULL AND warehouses.is_active").
+		Where("reorder_rules.is_active").
+		Order("reorder_rules.id").
+		Find(&rules).Error; err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	available, err := availableStockLevels(db, now)
+	if err != nil {
+		return err
+	}
+	inbound, err := inboundStockLevels(db)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		orders := make(map[supplierKey][]reorderLine)
+		for _, rule := range rules {
+			key := stockKey{rule.ProductID, rule.WarehouseID}
+			if err := refreshStockAlert(tx, rule, available[key], inbound[key], now); err != nil {
+				return err
+			}
+
+			projected := available[key] + inbound[key]
+			if rule.SupplierID == nil || rule.ReorderQuantity <= 0 || projected > rule.ReorderPoint {
+				continue
+			}
+			// Whole multiples of the reorder quantity that lift the projected
+			// level above the reorder point
+			multiples := (rule.ReorderPoint-projected)/rule.ReorderQuantity + 1
+			order := supplierKey{*rule.SupplierID, rule.WarehouseID}
+			orders[order] = append(orders[order], reorderLine{rule, multiples * rule.ReorderQuantity})
+		}
+
+		keys := make([]supplierKey, 0, len(orders))
+		for key := range orders {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].SupplierID != keys[j].SupplierID {
+				return keys[i].SupplierID < keys[j].SupplierID
+			}
+			return keys[i].WarehouseID < keys[j].WarehouseID
+		})
+		for _, key := range keys {
+			if err := raiseDraftPurchaseOrder(tx, key.SupplierID, key.WarehouseID, orders[key], now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// availableStockLevels is sellable, unreserved stock by product and
+// warehouse
+func availableStockLevels(db *gorm.DB, now time.Time) (map[stockKey]int, error) {
+	var levels []stockLevel
+	if err := db.Model(&models.InventoryItem{}).
+		Scopes(sellableStock(now)).
+		Select("inventory_items.product_id, inventory_items.warehouse_id, SUM(inventory_items.quantity - inventory_items.reserved) AS quantity").
+		Group("inventory_items.product_id, inventory_items.warehouse_id").
+		Scan(&levels).Error; err != nil {
+		return nil, err
+	}
+	return stockLevelMap(levels), nil
+}
+
+// inboundStockLevels is the unreceived quantity on open purchase orders by
+// product and destination warehouse
+func inboundStockLevels(db *gorm.DB) (map[stockKey]int, error) {
+	var levels []stockLevel
+	if err := db.Model(&models.POItem{}).
+		Select("po_items.product_id, purchase_orders.warehouse_id, SUM(GREATEST(po_items.quantity - po_items.received_quantity, 0)) AS quantity").
+		Joins("JOIN purchase_orders ON purchase_orders.id = po_items.po_id AND purchase_orders.deleted_at IS NULL").
+		Where("purchase_orders.status IN ? AND purchase_orders.warehouse_id IS NOT NULL", inboundPOStatuses).
+		Group("po_items.product_id, purchase_orders.warehouse_id").
+		Scan(&levels).Error; err != nil {
+		return nil, err
+	}
+	return stockLevelMap(levels), nil
+}
+
+func stockLevelMap(levels []stockLevel) map[stockKey]int {
+	byKey := make(map[stockKey]int, len(levels))
+	for _, level := range levels {
+		byKey[level.stockKey] = level.Quantity
+	}
+	return byKey
+}
+
+// refreshStockAlert keeps the rule's unresolved alert in step with
+// available stock. An acknowledged alert reopens if it becomes critical.
+func refreshStockAlert(tx *gorm.DB, rule models.ReorderRule, available, inbound int, now time.Time) error {
+	var alert models.StockAlert
+	err := tx.Where("reorder_rule_id = ? AND status <> ?", rule.ID, models.StockAlertStatusResolved).
+		Order("id DESC").
+		First(&alert).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	exists := err == nil
+
+	if available > rule.ReorderPoint {
+		if !exists {
+			return nil
+		}
+		return tx.Model(&alert).Updates(map[string]interface{}{
+			"status":      models.StockAlertStatusResolved,
+			"available":   available,
+			"inbound":     inbound,
+			"resolved_at": now,
+		}).Error
+	}
+
+	level := models.StockAlertLevelLow
+	if available < rule.SafetyStock {
+		level = models.StockAlertLevelCritical
+	}
+	if !exists {
+		return tx.Create(&models.StockAlert{
+			ReorderRuleID: rule.ID,
+			ProductID:     rule.ProductID,
+			WarehouseID:   rule.WarehouseID,
+			Level:         level,
+			Status:        models.StockAlertStatusOpen,
+			Available:     available,
+			Inbound:       inbound,
+			ReorderPoint:  rule.ReorderPoint,
+			SafetyStock:   rule.SafetyStock,
+		}).Error
+	}
+
+	updates := map[string]interface{}{
+		"level":         level,
+		"available":     available,
+		"inbound":       inbound,
+		"reorder_point": rule.ReorderPoint,
+		"safety_stock":  rule.SafetyStock,
+	}
+	if level == models.StockAlertLevelCritical && alert.Level != level {
+		updates["status"] = models.StockAlertStatusOpen
+	}
+	return tx.Model(&alert).Updates(updates).Error
+}
+
+// raiseDraftPurchaseOrder writes a draft purchase order for the lines and
+// links it from their rules' alerts
+func raiseDraftPurchaseOrder(tx *gorm.DB, supplierID, warehouseID uint, lines []reorderLine, now time.Time) error {
+	po := models.PurchaseOrder{
+		SupplierID:   supplierID,
+		WarehouseID:  &warehouseID,
+		Status:       models.POStatusDraft,
+		OrderDate:    now,
+		Currency:     BaseCurrency(),
+		ExchangeRate: 1,
+		Notes:        "Suggested by reorder rules",
+	}
+	taxContext, err := PurchaseTaxContext(tx, supplierID, &warehouseID, now)
+	if err != nil {
+		return err
+	}
+	taxes := NewTaxCalculator(tx, taxContext)
+
+	items := make([]models.POItem, 0, len(lines))
+	for _, line := range lines {
+		unitPrice, err := supplierUnitPrice(tx, supplierID, line.rule.ProductID)
+		if err != nil {
+			return err
+		}
+		var product models.Product
+		if err := tx.Select("id", "tax_class_id").First(&product, line.rule.ProductID).Error; err != nil {
+			return err
+		}
+		item := models.POItem{
+			ProductID:   line.rule.ProductID,
+			Quantity:    line.quantity,
+			UnitPrice:   unitPrice,
+			TotalAmount: RoundMoney(unitPrice * float64(line.quantity)),
+			Status:      "pending",
+		}
+		if item.LineTax, err = taxes.Line(product.TaxClassID, item.TotalAmount); err != nil {
+			return err
+		}
+		items = append(items, item)
+		po.TotalAmount += item.TotalAmount
+		po.TaxAmount += item.TaxAmount
+	}
+	po.TotalAmount = RoundMoney(po.TotalAmount)
+	po.TaxAmount = RoundMoney(po.TaxAmount)
+	po.FinalAmount = RoundMoney(po.TotalAmount + po.TaxAmount)
+
+	if po.PONumber, err = NextNumber(tx, models.SequenceTypePurchaseOrder, now); err != nil {
+		return err
+	}
+	if err := tx.Create(&po).Error; err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].POID = po.ID
+	}
+	if err := tx.Create(&items).Error; err != nil {
+		return err
+	}
+
+	ruleIDs := make([]uint, 0, len(lines))
+	for _, line := range lines {
+		ruleIDs = append(ruleIDs, line.rule.ID)
+	}
+	return tx.Model(&models.StockAlert{}).
+		Where("reorder_rule_id IN ? AND status <> ?", ruleIDs, models.StockAlertStatusResolved).
+		Update("purchase_order_id", po.ID).Error
+}
+
+// supplierUnitPrice is the price last paid to the supplier for the product
+// in the base currency, or the product's cost price
+func supplierUnitPrice(tx *gorm.DB, supplierID, productID uint) (float64, error) {
+	var last models.POItem
+	err := tx.Joins("JOIN purchase_orders ON purchase_orders.id = po_items.po_id").
+		Where("purchase_orders.supplier_id = ? AND purchase_orders.currency = ? AND purchase_orders.status <> ?",
+			supplierID, BaseCurrency(), models.POStatusCancelled).
+		Where("po_items.product_id = ?", productID).
+		Order("purchase_orders.order_date DESC, po_items.id DESC").
+		First(&last).Error
+	if err == nil {
+		return last.UnitPrice, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var product models.Product
+	if err := tx.Select("cost_price").First(&product, productID).Error; err != nil {
+		return 0, err
+	}
+	return product.CostPrice, nil
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// allocationTransitions says what happens to an order's allocations when it
+// reaches a status: which allocation states move, to what, and the stock
+// movement that goes with it
+var allocationTransitions = map[models.OrderStatus][]struct {
+	from     models.AllocationStatus
+	to       models.AllocationStatus
+	movement models.MovementType
+}{
+	models.OrderStatusCancelled: {
+		{models.AllocationStatusReserved, models.AllocationStatusReleased, models.MovementTypeRelease},
+	},
+	models.OrderStatusShipped: {
+		{models.AllocationStatusReserved, models.AllocationStatusShipped, models.MovementTypeShipment},
+	},
+	models.OrderStatusDelivered: {
+		{models.AllocationStatusReserved, models.AllocationStatusShipped, models.MovementTypeShipment},
+	},
+	models.OrderStatusReturned: {
+		{models.AllocationStatusReserved, models.AllocationStatusReleased, models.MovementTypeRelease},
+		{models.AllocationStatusShipped, models.AllocationStatusReturned, models.MovementTypeReturn},
+	},
+}
+
+// SyncReservations brings an order's stock in line with its status:
+// cancelling releases reserved stock, shipping issues it and a return puts
+// shipped stock back. Allocations already in their final state are left
+// alone, so it is safe to call more than once.
+func SyncReservations(tx *gorm.DB, order *models.Order, userID *uint) error {
+	transitions, ok := allocationTransitions[order.Status]
+	if !ok {
+		return nil
+	}
+
+	ref := MovementRef{Type: ReferenceTypeOrder, ID: order.ID, UserID: userID}
+	for _, transition := range transitions {
+		var allocations []models.OrderItemAllocation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "order_item_allocations"}}).
+			Joins("JOIN order_items ON order_items.id = order_item_allocations.order_item_id").
+			Where("order_items.order_id = ? AND order_item_allocations.status = ?", order.ID, transition.from).
+			Order("order_item_allocations.inventory_item_id, order_item_allocations.id").
+			Find(&allocations).Error; err != nil {
+			return err
+		}
+
+		for _, allocation := range allocations {
+			if err := moveAllocation(tx, &allocation, transition.movement, ref); err != nil {
+				return err
+			}
+			if err := tx.Model(&allocation).Update("status", transition.to).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveAllocation applies the stock movement for one allocation. Items in
+// the trash still have their balances kept in step with the ledger.
+func moveAllocation(tx *gorm.DB, allocation *models.OrderItemAllocation, movementType models.MovementType, ref MovementRef) error {
+	tx = tx.Unscoped()
+	var inventory models.InventoryItem
+	if err := tx.First(&inventory, allocation.InventoryItemID).Error; err != nil {
+		return err
+	}
+
+	quantity := allocation.Quantity
+	switch movementType {
+	case models.MovementTypeRelease:
+		_, err := moveStock(tx, &inventory, movementType, 0, -quantity, ref)
+		return err
+	case models.MovementTypeShipment:
+		_, err := moveStock(tx, &inventory, movementType, -quantity, -quantity, ref)
+		return err
+	default:
+		_, err := moveStock(tx, &inventory, movementType, quantity, 0, ref)
+		return err
+	}
+}
+
+// ErrReservationsStranded means a batch leaving sale holds reservations
+// that no other sellable batch can take over
+var ErrReservationsStranded = errors.New("reserved stock cannot be moved to other batches")
+
+// moveReservations releases the open allocations on a batch that is no
+// longer sellable and reserves the same quantities for each order from its
+// other batches, first-expired-first-out. The batch's status must already
+// have changed, so it is not picked again.
+func moveReservations(tx *gorm.DB, inventory *models.InventoryItem, userID *uint, now time.Time) error {
+	var allocations []models.OrderItemAllocation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("inventory_item_id = ? AND status = ?", inventory.ID, models.AllocationStatusReserved).
+		Order("id").
+		Find(&allocations).Error; err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		var item models.OrderItem
+		if err := tx.Select("id", "order_id").First(&item, allocation.OrderItemID).Error; err != nil {
+			return err
+		}
+		var order models.Order
+		if err := tx.Preload("ShippingAddress").First(&order, item.OrderID).Error; err != nil {
+			return err
+		}
+
+		ref := MovementRef{Type: ReferenceTypeOrder, ID: order.ID, UserID: userID, Note: "Moved off batch " + inventory.Status}
+		if _, err := moveStock(tx, inventory, models.MovementTypeRelease, 0, -allocation.Quantity, ref); err != nil {
+			return err
+		}
+		if err := tx.Model(&allocation).Update("status", models.AllocationStatusReleased).Error; err != nil {
+			return err
+		}
+
+		minShelfLife, err := MinShelfLifeDays(tx, order.UserID, nil)
+		if err != nil {
+			return err
+		}
+		plan, err := PlanAllocation(tx, []StockDemand{{ProductID: allocation.ProductID, Quantity: allocation.Quantity}}, AllocationOptions{
+			Strategy:         AllocationStrategyPriority,
+			Destination:      &order.ShippingAddress,
+			MinShelfLifeDays: minShelfLife,
+			Now:              now,
+			Lock:             true,
+		})
+		if err != nil {
+			return err
+		}
+		demand := plan.Demands[0]
+		if demand.Shortfall > 0 {
+			return fmt.Errorf("%w: order %d would be short %d of product %d",
+				ErrReservationsStranded, order.ID, demand.Shortfall, allocation.ProductID)
+		}
+
+		for _, planned := range demand.Allocations {
+			if err := ReserveAllocation(tx, allocation.ProductID, planned, ref); err != nil {
+				return err
+			}
+			if err := tx.Create(&models.OrderItemAllocation{
+				OrderItemID:          allocation.OrderItemID,
+				OrderItemComponentID: allocation.OrderItemComponentID,
+				ProductID:            allocation.ProductID,
+				InventoryItemID:      planned.InventoryItemID,
+				WarehouseID:          planned.WarehouseID,
+				Quantity:             planned.Quantity,
+				Status:               models.AllocationStatusReserved,
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CancelUnpaidOrders cancels pending orders still unpaid after the
+// configured timeout and releases their stock
+func CancelUnpaidOrders(db *gorm.DB, now time.Time) error {
+	if settings.UnpaidOrderTimeout <= 0 {
+		return nil
+	}
+
+	var due []models.Order
+	if err := db.Select("id").
+		Where("status = ? AND payment_status IN ? AND order_date <= ?",
+			models.OrderStatusPending,
+			[]models.PaymentStatus{models.PaymentStatusPending, models.PaymentStatusFailed},
+			now.Add(-settings.UnpaidOrderTimeout)).
+		Order("order_date, id").
+		Find(&due).Error; err != nil {
+		return err
+	}
+
+	for _, order := range due {
+		if err := Transaction(db, func(tx *gorm.DB) error {
+			return cancelUnpaidOrder(tx, order.ID, now)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cancelUnpaidOrder(tx *gorm.DB, id uint, now time.Time) error {
+	var order models.Order
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND payment_status IN ?", models.OrderStatusPending,
+			[]models.PaymentStatus{models.PaymentStatusPending, models.PaymentStatusFailed}).
+		First(&order, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // paid, cancelled or being handled elsewhere
+	}
+	if err != nil {
+		return err
+	}
+
+	return ChangeOrderStatus(tx, &order, StatusChange{
+		Status: models.OrderStatusCancelled,
+		Reason: "Cancelled automatically: unpaid after " + settings.UnpaidOrderTimeout.String(),
+	}, now)
+}
+
+// ReservationDrift is an inventory item whose reserved balance differs from
+// the reservations its open order allocations account for
+type ReservationDrift struct {
+	InventoryItemID uint `json:"inventory_item_id"`
+	Reserved        int  `json:"reserved"`
+	Expected        int  `json:"expected"`
+}
+
+// FixOrphanedReservations settles allocations left reserved on orders that
+// have been cancelled, returned, shipped or deleted, then releases any
+// reserved stock no open allocation accounts for. Items reserving less
+// than their allocations need are left for ReservationDrifts to report.
+func FixOrphanedReservations(db *gorm.DB, now time.Time) error {
+	var orders []models.Order
+	if err := db.Unscoped().Select("DISTINCT orders.id, orders.status, orders.deleted_at").
+		Joins("JOIN order_items ON order_items.order_id = orders.id").
+		Joins("JOIN order_item_allocations ON order_item_allocations.order_item_id = order_items.id").
+		Where("order_item_allocations.status = ? AND order_item_allocations.deleted_at IS NULL", models.AllocationStatusReserved).
+		Where("orders.status IN ? OR orders.deleted_at IS NOT NULL", []models.OrderStatus{
+			models.OrderStatusCancelled,
+			models.OrderStatusReturned,
+			models.OrderStatusShipped,
+			models.OrderStatusDelivered,
+		}).
+		Find(&orders).Error; err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if order.DeletedAt.Valid {
+			order.Status = models.OrderStatusCancelled
+		}
+		if err := Transaction(db, func(tx *gorm.DB) error {
+			return SyncReservations(tx, &order, nil)
+		}); err != nil {
+			return err
+		}
+	}
+
+	drifts, err := ReservationDrifts(db)
+	if err != nil {
+		return err
+	}
+	for _, drift := range drifts {
+		if drift.Reserved < drift.Expected {
+			continue
+		}
+		if err := Transaction(db, func(tx *gorm.DB) error {
+			return releaseOrphanedReservation(tx, drift.InventoryItemID)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReservationDrifts compares every inventory item's reserved balance with
+// its open allocations. Order lines from before allocations were recorded
+// count through OrderItem.InventoryItemID while their order is open.
+func ReservationDrifts(db *gorm.DB) ([]ReservationDrift, error) {
+	var drifts []ReservationDrift
+	err := db.Raw(`SELECT i.id AS inventory_item_id, i.reserved, COALESCE(e.expected, 0) AS expected
+		FROM inventory_items i
+		LEFT JOIN (`+expectedReservationsSQL+`) e ON e.inventory_item_id = i.id
+		WHERE i.reserved <> COALESCE(e.expected, 0)
+		ORDER BY i.id`, expectedReservationsArgs(nil)).
+		Scan(&drifts).Error
+	return drifts, err
+}
+
+const expectedReservationsSQL = `SELECT inventory_item_id, SUM(quantity) AS expected FROM (
+		SELECT a.inventory_item_id, a.quantity
+		FROM order_item_allocations a
+		WHERE a.status = @reserved AND a.deleted_at IS NULL
+		UNION ALL
+		SELECT oi.inventory_item_id, oi.quantity
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id AND o.deleted_at IS NULL
+		WHERE oi.inventory_item_id IS NOT NULL AND oi.deleted_at IS NULL
+			AND o.status IN @open
+			AND NOT EXISTS (SELECT 1 FROM order_item_allocations a WHERE a.order_item_id = oi.id)
+	) r GROUP BY inventory_item_id`
+
+func expectedReservationsArgs(inventoryItemID *uint) map[string]interface{} {
+	args := map[string]interface{}{
+		"reserved": models.AllocationStatusReserved,
+		"open":     []models.OrderStatus{models.OrderStatusPending, models.OrderStatusProcessing},
+	}
+	if inventoryItemID != nil {
+		args["id"] = *inventoryItemID
+	}
+	return args
+}
+
+// releaseOrphanedReservation rechecks an item under lock and releases the
+// reserved stock no open allocation accounts for
+func releaseOrphanedReservation(tx *gorm.DB, inventoryItemID uint) error {
+	var inventory models.InventoryItem
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&inventory, inventoryItemID).Error; err != nil {
+		return err
+	}
+
+	var expected int
+	if err := tx.Raw(`SELECT COALESCE(SUM(expected), 0) FROM (`+expectedReservationsSQL+`) e WHERE inventory_item_id = @id`,
+		expectedReservationsArgs(&inventory.ID)).Scan(&expected).Error; err != nil {
+		return err
+	}
+	if inventory.Reserved <= expected {
+		return nil
+	}
+
+	_, err := moveStock(tx.Unscoped(), &inventory, models.MovementTypeRelease, 0, expected-inventory.Reserved, MovementRef{
+		Type: ReferenceTypeReconcile,
+		Note: "Released orphaned reservation",
+	})
+	return err
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"marketprogo/pkg/database"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB connects to the Postgres database in TEST_DATABASE_DSN and
+// migrates it, skipping the test when none is configured
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(25)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// TestPlaceOrderConcurrently places hundreds of orders in parallel against
+// limited stock spread over several warehouses and batches, and checks that
+// nothing is oversold and every reservation is accounted for.
+func TestPlaceOrderConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	run := time.Now().Format("20060102150405.000000")
+
+	user := models.User{Email: "concurrency-" + run + "@example.com", PasswordHash: "-", UserType: models.UserTypeB2C}
+	mustCreate(t, db, &user)
+	address := models.Address{StreetAddress1: "1 Test Street", City: "London", PostalCode: "E1 1AA", Country: "GB", UserID: &user.ID}
+	mustCreate(t, db, &address)
+
+	zone := models.ShippingZone{Name: "Test " + run, Locations: []models.ShippingZoneLocation{{Country: "GB"}}}
+	mustCreate(t, db, &zone)
+	method := models.ShippingMethod{Code: "T-" + run, Name: "Test", IsActive: true, RateBasis: models.ShippingRateBasisItems,
+		Rates: []models.ShippingRate{{ZoneID: zone.ID, Price: 4.95}}}
+	mustCreate(t, db, &method)
+
+	var warehouses []models.Warehouse
+	for i := 0; i < 2; i++ {
+		warehouseAddress := models.Address{StreetAddress1: "Unit " + fmt.Sprint(i), City: "Leeds", PostalCode: "LS1 1AA", Country: "GB"}
+		mustCreate(t, db, &warehouseAddress)
+		warehouse := models.Warehouse{Name: fmt.Sprintf("Test %d", i), Code: fmt.Sprintf("T%d-%s", i, run), AddressID: warehouseAddress.ID, IsActive: true, Priority: 100 + i}
+		mustCreate(t, db, &warehouse)
+		warehouses = append(warehouses, warehouse)
+	}
+
+	// Each product has 60 units: two batches in the first warehouse and
+	// one in the second
+	const stockPerProduct = 60
+	var products []models.Product
+	var items []models.InventoryItem
+	for i := 0; i < 2; i++ {
+		product := models.Product{Name: fmt.Sprintf("Concurrency %d", i), SKU: fmt.Sprintf("CONC-%d-%s", i, run), BasePrice: 10}
+		mustCreate(t, db, &product)
+		products = append(products, product)
+
+		for j, batch := range []struct {
+			warehouse int
+			quantity  int
+		}{{0, 25}, {0, 15}, {1, 20}} {
+			item := models.InventoryItem{
+				ProductID:   product.ID,
+				WarehouseID: warehouses[batch.warehouse].ID,
+				BatchNumber: fmt.Sprintf("B%d", j),
+				Status:      models.InventoryStatusActive,
+			}
+			mustCreate(t, db, &item)
+			if err := db.Transaction(func(tx *gorm.DB) error {
+				_, _, err := AdjustStock(tx, item.ID, StockAdjustment{QuantityChange: batch.quantity, Reason: models.AdjustmentReasonInitialStock})
+				return err
+			}); err != nil {
+				t.Fatalf("stock item: %v", err)
+			}
+			items = append(items, item)
+		}
+	}
+
+	const orders = 300
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		placed   = make(map[uint]int)
+		failures int
+	)
+	for n := 0; n < orders; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(n)))
+			var lines []OrderLine
+			for _, product := range products {
+				if random.Intn(3) > 0 {
+					lines = append(lines, OrderLine{ProductID: product.ID, Quantity: 1 + random.Intn(3)})
+				}
+			}
+			if len(lines) == 0 {
+				lines = append(lines, OrderLine{ProductID: products[0].ID, Quantity: 1})
+			}
+			strategies := []AllocationStrategy{AllocationStrategyPriority, AllocationStrategyFewestShipments, AllocationStrategyNearest}
+
+			err := Transact(db, func(tx *gorm.DB) error {
+				_, err := PlaceOrder(tx, PlaceOrderInput{
+					UserID:            user.ID,
+					ShippingAddressID: address.ID,
+					ShippingMethod:    method.Code,
+					PaymentMethod:     "card",
+					Strategy:          strategies[n%len(strategies)],
+					Lines:             lines,
+				})
+				return err
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			var stockErr *StockError
+			switch {
+			case err == nil:
+				for _, line := range lines {
+					placed[line.ProductID] += line.Quantity
+				}
+			case errors.As(err, &stockErr):
+				failures++
+			default:
+				t.Errorf("order %d: %v", n, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if failures == 0 {
+		t.Errorf("expected some orders to run out of stock")
+	}
+
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	var final []models.InventoryItem
+	if err := db.Where("id IN ?", ids).Find(&final).Error; err != nil {
+		t.Fatalf("load stock: %v", err)
+	}
+
+	reserved := make(map[uint]int)
+	for _, item := range final {
+		if item.Reserved < 0 || item.Reserved > item.Quantity {
+			t.Errorf("item %d: quantity %d, reserved %d", item.ID, item.Quantity, item.Reserved)
+		}
+		reserved[item.ProductID] += item.Reserved
+
+		var allocated int64
+		if err := db.Model(&models.OrderItemAllocation{}).
+			Where("inventory_item_id = ? AND status = ?", item.ID, models.AllocationStatusReserved).
+			Select("COALESCE(SUM(quantity), 0)").
+			Scan(&allocated).Error; err != nil {
+			t.Fatalf("sum allocations: %v", err)
+		}
+		if int(allocated) != item.Reserved {
+			t.Errorf("item %d: reserved %d but allocations total %d", item.ID, item.Reserved, allocated)
+		}
+	}
+
+	for _, product := range products {
+		if placed[product.ID] > stockPerProduct {
+			t.Errorf("product %d: oversold %d of %d", product.ID, placed[product.ID], stockPerProduct)
+		}
+		if reserved[product.ID] != placed[product.ID] {
+			t.Errorf("product %d: reserved %d, ordered %d", product.ID, reserved[product.ID], placed[product.ID])
+		}
+	}
+
+	drifts, err := ReconcileLedger(db)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	for _, drift := range drifts {
+		for _, id := range ids {
+			if drift.InventoryItemID == id {
+				t.Errorf("item %d: ledger out of balance: %+v", id, drift)
+			}
+		}
+	}
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// transactionAttempts bounds how often Transact retries a transaction
+const transactionAttempts = 5
+
+// Transact runs fn in a transaction, starting it again from scratch when
+// Postgres aborts it with a serialization failure or a deadlock. fn must
+// not have side effects outside the transaction; AfterCommit hooks only
+// run for the attempt that commits.
+func Transact(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	var err error
+	for attempt := 1; attempt <= transactionAttempts; attempt++ {
+		err = Transaction(db, fn)
+		if !retryable(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt*attempt) * 10 * time.Millisecond)
+	}
+	return err
+}
+
+// retryable reports whether err is a transient conflict between
+// transactions
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case "40001", // serialization_failure
+		"40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
+
+type commitHooksKey struct{}
+
+// Transaction runs fn in a transaction like db.Transaction, then runs the
+// hooks fn registered with AfterCommit once it has committed. Nested in
+// another Transaction, the hooks wait for the outer commit.
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.Statement.Context.Value(commitHooksKey{}).(*[]func()); ok {
+		return db.Transaction(fn)
+	}
+
+	hooks := &[]func(){}
+	if err := db.WithContext(context.WithValue(db.Statement.Context, commitHooksKey{}, hooks)).Transaction(fn); err != nil {
+		return err
+	}
+	for _, hook := range *hooks {
+		hook()
+	}
+	return nil
+}
+
+// AfterCommit runs hook once tx has committed, for work such as dropping
+// cache entries that must not happen while tx can still roll back or be
+// read around. Outside Transaction and Transact the hook runs at once.
+func AfterCommit(tx *gorm.DB, hook func()) {
+	hooks, ok := tx.Statement.Context.Value(commitHooksKey{}).(*[]func())
+	if !ok {
+		hook()
+		return
+	}
+	*hooks = append(*hooks, hook)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUnknownSequence = errors.New("unknown number sequence")
+	ErrInvalidPattern  = errors.New("invalid number pattern")
+)
+
+// defaultSequences are used until an admin changes a sequence's pattern
+var defaultSequences = map[models.SequenceType]models.NumberSequence{
+	models.SequenceTypeOrder:         {Pattern: "SO-{YYYY}-{seq:06}", Reset: models.SequenceResetYearly},
+	models.SequenceTypePurchaseOrder: {Pattern: "PO-{YYYY}-{seq:06}", Reset: models.SequenceResetYearly},
+	models.SequenceTypeContract:      {Pattern: "CT-{YYYY}-{seq:05}", Reset: models.SequenceResetYearly},
+	models.SequenceTypeInvoice:       {Pattern: "INV-{YYYY}-{seq:06}", Reset: models.SequenceResetYearly},
+}
+
+// maxSequenceWidth bounds the padding a pattern can ask for
+const maxSequenceWidth = 12
+
+var patternToken = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// NextNumber draws the next number of a sequence. The counter row stays
+// locked until tx ends and a rollback hands the number back, so numbers
+// are gap-free as long as the document is created in the same tx. Other
+// documents of the type wait from the draw to the commit, so draw as late
+// in the transaction as possible.
+func NextNumber(tx *gorm.DB, sequenceType models.SequenceType, now time.Time) (string, error) {
+	sequence, err := lockSequence(tx, sequenceType)
+	if err != nil {
+		return "", err
+	}
+
+	period := sequencePeriod(sequence.Reset, now)
+	if sequence.Period != period {
+		sequence.Period = period
+		sequence.LastValue = 0
+	}
+	sequence.LastValue++
+	if err := tx.Model(sequence).Updates(map[string]interface{}{
+		"period":     sequence.Period,
+		"last_value": sequence.LastValue,
+	}).Error; err != nil {
+		return "", err
+	}
+	return formatNumber(sequence.Pattern, now, sequence.LastValue)
+}
+
+// NumberSequences lists every sequence, with the defaults for those not
+// used yet
+func NumberSequences(db *gorm.DB) ([]models.NumberSequence, error) {
+	var stored []models.NumberSequence
+	if err := db.Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byType := make(map[models.SequenceType]models.NumberSequence, len(stored))
+	for _, sequence := range stored {
+		byType[sequence.Type] = sequence
+	}
+
+	sequences := make([]models.NumberSequence, 0, len(defaultSequences))
+	for sequenceType, defaults := range defaultSequences {
+		sequence, ok := byType[sequenceType]
+		if !ok {
+			sequence = defaults
+			sequence.Type = sequenceType
+		}
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i].Type < sequences[j].Type })
+	return sequences, nil
+}
+
+// UpdateNumberSequence changes a sequence's pattern and reset. The counter
+// carries on from where it is in the current period rather than restarting.
+func UpdateNumberSequence(tx *gorm.DB, sequenceType models.SequenceType, pattern string, reset models.SequenceReset, now time.Time) (*models.NumberSequence, error) {
+	if err := ValidatePattern(pattern, reset); err != nil {
+		return nil, err
+	}
+	sequence, err := lockSequence(tx, sequenceType)
+	if err != nil {
+		return nil, err
+	}
+
+	if sequence.Period != sequencePeriod(sequence.Reset, now) {
+		sequence.LastValue = 0
+	}
+	sequence.Pattern = pattern
+	sequence.Reset = reset
+	sequence.Period = sequencePeriod(reset, now)
+	if err := tx.Model(sequence).Updates(map[string]interface{}{
+		"pattern":    sequence.Pattern,
+		"reset":      sequence.Reset,
+		"period":     sequence.Period,
+		"last_value": sequence.LastValue,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return sequence, nil
+}
+
+// ValidatePattern checks a pattern has exactly one counter and, when the
+// counter resets, the date tokens that keep numbers from repeating
+func ValidatePattern(pattern string, reset models.SequenceReset) error {
+	tokens := make(map[string]bool)
+	counters := 0
+	for _, match := range patternToken.FindAllStringSubmatch(pattern, -1) {
+		token := match[1]
+		switch {
+		case token == "YYYY" || token == "YY" || token == "MM" || token == "DD":
+			tokens[token] = true
+		case token == "seq" || strings.HasPrefix(token, "seq:"):
+			if _, err := sequenceWidth(token); err != nil {
+				return err
+			}
+			counters++
+		default:
+			return fmt.Errorf("%w: unknown token {%s}", ErrInvalidPattern, token)
+		}
+	}
+	if strings.ContainsAny(patternToken.ReplaceAllString(pattern, ""), "{}") {
+		return fmt.Errorf("%w: unbalanced braces", ErrInvalidPattern)
+	}
+	if counters != 1 {
+		return fmt.Errorf("%w: needs exactly one {seq} token", ErrInvalidPattern)
+	}
+
+	hasYear := tokens["YYYY"] || tokens["YY"]
+	switch reset {
+	case models.SequenceResetNever:
+	case models.SequenceResetYearly:
+		if !hasYear {
+			return fmt.Errorf("%w: a yearly reset needs {YYYY} or {YY}", ErrInvalidPattern)
+		}
+	case models.SequenceResetMonthly:
+		if !hasYear || !tokens["MM"] {
+			return fmt.Errorf("%w: a monthly reset needs the year and {MM}", ErrInvalidPattern)
+		}
+	default:
+		return fmt.Errorf("%w: unknown reset %s", ErrInvalidPattern, reset)
+	}
+	return nil
+}
+
+// lockSequence loads a sequence for update, creating it from the defaults
+// on first use
+func lockSequence(tx *gorm.DB, sequenceType models.SequenceType) (*models.NumberSequence, error) {
+	defaults, ok := defaultSequences[sequenceType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSequence, sequenceType)
+	}
+
+	var sequence models.NumberSequence
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("type = ?", sequenceType).First(&sequence).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Concurrent first uses race to insert; the losers wait for the
+		// winner's row and then lock it
+		defaults.Type = sequenceType
+		if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "type"}}, DoNothing: true}).
+			Create(&defaults).Error; err != nil {
+			return nil, err
+		}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("type = ?", sequenceType).First(&sequence).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sequence, nil
+}
+
+func sequencePeriod(reset models.SequenceReset, now time.Time) string {
+	switch reset {
+	case models.SequenceResetYearly:
+		return now.Format("2006")
+	case models.SequenceResetMonthly:
+		return now.Format("2006-01")
+	}
+	return ""
+}
+
+func formatNumber(pattern string, now time.Time, value int64) (string, error) {
+	var formatErr error
+	number := patternToken.ReplaceAllStringFunc(pattern, func(match string) string {
+		token := match[1 : len(match)-1]
+		switch token {
+		case "YYYY":
+			return now.Format("2006")
+		case "YY":
+			return now.Format("06")
+		case "MM":
+			return now.Format("01")
+		case "DD":
+			return now.Format("02")
+		}
+		width, err := sequenceWidth(token)
+		if err != nil {
+			formatErr = err
+			return match
+		}
+		return fmt.Sprintf("%0*d", width, value)
+	})
+	return number, formatErr
+}
+
+// sequenceWidth reads the padding from a {seq} or {seq:06} token
+func sequenceWidth(token string) (int, error) {
+	if token == "seq" {
+		return 0, nil
+	}
+	digits, ok := strings.CutPrefix(token, "seq:")
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown token {%s}", ErrInvalidPattern, token)
+	}
+	width, err := strconv.Atoi(digits)
+	if err != nil || width < 0 || width > maxSequenceWidth {
+		return 0, fmt.Errorf("%w: bad counter width in {%s}", ErrInvalidPattern, token)
+	}
+	return width, nil
+}
@@ -0,0 +1,11 @@
+package services
+
+import "marketprogo/config"
+
+// settings holds the configuration the services read at runtime
+var settings = config.LoadConfig()
+
+// Configure replaces the configuration loaded at startup
+func Configure(cfg *config.Config) {
+	settings = cfg
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"marketprogo/pkg/units"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrShippingMethodUnavailable = errors.New("shipping method not available for this order")
+
+// Shipment is what is being shipped and where to
+type Shipment struct {
+	Lines      []OrderLine
+	Country    string
+	PostalCode string
+
+	// Value is the goods value in the base currency
+	Value float64
+}
+
+// ShippingQuote is what a method charges for a shipment, in the base
+// currency
+type ShippingQuote struct {
+	MethodID    uint    `json:"method_id"`
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Carrier     string  `json:"carrier,omitempty"`
+	Price       float64 `json:"price"`
+	Free        bool    `json:"free"`
+
+	MinDeliveryDays       int       `json:"min_delivery_days"`
+	MaxDeliveryDays       int       `json:"max_delivery_days"`
+	EstimatedDeliveryFrom time.Time `json:"estimated_delivery_from"`
+	EstimatedDeliveryTo   time.Time `json:"estimated_delivery_to"`
+
+	TaxClassID *uint `json:"-"`
+}
+
+// ShippingQuotes lists the methods that can deliver the shipment, cheapest
+// first
+func ShippingQuotes(db *gorm.DB, shipment Shipment, now time.Time) ([]ShippingQuote, error) {
+	quotes, err := shippingQuotes(db, shipment, "", now)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].Price < quotes[j].Price })
+	return quotes, nil
+}
+
+// QuoteShipping prices one method for the shipment, or refuses it with
+// ErrShippingMethodUnavailable
+func QuoteShipping(db *gorm.DB, code string, shipment Shipment, now time.Time) (*ShippingQuote, error) {
+	quotes, err := shippingQuotes(db, shipment, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrShippingMethodUnavailable, code)
+	}
+	return &quotes[0], nil
+}
+
+// GoodsValue prices lines at list price in the base currency, for quoting
+// shipping before an order is priced
+func GoodsValue(db *gorm.DB, lines []OrderLine) (float64, error) {
+	var value float64
+	for _, line := range lines {
+		var product models.Product
+		if err := db.First(&product, line.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, fmt.Errorf("%w: %d", ErrProductNotFound, line.ProductID)
+			}
+			return 0, err
+		}
+		price, err := ProductUnitPrice(db, &product, BaseCurrency(), 1, false)
+		if err != nil {
+			return 0, err
+		}
+		value += price * float64(line.Quantity)
+	}
+	return RoundMoney(value), nil
+}
+
+func shippingQuotes(db *gorm.DB, shipment Shipment, code string, now time.Time) ([]ShippingQuote, error) {
+	zones, err := shippingZones(db, shipment.Country, shipment.PostalCode)
+	if err != nil || len(zones) == 0 {
+		return nil, err
+	}
+	weight, items, err := measureShipment(db, shipment.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.Where("is_active").Preload("Rates", "zone_id IN ?", zones).Order("sort_order, id")
+	if code != "" {
+		query = query.Where("code = ?", code)
+	}
+	var methods []models.ShippingMethod
+	if err := query.Find(&methods).Error; err != nil {
+		return nil, err
+	}
+
+	quotes := []ShippingQuote{}
+	for _, method := range methods {
+		measure := weight
+		switch method.RateBasis {
+		case models.ShippingRateBasisValue:
+			measure = shipment.Value
+		case models.ShippingRateBasisItems:
+			measure = float64(items)
+		}
+		rate := shippingRate(method.Rates, zones, measure)
+		if rate == nil {
+			continue
+		}
+
+		quote := ShippingQuote{
+			MethodID:        method.ID,
+			Code:            method.Code,
+			Name:            method.Name,
+			Description:     method.Description,
+			Carrier:         method.Carrier,
+			Price:           rate.Price,
+			MinDeliveryDays: method.MinDeliveryDays,
+			MaxDeliveryDays: method.MaxDeliveryDays,
+			TaxClassID:      method.TaxClassID,
+		}
+		if method.FreeShippingThreshold != nil && shipment.Value >= *method.FreeShippingThreshold {
+			quote.Price, quote.Free = 0, true
+		}
+		today := startOfDay(now)
+		quote.EstimatedDeliveryFrom = addWorkingDays(today, method.MinDeliveryDays)
+		quote.EstimatedDeliveryTo = addWorkingDays(today, method.MaxDeliveryDays)
+		quotes = append(quotes, quote)
+	}
+	return quotes, nil
+}
+
+// shippingZones are the zones covering an address, most specific first
+func shippingZones(db *gorm.DB, country, postalCode string) ([]uint, error) {
+	var locations []models.ShippingZoneLocation
+	if err := db.Joins("JOIN shipping_zones ON shipping_zones.id = shipping_zone_locations.zone_id AND shipping_zones.deleted_at IS NULL").
+		Where("shipping_zone_locations.country = ?", strings.ToUpper(strings.TrimSpace(country))).
+		Find(&locations).Error; err != nil {
+		return nil, err
+	}
+
+	postcode := normalizePostcode(postalCode)
+	matched := locations[:0]
+	for _, location := range locations {
+		if strings.HasPrefix(postcode, normalizePostcode(location.PostcodePrefix)) {
+			matched = append(matched, location)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return len(matched[i].PostcodePrefix) > len(matched[j].PostcodePrefix)
+	})
+
+	zones := make([]uint, 0, len(matched))
+	seen := make(map[uint]bool)
+	for _, location := range matched {
+		if !seen[location.ZoneID] {
+			seen[location.ZoneID] = true
+			zones = append(zones, location.ZoneID)
+		}
+	}
+	return zones, nil
+}
+
+// shippingRate finds the band for measure in the most specific zone the
+// method has rates for. A gap in that zone's bands is not filled from a
+// wider zone.
+func shippingRate(rates []models.ShippingRate, zones []uint, measure float64) *models.ShippingRate {
+	for _, zone := range zones {
+		found := false
+		for i := range rates {
+			rate := &rates[i]
+			if rate.ZoneID != zone {
+				continue
+			}
+			found = true
+			if measure >= rate.MinValue && (rate.MaxValue == nil || measure < *rate.MaxValue) {
+				return rate
+			}
+		}
+		if found {
+			return nil
+		}
+	}
+	return nil
+}
+
+// measureShipment totals the weight in kg and the number of items. A
+// bundle without its own weight weighs what its components do; weights
+// without a unit are taken to be in kg.
+func measureShipment(db *gorm.DB, lines []OrderLine) (float64, int, error) {
+	var weight float64
+	var items int
+	for _, line := range lines {
+		var product models.Product
+		if err := db.First(&product, line.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, 0, fmt.Errorf("%w: %d", ErrProductNotFound, line.ProductID)
+			}
+			return 0, 0, err
+		}
+		unitWeight, err := productWeight(&product)
+		if err != nil {
+			return 0, 0, err
+		}
+		if product.IsBundle && unitWeight == 0 {
+			components, err := bundleComponents(db, &product)
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, component := range components {
+				var part models.Product
+				if err := db.First(&part, component.ComponentID).Error; err != nil {
+					return 0, 0, err
+				}
+				partWeight, err := productWeight(&part)
+				if err != nil {
+					return 0, 0, err
+				}
+				unitWeight += partWeight * float64(component.Quantity)
+			}
+		}
+		weight += unitWeight * float64(line.Quantity)
+		items += line.Quantity
+	}
+	return weight, items, nil
+}
+
+func productWeight(product *models.Product) (float64, error) {
+	if product.Weight == 0 || product.WeightUnit == "" || product.WeightUnit == "kg" {
+		return product.Weight, nil
+	}
+	return units.Convert(product.Weight, product.WeightUnit, "kg")
+}
+
+func normalizePostcode(postcode string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(postcode), " ", ""))
+}
+
+// addWorkingDays counts days forward from t, skipping weekends
+func addWorkingDays(t time.Time, days int) time.Time {
+	for days > 0 {
+		t = t.AddDate(0, 0, 1)
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return t
+}
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultRecountThreshold is the variance, in percent of the book quantity,
+// above which a line is counted again when the session does not say
+const DefaultRecountThreshold = 5.0
+
+var (
+	ErrCountState       = errors.New("stock count is not in a state that allows this")
+	ErrCountIncomplete  = errors.New("stock count has lines not counted or awaiting recount")
+	ErrEmptyCount       = errors.New("no inventory items match the count scope")
+	ErrUnknownCountLine = errors.New("line does not belong to the stock count")
+	ErrCategoryNotFound = errors.New("category not found")
+)
+
+type StockCountInput struct {
+	WarehouseID      uint
+	CategoryIDs      []uint
+	ABCClass         string
+	RecountThreshold *float64
+	Notes            string
+	UserID           *uint
+}
+
+// CreateStockCount opens a count session with one line per inventory item
+// in scope. Categories include their subcategories.
+func CreateStockCount(tx *gorm.DB, input StockCountInput) (*models.StockCount, error) {
+	var warehouse models.Warehouse
+	if err := tx.First(&warehouse, input.WarehouseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWarehouseNotFound
+		}
+		return nil, err
+	}
+
+	count := models.StockCount{
+		WarehouseID:      warehouse.ID,
+		Status:           models.StockCountStatusOpen,
+		Notes:            input.Notes,
+		ABCClass:         input.ABCClass,
+		RecountThreshold: DefaultRecountThreshold,
+		CreatedByID:      input.UserID,
+	}
+	if input.RecountThreshold != nil {
+		count.RecountThreshold = *input.RecountThreshold
+	}
+	if len(input.CategoryIDs) > 0 {
+		if err := tx.Where("id IN ?", input.CategoryIDs).Find(&count.Categories).Error; err != nil {
+			return nil, err
+		}
+		if len(count.Categories) != len(input.CategoryIDs) {
+			return nil, ErrCategoryNotFound
+		}
+	}
+
+	query := tx.Joins("JOIN products ON products.id = inventory_items.product_id AND products.deleted_at IS NULL").
+		Where("inventory_items.warehouse_id = ?", warehouse.ID).
+		Where("inventory_items.quantity > 0 OR inventory_items.status = ?", models.InventoryStatusActive).
+		Order("inventory_items.product_id, inventory_items.id")
+	if input.ABCClass != "" {
+		query = query.Where("products.abc_class = ?", input.ABCClass)
+	}
+	if len(input.CategoryIDs) > 0 {
+		query = query.Where(`inventory_items.product_id IN (SELECT product_id FROM product_categories WHERE category_id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id IN ? AND deleted_at IS NULL
+				UNION SELECT categories.id FROM categories JOIN tree ON categories.parent_id = tree.id WHERE categories.deleted_at IS NULL
+			) SELECT id FROM tree))`, input.CategoryIDs)
+	}
+	var items []models.InventoryItem
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyCount
+	}
+
+	for _, item := range items {
+		count.Lines = append(count.Lines, models.StockCountLine{
+			InventoryItemID: item.ID,
+			ProductID:       item.ProductID,
+			BatchNumber:     item.BatchNumber,
+			ExpiryDate:      item.ExpiryDate,
+		})
+	}
+	if err := tx.Omit("Categories.*").Create(&count).Error; err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// CountEntry is a counted quantity for one line
+type CountEntry struct {
+	LineID   uint
+	Quantity int
+}
+
+// RecordCounts enters counts for lines of an open session. The book
+// quantity is read at the time of counting, so stock can keep moving. A
+// first count off by more than the threshold asks for a recount, which
+// then stands; an entry on any other line replaces its count.
+func RecordCounts(tx *gorm.DB, id uint, entries []CountEntry, userID *uint, now time.Time) (*models.StockCount, error) {
+	count, err := lockStockCount(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusOpen {
+		return nil, ErrCountState
+	}
+
+	lines := make(map[uint]*models.StockCountLine, len(count.Lines))
+	for i := range count.Lines {
+		lines[count.Lines[i].ID] = &count.Lines[i]
+	}
+
+	for _, entry := range entries {
+		line, ok := lines[entry.LineID]
+		if !ok {
+			return nil, ErrUnknownCountLine
+		}
+		var item models.InventoryItem
+		if err := tx.Select("id", "quantity").First(&item, line.InventoryItemID).Error; err != nil {
+			return nil, err
+		}
+
+		quantity, expected := entry.Quantity, item.Quantity
+		variance := quantity - expected
+		recount := line.NeedsRecount
+		line.NeedsRecount = false
+		if !recount {
+			line.FirstCount = &quantity
+			line.NeedsRecount = exceedsRecountThreshold(variance, expected, count.RecountThreshold)
+		}
+		line.CountedQuantity = &quantity
+		line.ExpectedQuantity = &expected
+		line.Variance = &variance
+		line.CountedByID = userID
+		line.CountedAt = &now
+
+		if err := tx.Model(line).Updates(map[string]interface{}{
+			"first_count":       line.FirstCount,
+			"counted_quantity":  line.CountedQuantity,
+			"expected_quantity": line.ExpectedQuantity,
+			"variance":          line.Variance,
+			"needs_recount":     line.NeedsRecount,
+			"counted_by_id":     line.CountedByID,
+			"counted_at":        line.CountedAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return count, nil
+}
+
+func exceedsRecountThreshold(variance, expected int, threshold float64) bool {
+	if variance == 0 {
+		return false
+	}
+	if expected == 0 {
+		return true
+	}
+	return math.Abs(float64(variance))*100 > threshold*float64(expected)
+}
+
+// SubmitStockCount closes counting and values the variances for approval
+func SubmitStockCount(tx *gorm.DB, id uint, now time.Time) (*models.StockCount, error) {
+	count, err := lockStockCount(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusOpen {
+		return nil, ErrCountState
+	}
+	for _, line := range count.Lines {
+		if line.CountedQuantity == nil || line.NeedsRecount {
+			return nil, ErrCountIncomplete
+		}
+	}
+
+	if err := valueCountLines(tx, count); err != nil {
+		return nil, err
+	}
+	count.Status = models.StockCountStatusSubmitted
+	count.SubmittedAt = &now
+	if err := tx.Model(count).Updates(map[string]interface{}{"status": count.Status, "submitted_at": now}).Error; err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// RequestRecount sends a submitted session back for the given lines to be
+// counted again
+func RequestRecount(tx *gorm.DB, id uint, lineIDs []uint) (*models.StockCount, error) {
+	count, err := lockStockCount(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusSubmitted {
+		return nil, ErrCountState
+	}
+
+	lines := make(map[uint]*models.StockCountLine, len(count.Lines))
+	for i := range count.Lines {
+		lines[count.Lines[i].ID] = &count.Lines[i]
+	}
+	for _, lineID := range lineIDs {
+		line, ok := lines[lineID]
+		if !ok {
+			return nil, ErrUnknownCountLine
+		}
+		line.NeedsRecount = true
+		if err := tx.Model(line).Update("needs_recount", true).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	count.Status = models.StockCountStatusOpen
+	count.SubmittedAt = nil
+	if err := tx.Model(count).Updates(map[string]interface{}{"status": count.Status, "submitted_at": nil}).Error; err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// ApproveStockCount posts each variance as an adjustment of the inventory
+// item. Reasons are per line and default to a count correction.
+func ApproveStockCount(tx *gorm.DB, id uint, reasons map[uint]models.AdjustmentReason, userID *uint, now time.Time) (*models.StockCount, error) {
+	count, err := lockStockCount(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusSubmitted {
+		return nil, ErrCountState
+	}
+	if err := valueCountLines(tx, count); err != nil {
+		return nil, err
+	}
+
+	lines := make([]*models.StockCountLine, 0, len(count.Lines))
+	for i := range count.Lines {
+		lines = append(lines, &count.Lines[i])
+	}
+	// Adjust in inventory item order, as everywhere else
+	sort.Slice(lines, func(i, j int) bool { return lines[i].InventoryItemID < lines[j].InventoryItemID })
+
+	for _, line := range lines {
+		if line.Variance == nil || *line.Variance == 0 {
+			continue
+		}
+		reason, ok := reasons[line.ID]
+		if !ok {
+			reason = models.AdjustmentReasonCountCorrection
+		}
+		_, adjustment, err := AdjustStock(tx, line.InventoryItemID, StockAdjustment{
+			QuantityChange: *line.Variance,
+			Reason:         reason,
+			Note:           fmt.Sprintf("Stock count %d", count.ID),
+			AdjustedByID:   userID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.ID, err)
+		}
+		line.Reason = reason
+		line.AdjustmentID = &adjustment.ID
+		if err := tx.Model(line).Updates(map[string]interface{}{"reason": reason, "adjustment_id": adjustment.ID}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	count.Status = models.StockCountStatusApproved
+	count.ApprovedByID = userID
+	count.ApprovedAt = &now
+	if err := tx.Model(count).Updates(map[string]interface{}{
+		"status":         count.Status,
+		"approved_by_id": userID,
+		"approved_at":    now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// CancelStockCount abandons a session that has not been approved
+func CancelStockCount(tx *gorm.DB, id uint) (*models.StockCount, error) {
+	count, err := lockStockCount(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusOpen && count.Status != models.StockCountStatusSubmitted {
+		return nil, ErrCountState
+	}
+	count.Status = models.StockCountStatusCancelled
+	if err := tx.Model(count).Update("status", count.Status).Error; err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// lockStockCount loads a session and its lines, locking the session
+func lockStockCount(tx *gorm.DB, id uint) (*models.StockCount, error) {
+	var count models.StockCount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&count, id).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("count_id = ?", count.ID).Order("id").Find(&count.Lines).Error; err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// valueCountLines prices each variance at the product's current cost
+func valueCountLines(tx *gorm.DB, count *models.StockCount) error {
+	productIDs := make([]uint, 0, len(count.Lines))
+	for _, line := range count.Lines {
+		productIDs = append(productIDs, line.ProductID)
+	}
+	var products []models.Product
+	if err := tx.Unscoped().Select("id", "cost_price").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return err
+	}
+	costs := make(map[uint]float64, len(products))
+	for _, product := range products {
+		costs[product.ID] = product.CostPrice
+	}
+
+	for i := range count.Lines {
+		line := &count.Lines[i]
+		var variance int
+		if line.Variance != nil {
+			variance = *line.Variance
+		}
+		line.UnitCost = costs[line.ProductID]
+		line.VarianceValue = RoundMoney(line.UnitCost * float64(variance))
+		if err := tx.Model(line).Updates(map[string]interface{}{
+			"unit_cost":      line.UnitCost,
+			"variance_value": line.VarianceValue,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VarianceSummary totals count variances and their value at cost
+type VarianceSummary struct {
+	Lines             int     `json:"lines"`
+	LinesWithVariance int     `json:"lines_with_variance"`
+	UnitsGained       int     `json:"units_gained"`
+	UnitsLost         int     `json:"units_lost"`
+	ValueGained       float64 `json:"value_gained"`
+	ValueLost         float64 `json:"value_lost"`
+	NetValue          float64 `json:"net_value"`
+	// Accuracy is the percentage of lines counted without variance
+	Accuracy float64 `json:"accuracy"`
+}
+
+func (s *VarianceSummary) add(line *models.StockCountLine) {
+	s.Lines++
+	if line.Variance == nil || *line.Variance == 0 {
+		return
+	}
+	s.LinesWithVariance++
+	if *line.Variance > 0 {
+		s.UnitsGained += *line.Variance
+		s.ValueGained += line.VarianceValue
+	} else {
+		s.UnitsLost -= *line.Variance
+		s.ValueLost -= line.VarianceValue
+	}
+}
+
+func (s *VarianceSummary) finish() {
+	s.ValueGained = RoundMoney(s.ValueGained)
+	s.ValueLost = RoundMoney(s.ValueLost)
+	s.NetValue = RoundMoney(s.ValueGained - s.ValueLost)
+	if s.Lines > 0 {
+		s.Accuracy = math.Round(float64(s.Lines-s.LinesWithVariance)*10000/float64(s.Lines)) / 100
+	}
+}
+
+type StockCountReport struct {
+	CountID uint                        `json:"count_id"`
+	Status  models.StockCountStatus     `json:"status"`
+	Total   VarianceSummary             `json:"total"`
+	ByClass map[string]*VarianceSummary `json:"by_abc_class"`
+	// Variances are the lines off the book, largest value first
+	Variances []models.StockCountLine `json:"variances"`
+}
+
+// StockCountVariance reports a submitted or approved session's variances
+// and their valuation impact. Open sessions are blind and not reported.
+func StockCountVariance(db *gorm.DB, id uint) (*StockCountReport, error) {
+	var count models.StockCount
+	if err := db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Lines.Product").
+		First(&count, id).Error; err != nil {
+		return nil, err
+	}
+	if count.Status != models.StockCountStatusSubmitted && count.Status != models.StockCountStatusApproved {
+		return nil, ErrCountState
+	}
+
+	report := &StockCountReport{
+		CountID:   count.ID,
+		Status:    count.Status,
+		ByClass:   make(map[string]*VarianceSummary),
+		Variances: []models.StockCountLine{},
+	}
+	for i := range count.Lines {
+		line := &count.Lines[i]
+		report.Total.add(line)
+		class := line.Product.ABCClass
+		if class == "" {
+			class = "unclassified"
+		}
+		if report.ByClass[class] == nil {
+			report.ByClass[class] = &VarianceSummary{}
+		}
+		report.ByClass[class].add(line)
+		if line.Variance != nil && *line.Variance != 0 {
+			report.Variances = append(report.Variances, *line)
+		}
+	}
+	report.Total.finish()
+	for _, summary := range report.ByClass {
+		summary.finish()
+	}
+	sort.SliceStable(report.Variances, func(i, j int) bool {
+		return math.Abs(report.Variances[i].VarianceValue) > math.Abs(report.Variances[j].VarianceValue)
+	})
+	return report, nil
+}
+
+// CountImpact is the valuation impact of approved counts in one warehouse
+type CountImpact struct {
+	WarehouseID uint    `json:"warehouse_id"`
+	Counts      int     `json:"counts"`
+	Lines       int     `json:"lines"`
+	UnitsGained int     `json:"units_gained"`
+	UnitsLost   int     `json:"units_lost"`
+	ValueGained float64 `json:"value_gained"`
+	ValueLost   float64 `json:"value_lost"`
+	NetValue    float64 `json:"net_value"`
+}
+
+// StockCountImpact totals the adjustments posted by counts approved between
+// from and to, by warehouse
+func StockCountImpact(db *gorm.DB, from, to time.Time, warehouseID *uint) ([]CountImpact, error) {
+	query := db.Model(&models.StockCountLine{}).
+		Select(`stock_counts.warehouse_id,
+			COUNT(DISTINCT stock_counts.id) AS counts,
+			COUNT(*) AS lines,
+			COALESCE(SUM(GREATEST(stock_count_lines.variance, 0)), 0) AS units_gained,
+			COALESCE(SUM(GREATEST(-stock_count_lines.variance, 0)), 0) AS units_lost,
+			COALESCE(SUM(GREATEST(stock_count_lines.variance_value, 0)), 0) AS value_gained,
+			COALESCE(SUM(GREATEST(-stock_count_lines.variance_value, 0)), 0) AS value_lost,
+			COALESCE(SUM(stock_count_lines.variance_value), 0) AS net_value`).
+		Joins("JOIN stock_counts ON stock_counts.id = stock_count_lines.count_id AND stock_counts.deleted_at IS NULL").
+		Where("stock_counts.status = ? AND stock_counts.approved_at BETWEEN ? AND ?", models.StockCountStatusApproved, from, to).
+		Group("stock_counts.warehouse_id").
+		Order("stock_counts.warehouse_id")
+	if warehouseID != nil {
+		query = query.Where("stock_counts.warehouse_id = ?", *warehouseID)
+	}
+
+	impacts := []CountImpact{}
+	if err := query.Scan(&impacts).Error; err != nil {
+		return nil, err
+	}
+	for i := range impacts {
+		impacts[i].ValueGained = RoundMoney(impacts[i].ValueGained)
+		impacts[i].ValueLost = RoundMoney(impacts[i].ValueLost)
+		impacts[i].NetValue = RoundMoney(impacts[i].NetValue)
+	}
+	return impacts, nil
+}
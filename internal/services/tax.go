@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reverseChargeCountries are the EU member states and the UK (XI is
+// Northern Ireland). Business buyers in one of them account for the tax on
+// supplies from another themselves.
+var reverseChargeCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true,
+	"DK": true, "EE": true, "ES": true, "FI": true, "FR": true, "GR": true,
+	"HR": true, "HU": true, "IE": true, "IT": true, "LT": true, "LU": true,
+	"LV": true, "MT": true, "NL": true, "PL": true, "PT": true, "RO": true,
+	"SE": true, "SI": true, "SK": true, "GB": true, "XI": true,
+}
+
+// TaxContext is where a sale is supplied and who buys it
+type TaxContext struct {
+	Country       string
+	Region        string
+	OriginCountry string
+
+	// BusinessBuyer is a verified buyer registered for VAT
+	BusinessBuyer    bool
+	PricesIncludeTax bool
+	At               time.Time
+}
+
+// ReverseCharge reports whether the buyer accounts for the tax instead of
+// being charged it
+func (ctx TaxContext) ReverseCharge() bool {
+	return ctx.BusinessBuyer && ctx.Country != ctx.OriginCountry &&
+		reverseChargeCountries[ctx.Country] && reverseChargeCountries[ctx.OriginCountry]
+}
+
+// TaxCalculator taxes the lines of one document, looking each class's rate
+// up once
+type TaxCalculator struct {
+	db  *gorm.DB
+	ctx TaxContext
+
+	defaultClass  *uint
+	defaultLoaded bool
+	rates         map[uint]*models.TaxRate
+}
+
+func NewTaxCalculator(db *gorm.DB, ctx TaxContext) *TaxCalculator {
+	ctx.Country = strings.ToUpper(strings.TrimSpace(ctx.Country))
+	ctx.OriginCountry = strings.ToUpper(strings.TrimSpace(ctx.OriginCountry))
+	ctx.Region = strings.TrimSpace(ctx.Region)
+	return &TaxCalculator{db: db, ctx: ctx, rates: make(map[uint]*models.TaxRate)}
+}
+
+// Line taxes a line of amount, which includes tax when the context's prices
+// do. Reverse-charged lines priced with tax are charged net.
+func (c *TaxCalculator) Line(taxClassID *uint, amount float64) (models.LineTax, error) {
+	if taxClassID == nil {
+		var err error
+		if taxClassID, err = c.defaultClassID(); err != nil {
+			return models.LineTax{}, err
+		}
+	}
+	line := models.LineTax{
+		TaxClassID:   taxClassID,
+		TaxTreatment: models.TaxTreatmentOutOfScope,
+		NetAmount:    RoundMoney(amount),
+	}
+	if taxClassID == nil {
+		return line, nil
+	}
+	rate, err := c.rate(*taxClassID)
+	if err != nil || rate == nil {
+		return line, err
+	}
+
+	line.TaxTreatment = rate.Treatment
+	var nominal float64
+	if rate.Treatment == models.TaxTreatmentStandard {
+		nominal = rate.Rate
+	}
+	charged := nominal
+	if nominal > 0 && c.ctx.ReverseCharge() {
+		line.TaxTreatment = models.TaxTreatmentReverseCharge
+		charged = 0
+	}
+
+	if c.ctx.PricesIncludeTax {
+		line.NetAmount = RoundMoney(amount / (1 + nominal/100))
+	}
+	line.TaxRate = charged
+	switch {
+	case charged == 0:
+	case c.ctx.PricesIncludeTax:
+		// Whatever the net rounds to, net and tax add up to the price
+		line.TaxAmount = RoundMoney(amount - line.NetAmount)
+	default:
+		line.TaxAmount = RoundMoney(line.NetAmount * charged / 100)
+	}
+	return line, nil
+}
+
+func (c *TaxCalculator) defaultClassID() (*uint, error) {
+	if c.defaultLoaded {
+		return c.defaultClass, nil
+	}
+	var class models.TaxClass
+	err := c.db.Select("id").Where("is_default").Order("id").First(&class).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		c.defaultClass = &class.ID
+	}
+	c.defaultLoaded = true
+	return c.defaultClass, nil
+}
+
+// rate finds the class's rate in force for the destination, preferring
+// one for its region. nil means none is configured.
+func (c *TaxCalculator) rate(taxClassID uint) (*models.TaxRate, error) {
+	if rate, ok := c.rates[taxClassID]; ok {
+		return rate, nil
+	}
+	var rates []models.TaxRate
+	if err := c.db.Where("tax_class_id = ? AND country = ?", taxClassID, c.ctx.Country).
+		Where("COALESCE(region, '') = '' OR LOWER(region) = LOWER(?)", c.ctx.Region).
+		Where("effective_from <= ? AND (effective_to IS NULL OR effective_to > ?)", c.ctx.At, c.ctx.At).
+		Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	rate := selectTaxRate(rates, c.ctx.Region, c.ctx.At)
+	c.rates[taxClassID] = rate
+	return rate, nil
+}
+
+// selectTaxRate picks the rate in force at a point in time: from its
+// EffectiveFrom up to but excluding its EffectiveTo. A rate for the region
+// beats a country-wide one, then the latest to take effect wins.
+func selectTaxRate(rates []models.TaxRate, region string, at time.Time) *models.TaxRate {
+	var best *models.TaxRate
+	for i := range rates {
+		rate := &rates[i]
+		if rate.Region != "" && !strings.EqualFold(rate.Region, region) {
+			continue
+		}
+		if rate.EffectiveFrom.After(at) || (rate.EffectiveTo != nil && !rate.EffectiveTo.After(at)) {
+			continue
+		}
+		if best == nil || betterTaxRate(rate, best) {
+			best = rate
+		}
+	}
+	return best
+}
+
+func betterTaxRate(rate, than *models.TaxRate) bool {
+	if (rate.Region != "") != (than.Region != "") {
+		return rate.Region != ""
+	}
+	if !rate.EffectiveFrom.Equal(than.EffectiveFrom) {
+		return rate.EffectiveFrom.After(than.EffectiveFrom)
+	}
+	return rate.ID > than.ID
+}
+
+// OrderTaxContext taxes a sale at the shipping address, to a company when
+// companyID is set
+func OrderTaxContext(db *gorm.DB, companyID *uint, address *models.Address, at time.Time) (TaxContext, error) {
+	ctx := TaxContext{
+		Country:          address.Country,
+		Region:           address.State,
+		OriginCountry:    settings.TaxOriginCountry,
+		PricesIncludeTax: settings.PricesIncludeTax,
+		At:               at,
+	}
+	if companyID == nil {
+		return ctx, nil
+	}
+	var company models.Company
+	if err := db.Select("id", "vat_number", "is_verified").First(&company, *companyID).Error; err != nil {
+		return ctx, err
+	}
+	ctx.BusinessBuyer = verifiedBusiness(&company)
+	return ctx, nil
+}
+
+// ContractTaxContext taxes supplies to a company at its address
+func ContractTaxContext(db *gorm.DB, companyID uint, at time.Time) (TaxContext, error) {
+	ctx := TaxContext{OriginCountry: settings.TaxOriginCountry, At: at}
+	var company models.Company
+	if err := db.First(&company, companyID).Error; err != nil {
+		return ctx, err
+	}
+	ctx.BusinessBuyer = verifiedBusiness(&company)
+
+	var address models.Address
+	err := db.First(&address, company.AddressID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Without an address the supply is taken to be domestic
+		ctx.Country = ctx.OriginCountry
+		return ctx, nil
+	}
+	if err != nil {
+		return ctx, err
+	}
+	ctx.Country, ctx.Region = address.Country, address.State
+	return ctx, nil
+}
+
+// PurchaseTaxContext taxes goods bought from a supplier for a warehouse.
+// The store buys as a VAT-registered business, so cross-border purchases
+// within the EU and UK are reverse charged.
+func PurchaseTaxContext(db *gorm.DB, supplierID uint, warehouseID *uint, at time.Time) (TaxContext, error) {
+	ctx := TaxContext{Country: settings.TaxOriginCountry, BusinessBuyer: true, At: at}
+	if warehouseID != nil {
+		var warehouse models.Warehouse
+		if err := db.Preload("Address").First(&warehouse, *warehouseID).Error; err != nil {
+			return ctx, err
+		}
+		if warehouse.Address.Country != "" {
+			ctx.Country, ctx.Region = warehouse.Address.Country, warehouse.Address.State
+		}
+	}
+
+	ctx.OriginCountry = ctx.Country
+	var supplier models.Supplier
+	if err := db.Preload("Address").First(&supplier, supplierID).Error; err != nil {
+		return ctx, err
+	}
+	if supplier.Address.Country != "" {
+		ctx.OriginCountry = supplier.Address.Country
+	}
+	return ctx, nil
+}
+
+// verifiedBusiness reports whether a company can buy without being
+// charged tax on cross-border supplies
+func verifiedBusiness(company *models.Company) bool {
+	return company.IsVerified && strings.TrimSpace(company.VATNumber) != ""
+}
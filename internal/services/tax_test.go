@@ -0,0 +1,166 @@
+package services
+
+import (
+	"marketprogo/internal/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestTaxCalculatorLine(t *testing.T) {
+	standard, zeroRated, unconfigured := uint(1), uint(2), uint(3)
+	rates := map[uint]*models.TaxRate{
+		standard:     {Rate: 20, Treatment: models.TaxTreatmentStandard},
+		zeroRated:    {Rate: 0, Treatment: models.TaxTreatmentZeroRated},
+		unconfigured: nil,
+	}
+
+	domestic := TaxContext{Country: "FR", OriginCountry: "FR"}
+	crossBorder := TaxContext{Country: "de", OriginCountry: "FR"}
+	crossBorderBusiness := TaxContext{Country: "DE", OriginCountry: "FR", BusinessBuyer: true}
+
+	tests := []struct {
+		name        string
+		ctx         TaxContext
+		includeTax  bool
+		class       *uint
+		amount      float64
+		wantTax     models.LineTax
+		wantReverse bool
+	}{
+		{
+			name:    "standard rate on net price",
+			ctx:     domestic,
+			class:   &standard,
+			amount:  100,
+			wantTax: models.LineTax{TaxTreatment: models.TaxTreatmentStandard, TaxRate: 20, NetAmount: 100, TaxAmount: 20},
+		},
+		{
+			name:       "standard rate on price including tax",
+			ctx:        domestic,
+			includeTax: true,
+			class:      &standard,
+			amount:     10,
+			wantTax:    models.LineTax{TaxTreatment: models.TaxTreatmentStandard, TaxRate: 20, NetAmount: 8.33, TaxAmount: 1.67},
+		},
+		{
+			name:    "consumer across a border is charged",
+			ctx:     crossBorder,
+			class:   &standard,
+			amount:  100,
+			wantTax: models.LineTax{TaxTreatment: models.TaxTreatmentStandard, TaxRate: 20, NetAmount: 100, TaxAmount: 20},
+		},
+		{
+			name:    "business at home is charged",
+			ctx:     TaxContext{Country: "FR", OriginCountry: "FR", BusinessBuyer: true},
+			class:   &standard,
+			amount:  100,
+			wantTax: models.LineTax{TaxTreatment: models.TaxTreatmentStandard, TaxRate: 20, NetAmount: 100, TaxAmount: 20},
+		},
+		{
+			name:        "business across a border is reverse charged",
+			ctx:         crossBorderBusiness,
+			class:       &standard,
+			amount:      100,
+			wantTax:     models.LineTax{TaxTreatment: models.TaxTreatmentReverseCharge, TaxRate: 0, NetAmount: 100, TaxAmount: 0},
+			wantReverse: true,
+		},
+		{
+			name:        "reverse charge on price including tax is charged net",
+			ctx:         crossBorderBusiness,
+			includeTax:  true,
+			class:       &standard,
+			amount:      120,
+			wantTax:     models.LineTax{TaxTreatment: models.TaxTreatmentReverseCharge, TaxRate: 0, NetAmount: 100, TaxAmount: 0},
+			wantReverse: true,
+		},
+		{
+			name:        "zero-rated stays zero-rated for a business",
+			ctx:         crossBorderBusiness,
+			class:       &zeroRated,
+			amount:      100,
+			wantTax:     models.LineTax{TaxTreatment: models.TaxTreatmentZeroRated, TaxRate: 0, NetAmount: 100, TaxAmount: 0},
+			wantReverse: true,
+		},
+		{
+			name:    "class without a rate is out of scope",
+			ctx:     domestic,
+			class:   &unconfigured,
+			amount:  100,
+			wantTax: models.LineTax{TaxTreatment: models.TaxTreatmentOutOfScope, NetAmount: 100},
+		},
+		{
+			name:    "no class and no default is out of scope",
+			ctx:     domestic,
+			amount:  99.999,
+			wantTax: models.LineTax{TaxTreatment: models.TaxTreatmentOutOfScope, NetAmount: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			ctx.PricesIncludeTax = tt.includeTax
+			calculator := NewTaxCalculator(nil, ctx)
+			calculator.defaultLoaded = true
+			for id, rate := range rates {
+				calculator.rates[id] = rate
+			}
+
+			if got := calculator.ctx.ReverseCharge(); got != tt.wantReverse {
+				t.Errorf("ReverseCharge() = %v, want %v", got, tt.wantReverse)
+			}
+			line, err := calculator.Line(tt.class, tt.amount)
+			if err != nil {
+				t.Fatalf("Line: %v", err)
+			}
+			tt.wantTax.TaxClassID = tt.class
+			if line != tt.wantTax {
+				t.Errorf("Line(%v) = %+v, want %+v", tt.amount, line, tt.wantTax)
+			}
+		})
+	}
+}
+
+func TestSelectTaxRate(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	change := date(2024, time.July, 1)
+	rates := []models.TaxRate{
+		{Model: gorm.Model{ID: 1}, Rate: 19, EffectiveFrom: date(2020, time.January, 1), EffectiveTo: &change},
+		{Model: gorm.Model{ID: 2}, Rate: 16, EffectiveFrom: change},
+		{Model: gorm.Model{ID: 3}, Region: "Bavaria", Rate: 7, EffectiveFrom: date(2024, time.January, 1)},
+		{Model: gorm.Model{ID: 4}, Region: "Saxony", Rate: 5, EffectiveFrom: date(2024, time.January, 1)},
+	}
+
+	tests := []struct {
+		name   string
+		region string
+		at     time.Time
+		wantID uint // 0 for none
+	}{
+		{name: "before any rate", at: date(2019, time.December, 31)},
+		{name: "first day in force", at: date(2020, time.January, 1), wantID: 1},
+		{name: "last moment before the change", at: change.Add(-time.Nanosecond), wantID: 1},
+		{name: "change takes effect at EffectiveTo", at: change, wantID: 2},
+		{name: "open-ended rate stays in force", at: date(2030, time.January, 1), wantID: 2},
+		{name: "regional rate beats the country's", region: "bavaria", at: change, wantID: 3},
+		{name: "other regions' rates are ignored", region: "Hesse", at: change, wantID: 2},
+		{name: "country rate before the regional one starts", region: "Bavaria", at: date(2023, time.June, 1), wantID: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := selectTaxRate(rates, tt.region, tt.at)
+			var got uint
+			if rate != nil {
+				got = rate.ID
+			}
+			if got != tt.wantID {
+				t.Errorf("selectTaxRate(%q, %s) = rate %d, want %d", tt.region, tt.at, got, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"marketprogo/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSameWarehouse        = errors.New("source and destination warehouse are the same")
+	ErrWarehouseNotFound    = errors.New("warehouse not found")
+	ErrInvalidTransferBatch = errors.New("inventory item is not stock of the product in the source warehouse")
+	ErrTransferState        = errors.New("transfer is not in a state that allows this")
+	ErrUnknownTransferLine  = errors.New("line does not belong to the transfer")
+	ErrOverReceipt          = errors.New("more received than is in transit")
+)
+
+// openTransferStatuses are transfers whose stock has not settled
+var openTransferStatuses = []models.TransferStatus{
+	models.TransferStatusDraft,
+	models.TransferStatusInTransit,
+	models.TransferStatusPartiallyReceived,
+}
+
+// TransferLineInput asks for a quantity of a product, optionally from a
+// specific batch
+type TransferLineInput struct {
+	ProductID       uint
+	Quantity        int
+	InventoryItemID *uint
+}
+
+type TransferInput struct {
+	SourceWarehouseID      uint
+	DestinationWarehouseID uint
+	Notes                  string
+	ExpectedDate           *time.Time
+	Lines                  []TransferLineInput
+	UserID                 *uint
+}
+
+// CreateTransfer writes a draft transfer. No stock moves until dispatch.
+func CreateTransfer(tx *gorm.DB, input TransferInput) (*models.StockTransfer, error) {
+	if input.SourceWarehouseID == input.DestinationWarehouseID {
+		return nil, ErrSameWarehouse
+	}
+	var count int64
+	if err := tx.Model(&models.Warehouse{}).
+		Where("id IN ?", []uint{input.SourceWarehouseID, input.DestinationWarehouseID}).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count != 2 {
+		return nil, ErrWarehouseNotFound
+	}
+
+	transfer := models.StockTransfer{
+		SourceWarehouseID:      input.SourceWarehouseID,
+		DestinationWarehouseID: input.DestinationWarehouseID,
+		Status:                 models.TransferStatusDraft,
+		Notes:                  input.Notes,
+		ExpectedDate:           input.ExpectedDate,
+	}
+	for _, lineInput := range input.Lines {
+		var product models.Product
+		if err := tx.First(&product, lineInput.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %d", ErrProductNotFound, lineInput.ProductID)
+			}
+			return nil, err
+		}
+		if product.IsBundle {
+			return nil, fmt.Errorf("%w: bundles are stocked as their components", ErrInvalidTransferBatch)
+		}
+
+		line := models.StockTransferLine{ProductID: product.ID, Quantity: lineInput.Quantity}
+		if lineInput.InventoryItemID != nil {
+			var item models.InventoryItem
+			if err := tx.Where("product_id = ? AND warehouse_id = ?", product.ID, input.SourceWarehouseID).
+				First(&item, *lineInput.InventoryItemID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, ErrInvalidTransferBatch
+				}
+				return nil, err
+			}
+			line.SourceInventoryItemID = &item.ID
+			line.BatchNumber = item.BatchNumber
+			line.ExpiryDate = item.ExpiryDate
+		}
+		transfer.Lines = append(transfer.Lines, line)
+	}
+
+	if err := tx.Create(&transfer).Error; err != nil {
+		return nil, err
+	}
+	if err := recordTransferStatus(tx, &transfer, "", input.UserID, ""); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// transferPick is stock taken from one source batch for a line
+type transferPick struct {
+	line     *models.StockTransferLine
+	item     models.InventoryItem
+	quantity int
+}
+
+// DispatchTransfer takes the transfer's stock out of the source warehouse
+// and puts it in transit. Lines without a batch are filled first expired,
+// first out and split per batch. Only free stock can be dispatched.
+func DispatchTransfer(tx *gorm.DB, id uint, userID *uint, now time.Time) (*models.StockTransfer, error) {
+	transfer, err := lockTransfer(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != models.TransferStatusDraft {
+		return nil, ErrTransferState
+	}
+
+	var productIDs []uint
+	for _, line := range transfer.Lines {
+		if line.SourceInventoryItemID == nil {
+			productIDs = append(productIDs, line.ProductID)
+		}
+	}
+	pool := &stockPool{}
+	if len(productIDs) > 0 {
+		if pool, err = loadStockPool(tx, productIDs, AllocationOptions{Now: now, Lock: true}); err != nil {
+			return nil, err
+		}
+	}
+
+	var picks []transferPick
+	var split []*models.StockTransferLine
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		if line.SourceInventoryItemID != nil {
+			var item models.InventoryItem
+			if err := tx.First(&item, *line.SourceInventoryItemID).Error; err != nil {
+				return nil, err
+			}
+			picks = append(picks, transferPick{line, item, line.Quantity})
+			continue
+		}
+
+		allocations := pool.take(transfer.SourceWarehouseID, line.ProductID, line.Quantity)
+		var taken int
+		for _, allocation := range allocations {
+			taken += allocation.Quantity
+		}
+		if taken < line.Quantity {
+			return nil, &StockError{ProductID: line.ProductID, Requested: line.Quantity, Available: taken}
+		}
+		for j, allocation := range allocations {
+			var item models.InventoryItem
+			if err := tx.First(&item, allocation.InventoryItemID).Error; err != nil {
+				return nil, err
+			}
+			target := line
+			if j > 0 {
+				target = &models.StockTransferLine{TransferID: transfer.ID, ProductID: line.ProductID}
+				split = append(split, target)
+			}
+			target.Quantity = allocation.Quantity
+			picks = append(picks, transferPick{target, item, allocation.Quantity})
+		}
+	}
+
+	// Take stock in inventory item order, as everywhere else
+	sort.SliceStable(picks, func(i, j int) bool { return picks[i].item.ID < picks[j].item.ID })
+	ref := MovementRef{Type: ReferenceTypeTransfer, ID: transfer.ID, UserID: userID}
+	for i := range picks {
+		pick := &picks[i]
+		result := tx.Model(&models.InventoryItem{}).
+			Where("id = ? AND status = ? AND quantity - reserved >= ?", pick.item.ID, models.InventoryStatusActive, pick.quantity).
+			Update("quantity", gorm.Expr("quantity - ?", pick.quantity))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, &StockError{ProductID: pick.item.ProductID, Requested: pick.quantity, Available: pick.item.Quantity - pick.item.Reserved}
+		}
+		pick.item.Quantity -= pick.quantity
+		if _, err := recordMovement(tx, &pick.item, models.MovementTypeTransfer, -pick.quantity, 0, ref); err != nil {
+			return nil, err
+		}
+
+		pick.line.SourceInventoryItemID = &pick.item.ID
+		pick.line.BatchNumber = pick.item.BatchNumber
+		pick.line.ExpiryDate = pick.item.ExpiryDate
+		pick.line.DispatchedQuantity = pick.quantity
+	}
+
+	for i := range transfer.Lines {
+		if err := tx.Omit(clause.Associations).Save(&transfer.Lines[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, line := range split {
+		if err := tx.Omit(clause.Associations).Create(line).Error; err != nil {
+			return nil, err
+		}
+		transfer.Lines = append(transfer.Lines, *line)
+	}
+
+	transfer.DispatchedAt = &now
+	if err := tx.Model(transfer).Update("dispatched_at", now).Error; err != nil {
+		return nil, err
+	}
+	if err := setTransferStatus(tx, transfer, models.TransferStatusInTransit, userID, ""); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// TransferReceipt is what arrived for one line: Quantity goes into stock,
+// Damaged is written off as a discrepancy
+type TransferReceipt struct {
+	LineID   uint
+	Quantity int
+	Damaged  int
+	Note     string
+}
+
+// ReceiveTransfer books arrived stock into the destination warehouse under
+// the same batch and expiry. Receipts may come in several parts; finish
+// records whatever is still in transit as short and closes the transfer.
+func ReceiveTransfer(tx *gorm.DB, id uint, receipts []TransferReceipt, finish bool, userID *uint, now time.Time) (*models.StockTransfer, error) {
+	transfer, err := lockTransfer(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != models.TransferStatusInTransit && transfer.Status != models.TransferStatusPartiallyReceived {
+		return nil, ErrTransferState
+	}
+
+	lines := make(map[uint]*models.StockTransferLine, len(transfer.Lines))
+	for i := range transfer.Lines {
+		lines[transfer.Lines[i].ID] = &transfer.Lines[i]
+	}
+
+	ref := MovementRef{Type: ReferenceTypeTransfer, ID: transfer.ID, UserID: userID}
+	for _, receipt := range receipts {
+		line, ok := lines[receipt.LineID]
+		if !ok {
+			return nil, ErrUnknownTransferLine
+		}
+		if receipt.Quantity < 0 || receipt.Damaged < 0 || receipt.Quantity+receipt.Damaged > line.InTransit() {
+			return nil, ErrOverReceipt
+		}
+
+		if receipt.Quantity > 0 {
+			item, err := destinationBatch(tx, transfer.DestinationWarehouseID, line)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := moveStock(tx, item, models.MovementTypeTransfer, receipt.Quantity, 0, ref); err != nil {
+				return nil, err
+			}
+			line.DestinationInventoryItemID = &item.ID
+			line.ReceivedQuantity += receipt.Quantity
+		}
+		if receipt.Damaged > 0 {
+			if err := recordDiscrepancy(tx, line, receipt.Damaged, models.DiscrepancyReasonDamaged, receipt.Note, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if finish {
+		for i := range transfer.Lines {
+			line := &transfer.Lines[i]
+			if missing := line.InTransit(); missing > 0 {
+				if err := recordDiscrepancy(tx, line, missing, models.DiscrepancyReasonShort, "Not received", userID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	complete := true
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		if err := tx.Model(line).Updates(map[string]interface{}{
+			"received_quantity":             line.ReceivedQuantity,
+			"discrepancy_quantity":          line.DiscrepancyQuantity,
+			"destination_inventory_item_id": line.DestinationInventoryItemID,
+		}).Error; err != nil {
+			return nil, err
+		}
+		if line.InTransit() > 0 {
+			complete = false
+		}
+	}
+
+	status := models.TransferStatusPartiallyReceived
+	if complete {
+		status = models.TransferStatusReceived
+		transfer.ReceivedAt = &now
+		if err := tx.Model(transfer).Update("received_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	if status != transfer.Status {
+		if err := setTransferStatus(tx, transfer, status, userID, ""); err != nil {
+			return nil, err
+		}
+	}
+	return transfer, nil
+}
+
+// CancelTransfer cancels a transfer that has not been dispatched
+func CancelTransfer(tx *gorm.DB, id uint, userID *uint, note string) (*models.StockTransfer, error) {
+	transfer, err := lockTransfer(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != models.TransferStatusDraft {
+		return nil, ErrTransferState
+	}
+	if err := setTransferStatus(tx, transfer, models.TransferStatusCancelled, userID, note); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// lockTransfer loads a transfer and its lines, locking the transfer
+func lockTransfer(tx *gorm.DB, id uint) (*models.StockTransfer, error) {
+	var transfer models.StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, id).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("transfer_id = ?", transfer.ID).Order("id").Find(&transfer.Lines).Error; err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// destinationBatch finds or creates the destination inventory item for a
+// line's batch, locked for update
+func destinationBatch(tx *gorm.DB, warehouseID uint, line *models.StockTransferLine) (*models.InventoryItem, error) {
+	item := models.InventoryItem{
+		ProductID:   line.ProductID,
+		WarehouseID: warehouseID,
+		BatchNumber: line.BatchNumber,
+		ExpiryDate:  line.ExpiryDate,
+		Status:      models.InventoryStatusActive,
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND warehouse_id = ? AND batch_number = ? AND expiry_date = ? AND status = ?",
+			item.ProductID, item.WarehouseID, item.BatchNumber, item.ExpiryDate, item.Status).
+		Order("id").
+		First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &item, tx.Create(&item).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func recordDiscrepancy(tx *gorm.DB, line *models.StockTransferLine, quantity int, reason models.DiscrepancyReason, note string, userID *uint) error {
+	discrepancy := models.StockTransferDiscrepancy{
+		LineID:       line.ID,
+		Quantity:     quantity,
+		Reason:       reason,
+		Note:         note,
+		RecordedByID: userID,
+	}
+	if err := tx.Create(&discrepancy).Error; err != nil {
+		return err
+	}
+	line.DiscrepancyQuantity += quantity
+	line.Discrepancies = append(line.Discrepancies, discrepancy)
+	return nil
+}
+
+func setTransferStatus(tx *gorm.DB, transfer *models.StockTransfer, status models.TransferStatus, userID *uint, note string) error {
+	from := transfer.Status
+	if err := tx.Model(transfer).Update("status", status).Error; err != nil {
+		return err
+	}
+	transfer.Status = status
+	return recordTransferStatus(tx, transfer, from, userID, note)
+}
+
+func recordTransferStatus(tx *gorm.DB, transfer *models.StockTransfer, from models.TransferStatus, userID *uint, note string) error {
+	change := models.StockTransferStatusChange{
+		TransferID:  transfer.ID,
+		FromStatus:  from,
+		ToStatus:    transfer.Status,
+		ChangedByID: userID,
+		Note:        note,
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return err
+	}
+	transfer.History = append(transfer.History, change)
+	return nil
+}
+
+// InTransitLine is stock dispatched on a transfer and not yet received
+type InTransitLine struct {
+	TransferID             uint      `json:"transfer_id"`
+	LineID                 uint      `json:"line_id"`
+	ProductID              uint      `json:"product_id"`
+	SourceWarehouseID      uint      `json:"source_warehouse_id"`
+	DestinationWarehouseID uint      `json:"destination_warehouse_id"`
+	BatchNumber            string    `json:"batch_number"`
+	ExpiryDate             time.Time `json:"expiry_date"`
+	Quantity               int       `json:"quantity"`
+	DispatchedAt           time.Time `json:"dispatched_at"`
+}
+
+// InTransitStock lists stock in transit, optionally for one product or
+// destination warehouse
+func InTransitStock(db *gorm.DB, productID, warehouseID *uint) ([]InTransitLine, error) {
+	query := db.Model(&models.StockTransferLine{}).
+		Select(`stock_transfers.id AS transfer_id, stock_transfer_lines.id AS line_id, stock_transfer_lines.product_id,
+			stock_transfers.source_warehouse_id, stock_transfers.destination_warehouse_id,
+			stock_transfer_lines.batch_number, stock_transfer_lines.expiry_date,
+			stock_transfer_lines.dispatched_quantity - stock_transfer_lines.received_quantity - stock_transfer_lines.discrepancy_quantity AS quantity,
+			stock_transfers.dispatched_at`).
+		Joins("JOIN stock_transfers ON stock_transfers.id = stock_transfer_lines.transfer_id AND stock_transfers.deleted_at IS NULL").
+		Where("stock_transfers.status IN ?", []models.TransferStatus{models.TransferStatusInTransit, models.TransferStatusPartiallyReceived}).
+		Where("stock_transfer_lines.dispatched_quantity > stock_transfer_lines.received_quantity + stock_transfer_lines.discrepancy_quantity").
+		Order("stock_transfers.dispatched_at, stock_transfer_lines.id")
+	if productID != nil {
+		query = query.Where("stock_transfer_lines.product_id = ?", *productID)
+	}
+	if warehouseID != nil {
+		query = query.Where("stock_transfers.destination_warehouse_id = ?", *warehouseID)
+	}
+
+	lines := []InTransitLine{}
+	err := query.Scan(&lines).Error
+	return lines, err
+}
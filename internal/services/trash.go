@@ -0,0 +1,466 @@
+package services
+
+import (
+	"errors"
+	"marketprogo/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type TrashKind string
+
+const (
+	TrashKindProduct   TrashKind = "product"
+	TrashKindCategory  TrashKind = "category"
+	TrashKindSupplier  TrashKind = "supplier"
+	TrashKindWarehouse TrashKind = "warehouse"
+)
+
+type DeletePolicy string
+
+const (
+	// DeletePolicyBlock refuses to delete a record that has dependents
+	DeletePolicyBlock DeletePolicy = "block"
+	// DeletePolicyCascade detaches or deactivates dependents that allow it
+	DeletePolicyCascade DeletePolicy = "cascade"
+)
+
+var (
+	ErrUnknownTrashKind = errors.New("unknown record type")
+	ErrHasDependents    = errors.New("record has dependents")
+	ErrNotInTrash       = errors.New("record is not in the trash")
+	ErrParentInTrash    = errors.New("parent record is in the trash")
+	ErrStillReferenced  = errors.New("record is still referenced")
+)
+
+// Dependent summarises records that point at a record being deleted
+type Dependent struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+	IDs   []uint `json:"ids"`
+	// Blocking dependents prevent deletion under every policy
+	Blocking bool `json:"blocking"`
+}
+
+// dependentRule finds one kind of dependent. Non-blocking rules carry the
+// cascade that detaches their dependents.
+type dependentRule struct {
+	name     string
+	blocking bool
+	query    func(db *gorm.DB, id uint) *gorm.DB
+	cascade  func(tx *gorm.DB, id uint) error
+}
+
+const dependentSampleSize = 20
+
+var (
+	openOrderStatuses    = []models.OrderStatus{models.OrderStatusPending, models.OrderStatusProcessing, models.OrderStatusShipped}
+	closedPOStatuses     = []models.POStatus{models.POStatusReceived, models.POStatusCancelled}
+	liveContractStatuses = []models.ContractStatus{models.ContractStatusDraft, models.ContractStatusActive, models.ContractStatusPaused}
+)
+
+var dependentRules = map[TrashKind][]dependentRule{
+	TrashKindProduct: {
+		{
+			name:     "open_orders",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.Order{}).
+					Where("status IN ?", openOrderStatuses).
+					Where(`id IN (SELECT order_id FROM order_items WHERE product_id = ? AND deleted_at IS NULL)
+						OR id IN (SELECT oi.order_id FROM order_item_components c
+							JOIN order_items oi ON oi.id = c.order_item_id
+							WHERE c.product_id = ? AND c.deleted_at IS NULL)`, id, id)
+			},
+		},
+		{
+			name:     "open_purchase_orders",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.PurchaseOrder{}).
+					Where("status NOT IN ?", closedPOStatuses).
+					Where("id IN (SELECT po_id FROM po_items WHERE product_id = ? AND deleted_at IS NULL)", id)
+			},
+		},
+		{
+			name:     "open_transfers",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.StockTransfer{}).
+					Where("status IN ?", openTransferStatuses).
+					Where("id IN (SELECT transfer_id FROM stock_transfer_lines WHERE product_id = ? AND deleted_at IS NULL)", id)
+			},
+		},
+		{
+			name: "active_contracts",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.Contract{}).
+					Where("status IN ?", liveContractStatuses).
+					Where("id IN (SELECT contract_id FROM contract_items WHERE product_id = ? AND is_active AND deleted_at IS NULL)", id)
+			},
+			cascade: func(tx *gorm.DB, id uint) error {
+				return tx.Model(&models.ContractItem{}).
+					Where("product_id = ? AND is_active", id).
+					Where("contract_id IN (SELECT id FROM contracts WHERE status IN ?)", liveContractStatuses).
+					Update("is_active", false).Error
+			},
+		},
+		{
+			name: "bundles",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.Product{}).
+					Where("is_active AND id IN (SELECT bundle_id FROM bundle_components WHERE component_id = ? AND deleted_at IS NULL)", id)
+			},
+			cascade: func(tx *gorm.DB, id uint) error {
+				return tx.Model(&models.Product{}).
+					Where("id IN (SELECT bundle_id FROM bundle_components WHERE component_id = ? AND deleted_at IS NULL)", id).
+					Update("is_active", false).Error
+			},
+		},
+		{
+			name: "stocked_inventory_items",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.InventoryItem{}).Where("product_id = ? AND quantity > 0", id)
+			},
+			// Stock stays on the books until it is adjusted out
+			cascade: func(tx *gorm.DB, id uint) error { return nil },
+		},
+	},
+	TrashKindCategory: {
+		{
+			name: "child_categories",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.Category{}).Where("parent_id = ?", id)
+			},
+			cascade: func(tx *gorm.DB, id uint) error {
+				// Children move up to the deleted category's parent
+				return tx.Model(&models.Category{}).Where("parent_id = ?", id).
+					Update("parent_id", gorm.Expr("(SELECT parent_id FROM categories WHERE id = ?)", id)).Error
+			},
+		},
+		{
+			name: "products",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.Product{}).
+					Where("id IN (SELECT product_id FROM product_categories WHERE category_id = ?)", id)
+			},
+			cascade: func(tx *gorm.DB, id uint) error {
+				return tx.Exec("DELETE FROM product_categories WHERE category_id = ?", id).Error
+			},
+		},
+	},
+	TrashKindSupplier: {
+		{
+			name:     "open_purchase_orders",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.PurchaseOrder{}).Where("supplier_id = ? AND status NOT IN ?", id, closedPOStatuses)
+			},
+		},
+		{
+			name: "reorder_rules",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.ReorderRule{}).Where("supplier_id = ?", id)
+			},
+			// Rules keep watching stock but stop raising purchase orders
+			cascade: func(tx *gorm.DB, id uint) error {
+				return tx.Model(&models.ReorderRule{}).Where("supplier_id = ?", id).Update("supplier_id", nil).Error
+			},
+		},
+	},
+	TrashKindWarehouse: {
+		{
+			name:     "stocked_inventory_items",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.InventoryItem{}).Where("warehouse_id = ? AND (quantity > 0 OR reserved > 0)", id)
+			},
+		},
+		{
+			name:     "open_transfers",
+			blocking: true,
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.StockTransfer{}).
+					Where("status IN ?", openTransferStatuses).
+					Where("source_warehouse_id = ? OR destination_warehouse_id = ?", id, id)
+			},
+		},
+		{
+			name: "empty_inventory_items",
+			query: func(db *gorm.DB, id uint) *gorm.DB {
+				return db.Model(&models.InventoryItem{}).Where("warehouse_id = ? AND quantity = 0 AND reserved = 0", id)
+			},
+			cascade: func(tx *gorm.DB, id uint) error {
+				return tx.Where("warehouse_id = ? AND quantity = 0 AND reserved = 0", id).Delete(&models.InventoryItem{}).Error
+			},
+		},
+	},
+}
+
+// trashModel returns an empty model for kind
+func trashModel(kind TrashKind) (interface{}, error) {
+	switch kind {
+	case TrashKindProduct:
+		return &models.Product{}, nil
+	case TrashKindCategory:
+		return &models.Category{}, nil
+	case TrashKindSupplier:
+		return &models.Supplier{}, nil
+	case TrashKindWarehouse:
+		return &models.Warehouse{}, nil
+	}
+	return nil, ErrUnknownTrashKind
+}
+
+// Dependents reports the records that depend on the given record
+func Dependents(db *gorm.DB, kind TrashKind, id uint) ([]Dependent, error) {
+	rules, ok := dependentRules[kind]
+	if !ok {
+		return nil, ErrUnknownTrashKind
+	}
+
+	dependents := []Dependent{}
+	for _, rule := range rules {
+		var count int64
+		if err := rule.query(db, id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+		var ids []uint
+		if err := rule.query(db, id).Order("id").Limit(dependentSampleSize).Pluck("id", &ids).Error; err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, Dependent{
+			Type:     rule.name,
+			Count:    count,
+			IDs:      ids,
+			Blocking: rule.blocking,
+		})
+	}
+	return dependents, nil
+}
+
+// Trash soft-deletes a record. With DeletePolicyBlock any dependent stops
+// the delete; with DeletePolicyCascade only blocking dependents do and the
+// rest are detached first. The dependents found are always returned.
+func Trash(tx *gorm.DB, kind TrashKind, id uint, policy DeletePolicy) ([]Dependent, error) {
+	model, err := trashModel(kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.First(model, id).Error; err != nil {
+		return nil, err
+	}
+
+	dependents, err := Dependents(tx, kind, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, dependent := range dependents {
+		if dependent.Blocking || policy != DeletePolicyCascade {
+			return dependents, ErrHasDependents
+		}
+	}
+
+	if len(dependents) > 0 {
+		for _, rule := range dependentRules[kind] {
+			if rule.cascade == nil {
+				continue
+			}
+			if err := rule.cascade(tx, id); err != nil {
+				return dependents, err
+			}
+		}
+	}
+
+	if err := tx.Delete(model).Error; err != nil {
+		return dependents, err
+	}
+	if kind == TrashKindProduct {
+		if err := IndexProduct(tx, id); err != nil {
+			return dependents, err
+		}
+	}
+	return dependents, nil
+}
+
+// Restore brings a record back out of the trash
+func Restore(tx *gorm.DB, kind TrashKind, id uint) error {
+	model, err := trashModel(kind)
+	if err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(model, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotInTrash
+		}
+		return err
+	}
+
+	if category, ok := model.(*models.Category); ok && category.ParentID != nil {
+		var parents int64
+		if err := tx.Model(&models.Category{}).Where("id = ?", *category.ParentID).Count(&parents).Error; err != nil {
+			return err
+		}
+		if parents == 0 {
+			return ErrParentInTrash
+		}
+	}
+
+	if err := tx.Unscoped().Model(model).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	if kind == TrashKindProduct {
+		return IndexProduct(tx, id)
+	}
+	return nil
+}
+
+// purgeReferences are rows, deleted or not, that keep a record from being
+// removed permanently because they are part of the trading history
+var purgeReferences = map[TrashKind][]dependentRule{
+	TrashKindProduct: {
+		{name: "order_items", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.OrderItem{}).Where("product_id = ?", id)
+		}},
+		{name: "order_item_components", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.OrderItemComponent{}).Where("product_id = ?", id)
+		}},
+		{name: "po_items", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.POItem{}).Where("product_id = ?", id)
+		}},
+		{name: "contract_items", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.ContractItem{}).Where("product_id = ?", id)
+		}},
+		{name: "reviews", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.Review{}).Where("product_id = ?", id)
+		}},
+		{name: "inventory_items", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.InventoryItem{}).Where("product_id = ? AND (quantity > 0 OR reserved > 0)", id)
+		}},
+		// The ledger is append-only, so items with movements stay
+		{name: "inventory_movements", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Model(&models.InventoryMovement{}).Where("product_id = ?", id)
+		}},
+		{name: "stock_transfer_lines", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.StockTransferLine{}).Where("product_id = ?", id)
+		}},
+		{name: "stock_count_lines", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.StockCountLine{}).Where("product_id = ?", id)
+		}},
+	},
+	TrashKindCategory: {
+		{name: "child_categories", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.Category{}).Where("parent_id = ?", id)
+		}},
+	},
+	TrashKindSupplier: {
+		{name: "purchase_orders", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.PurchaseOrder{}).Where("supplier_id = ?", id)
+		}},
+	},
+	TrashKindWarehouse: {
+		{name: "inventory_items", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.InventoryItem{}).Where("warehouse_id = ?", id)
+		}},
+		{name: "stock_transfers", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.StockTransfer{}).Where("source_warehouse_id = ? OR destination_warehouse_id = ?", id, id)
+		}},
+		{name: "stock_counts", query: func(db *gorm.DB, id uint) *gorm.DB {
+			return db.Unscoped().Model(&models.StockCount{}).Where("warehouse_id = ?", id)
+		}},
+	},
+}
+
+// Purge permanently deletes a trashed record and the rows it owns. Records
+// still referenced by orders, purchase orders or contracts are kept.
+func Purge(tx *gorm.DB, kind TrashKind, id uint) ([]Dependent, error) {
+	model, err := trashModel(kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(model, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotInTrash
+		}
+		return nil, err
+	}
+
+	var references []Dependent
+	for _, rule := range purgeReferences[kind] {
+		var count int64
+		if err := rule.query(tx, id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			references = append(references, Dependent{Type: rule.name, Count: count, Blocking: true})
+		}
+	}
+	if len(references) > 0 {
+		return references, ErrStillReferenced
+	}
+
+	if err := purgeOwned(tx, kind, id); err != nil {
+		return nil, err
+	}
+	return nil, tx.Unscoped().Delete(model).Error
+}
+
+// ownedRows are deleted along with the record that owns them. Models are
+// deleted unscoped; a nil model runs where as a raw statement.
+type ownedRows struct {
+	model interface{}
+	where string
+}
+
+var purgeOwnedRows = map[TrashKind][]ownedRows{
+	TrashKindProduct: {
+		{nil, `DELETE FROM product_specification_translations WHERE specification_id IN
+			(SELECT id FROM product_specifications WHERE product_id = @id)`},
+		{&models.ProductSpecification{}, "product_id = @id"},
+		{&models.ProductImage{}, "product_id = @id"},
+		{&models.ProductTranslation{}, "product_id = @id"},
+		{&models.ProductPrice{}, "product_id = @id"},
+		{&models.PriceHistory{}, "product_id = @id"},
+		{&models.ScheduledPriceChange{}, "product_id = @id"},
+		{&models.ProductSearchDocument{}, "product_id = @id"},
+		{&models.ProductRelation{}, "product_id = @id OR related_product_id = @id"},
+		{&models.ProductAffinity{}, "product_id = @id OR related_product_id = @id"},
+		{&models.ProductOrderStat{}, "product_id = @id"},
+		{&models.BundleComponent{}, "bundle_id = @id OR component_id = @id"},
+		{&models.StockAlert{}, "product_id = @id"},
+		{&models.ReorderRule{}, "product_id = @id"},
+		{&models.InventoryItem{}, "product_id = @id"},
+		{nil, "DELETE FROM product_categories WHERE product_id = @id"},
+	},
+	TrashKindCategory: {
+		{&models.CategoryTranslation{}, "category_id = @id"},
+		{nil, "DELETE FROM product_categories WHERE category_id = @id"},
+		{nil, "DELETE FROM stock_count_categories WHERE category_id = @id"},
+	},
+	TrashKindSupplier: {
+		{&models.SupplierContact{}, "supplier_id = @id"},
+	},
+	TrashKindWarehouse: {
+		{&models.StockAlert{}, "warehouse_id = @id"},
+		{&models.ReorderRule{}, "warehouse_id = @id"},
+	},
+}
+
+func purgeOwned(tx *gorm.DB, kind TrashKind, id uint) error {
+	args := map[string]interface{}{"id": id}
+	for _, rows := range purgeOwnedRows[kind] {
+		var err error
+		if rows.model == nil {
+			err = tx.Exec(rows.where, args).Error
+		} else {
+			err = tx.Unscoped().Where(rows.where, args).Delete(rows.model).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package barcode
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidLength     = errors.New("barcode must be 8, 12, 13 or 14 digits long")
+	ErrInvalidCharacters = errors.New("barcode must contain digits only")
+	ErrInvalidCheckDigit = errors.New("barcode check digit is invalid")
+)
+
+// Normalize strips the spaces and dashes scanners and spreadsheets tend to add
+func Normalize(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+}
+
+// ValidateGTIN checks a GTIN-8, UPC-A (GTIN-12), EAN-13 or GTIN-14 code
+// including its check digit
+func ValidateGTIN(code string) error {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return ErrInvalidLength
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return ErrInvalidCharacters
+		}
+	}
+
+	if CheckDigit(code[:len(code)-1]) != code[len(code)-1] {
+		return ErrInvalidCheckDigit
+	}
+
+	return nil
+}
+
+// CheckDigit computes the GS1 mod-10 check digit for the given payload
+// (the code without its last digit)
+func CheckDigit(payload string) byte {
+	sum := 0
+	for i := 0; i < len(payload); i++ {
+		digit := int(payload[len(payload)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,103 @@
+package barcode
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page geometry in millimetres
+const (
+	pageWidth  = 210.0
+	pageHeight = 297.0
+	pageMargin = 8.0
+)
+
+type Label struct {
+	Title     string
+	Subtitle  string
+	Price     string
+	Content   string
+	Symbology Symbology
+}
+
+type SheetLayout struct {
+	Columns int
+	Rows    int
+}
+
+// WriteLabelSheet renders labels onto printable A4 pages as a single SVG
+// document, one <g> group per page
+func WriteLabelSheet(w io.Writer, labels []Label, layout SheetLayout) error {
+	if layout.Columns <= 0 || layout.Rows <= 0 {
+		return fmt.Errorf("label sheet needs at least one row and one column")
+	}
+
+	perPage := layout.Columns * layout.Rows
+	pages := (len(labels) + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+
+	cellWidth := (pageWidth - 2*pageMargin) / float64(layout.Columns)
+	cellHeight := (pageHeight - 2*pageMargin) / float64(layout.Rows)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g" font-family="Helvetica, Arial, sans-serif">`,
+		pageWidth, pageHeight*float64(pages), pageWidth, pageHeight*float64(pages))
+
+	for i, label := range labels {
+		page := i / perPage
+		slot := i % perPage
+		x := pageMargin + float64(slot%layout.Columns)*cellWidth
+		y := float64(page)*pageHeight + pageMargin + float64(slot/layout.Columns)*cellHeight
+
+		if err := writeLabel(&b, label, x, y, cellWidth, cellHeight); err != nil {
+			return fmt.Errorf("label %d: %w", i+1, err)
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeLabel(b *strings.Builder, label Label, x, y, width, height float64) error {
+	code, err := Encode(label.Content, label.Symbology)
+	if err != nil {
+		return err
+	}
+
+	padding := 2.0
+	textSize := height / 10
+
+	fmt.Fprintf(b, `<g><rect x="%g" y="%g" width="%g" height="%g" fill="none" stroke="#ccc" stroke-width="0.2"/>`,
+		x, y, width, height)
+	fmt.Fprintf(b, `<text x="%g" y="%g" font-size="%g" font-weight="bold">%s</text>`,
+		x+padding, y+padding+textSize, textSize, escape(label.Title))
+	fmt.Fprintf(b, `<text x="%g" y="%g" font-size="%g">%s</text>`,
+		x+padding, y+padding+2.2*textSize, textSize*0.8, escape(label.Subtitle))
+	if label.Price != "" {
+		fmt.Fprintf(b, `<text x="%g" y="%g" font-size="%g" text-anchor="end" font-weight="bold">%s</text>`,
+			x+width-padding, y+padding+textSize, textSize, escape(label.Price))
+	}
+
+	codeTop := y + padding + 3*textSize
+	codeHeight := height - (codeTop - y) - padding
+	codeWidth := width - 2*padding
+	if label.Symbology == SymbologyQR {
+		codeWidth = codeHeight
+	}
+	b.WriteString(SVGElement(code, x+padding, codeTop, codeWidth, codeHeight))
+	b.WriteString(`</g>`)
+
+	return nil
+}
+
+func escape(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	boombuler "github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+)
+
+type Symbology string
+
+const (
+	SymbologyQR      Symbology = "qr"
+	SymbologyCode128 Symbology = "code128"
+	SymbologyEAN     Symbology = "ean"
+)
+
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+var (
+	ErrUnsupportedSymbology = errors.New("unsupported symbology")
+	ErrUnsupportedFormat    = errors.New("unsupported image format")
+)
+
+// ContentType returns the MIME type for the image format
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// ParseFormat validates a format name, defaulting to PNG
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case "", FormatPNG:
+		return FormatPNG, nil
+	case FormatSVG:
+		return FormatSVG, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Encode builds the unscaled symbol for content. UPC-A codes are encoded as
+// EAN-13 with a leading zero, which is how retail scanners read them.
+func Encode(content string, symbology Symbology) (boombuler.Barcode, error) {
+	switch symbology {
+	case SymbologyQR:
+		return qr.Encode(content, qr.M, qr.Auto)
+	case SymbologyCode128:
+		return code128.Encode(content)
+	case SymbologyEAN:
+		if err := ValidateGTIN(content); err != nil {
+			return nil, err
+		}
+		switch len(content) {
+		case 12:
+			return ean.Encode("0" + content)
+		case 8, 13:
+			return ean.Encode(content)
+		}
+		return nil, fmt.Errorf("%w: GTIN-14 cannot be drawn as EAN", ErrUnsupportedSymbology)
+	}
+	return nil, ErrUnsupportedSymbology
+}
+
+// Write renders the symbol at the requested size in the given format
+func Write(w io.Writer, code boombuler.Barcode, format Format, width, height int) error {
+	switch format {
+	case FormatPNG:
+		scaled, err := boombuler.Scale(code, width, height)
+		if err != nil {
+			return err
+		}
+		return png.Encode(w, scaled)
+	case FormatSVG:
+		_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n%s\n",
+			SVGElement(code, 0, 0, float64(width), float64(height)))
+		return err
+	}
+	return ErrUnsupportedFormat
+}
+
+// SVGElement draws the symbol as a standalone <svg> element positioned at
+// x,y. Dark modules are merged into horizontal runs to keep the output small.
+func SVGElement(code boombuler.Barcode, x, y, width, height float64) string {
+	bounds := code.Bounds()
+	var b strings.Builder
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" x="%g" y="%g" width="%g" height="%g" viewBox="0 0 %d %d" preserveAspectRatio="none" shape-rendering="crispEdges">`,
+		x, y, width, height, bounds.Dx(), bounds.Dy())
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`, bounds.Dx(), bounds.Dy())
+
+	for row := bounds.Min.Y; row < bounds.Max.Y; row++ {
+		start := -1
+		for col := bounds.Min.X; col <= bounds.Max.X; col++ {
+			dark := col < bounds.Max.X && isDark(code, col, row)
+			if dark && start < 0 {
+				start = col
+			}
+			if !dark && start >= 0 {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="1"/>`,
+					start-bounds.Min.X, row-bounds.Min.Y, col-start)
+				start = -1
+			}
+		}
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func isDark(img image.Image, x, y int) bool {
+	gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+	return gray.Y < 128
+}
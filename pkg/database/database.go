@@ -35,8 +35,13 @@ func InitDB(cfg *config.Config) error {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
+	return Migrate(DB)
+}
+
+// Migrate brings the schema of db up to date with the models
+func Migrate(db *gorm.DB) error {
 	// Auto migrate all models
-	err = DB.AutoMigrate(
+	err := db.AutoMigrate(
 		&models.Company{},
 		&models.User{},
 		&models.Address{},
@@ -58,11 +63,81 @@ func InitDB(cfg *config.Config) error {
 		&models.ContractItem{},
 		&models.ContractSchedule{},
 		&models.ContractOrder{},
+		&models.PriceHistory{},
+		&models.ScheduledPriceChange{},
+		&models.Review{},
+		&models.ReviewImage{},
+		&models.ReviewVote{},
+		&models.Currency{},
+		&models.ExchangeRate{},
+		&models.ProductPrice{},
+		&models.ProductTranslation{},
+		&models.CategoryTranslation{},
+		&models.ProductSpecificationTranslation{},
+		&models.ProductSearchDocument{},
+		&models.BundleComponent{},
+		&models.OrderItemComponent{},
+		&models.ProductRelation{},
+		&models.ProductAffinity{},
+		&models.ProductOrderStat{},
+		&models.JobCheckpoint{},
+		&models.InventoryAdjustment{},
+		&models.InventoryMovement{},
+		&models.OrderItemAllocation{},
+		&models.ReorderRule{},
+		&models.StockAlert{},
+		&models.StockTransfer{},
+		&models.StockTransferLine{},
+		&models.StockTransferDiscrepancy{},
+		&models.StockTransferStatusChange{},
+		&models.StockCount{},
+		&models.StockCountLine{},
+		&models.OrderStatusHistory{},
+		&models.Notification{},
+		&models.NumberSequence{},
+		&models.TaxClass{},
+		&models.TaxRate{},
+		&models.ShippingZone{},
+		&models.ShippingZoneLocation{},
+		&models.ShippingMethod{},
+		&models.ShippingRate{},
+		&models.Promotion{},
+		&models.Coupon{},
+		&models.PromotionRedemption{},
+		&models.OrderItemDiscount{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.CartCoupon{},
+		&models.PriceList{},
+		&models.PriceListItem{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to auto migrate database: %v", err)
 	}
 
+	// The stock ledger is append-only, also for writes that bypass the models
+	if err := db.Exec(`CREATE OR REPLACE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'inventory movements are append-only';
+		END;
+		$$ LANGUAGE plpgsql`).Error; err != nil {
+		return fmt.Errorf("failed to create ledger trigger function: %v", err)
+	}
+	if err := db.Exec(`DROP TRIGGER IF EXISTS inventory_movements_append_only ON inventory_movements`).Error; err != nil {
+		return fmt.Errorf("failed to drop ledger trigger: %v", err)
+	}
+	if err := db.Exec(`CREATE TRIGGER inventory_movements_append_only
+		BEFORE UPDATE OR DELETE ON inventory_movements
+		FOR EACH ROW EXECUTE FUNCTION inventory_movements_append_only()`).Error; err != nil {
+		return fmt.Errorf("failed to create ledger trigger: %v", err)
+	}
+
+	// A user has at most one active cart
+	if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_carts_active_user ON carts (user_id)
+		WHERE status = 'ACTIVE' AND user_id IS NOT NULL AND deleted_at IS NULL`).Error; err != nil {
+		return fmt.Errorf("failed to create cart index: %v", err)
+	}
+
 	return nil
 }
 
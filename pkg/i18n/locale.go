@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Normalize canonicalises a language tag, e.g. "fr_dz" becomes "fr-DZ"
+func Normalize(tag string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"), "-")
+	if len(parts) == 0 || parts[0] == "" {
+		return ""
+	}
+	parts[0] = strings.ToLower(parts[0])
+	if len(parts) > 1 {
+		parts[1] = strings.ToUpper(parts[1])
+	}
+	return strings.Join(parts[:min(len(parts), 2)], "-")
+}
+
+// ParseAcceptLanguage returns the tags of an Accept-Language header in
+// order of preference. Wildcards and tags with q=0 are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := Normalize(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// FallbackChain expands the requested tags into the supported locales to
+// try in order: each tag, then its bare language, then the default locale.
+func FallbackChain(requested, supported []string, defaultLocale string) []string {
+	allowed := make(map[string]bool, len(supported))
+	for _, locale := range supported {
+		allowed[Normalize(locale)] = true
+	}
+
+	var chain []string
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		if allowed[locale] && !seen[locale] {
+			seen[locale] = true
+			chain = append(chain, locale)
+		}
+	}
+
+	for _, tag := range requested {
+		tag = Normalize(tag)
+		add(tag)
+		if language, _, found := strings.Cut(tag, "-"); found {
+			add(language)
+		}
+	}
+	if locale := Normalize(defaultLocale); !seen[locale] {
+		chain = append(chain, locale)
+	}
+
+	return chain
+}
@@ -0,0 +1,95 @@
+package units
+
+import (
+	"errors"
+	"strings"
+)
+
+type Dimension string
+
+const (
+	DimensionMass   Dimension = "mass"
+	DimensionLength Dimension = "length"
+)
+
+var (
+	ErrUnknownUnit       = errors.New("unknown unit")
+	ErrIncompatibleUnits = errors.New("units measure different dimensions")
+)
+
+type Unit struct {
+	Symbol    string
+	Dimension Dimension
+	// Factor converts one of this unit into the dimension's canonical unit
+	Factor float64
+}
+
+// Canonical units every measurement is stored in
+var canonical = map[Dimension]string{
+	DimensionMass:   "g",
+	DimensionLength: "mm",
+}
+
+var known = map[string]Unit{
+	"mg": {"mg", DimensionMass, 0.001},
+	"g":  {"g", DimensionMass, 1},
+	"kg": {"kg", DimensionMass, 1000},
+	"oz": {"oz", DimensionMass, 28.349523125},
+	"lb": {"lb", DimensionMass, 453.59237},
+	"mm": {"mm", DimensionLength, 1},
+	"cm": {"cm", DimensionLength, 10},
+	"m":  {"m", DimensionLength, 1000},
+	"in": {"in", DimensionLength, 25.4},
+	"ft": {"ft", DimensionLength, 304.8},
+}
+
+var aliases = map[string]string{
+	"gram": "g", "grams": "g", "gr": "g",
+	"kilogram": "kg", "kilograms": "kg", "kgs": "kg",
+	"milligram": "mg", "milligrams": "mg",
+	"ounce": "oz", "ounces": "oz",
+	"pound": "lb", "pounds": "lb", "lbs": "lb",
+	"millimetre": "mm", "millimeter": "mm", "millimetres": "mm", "millimeters": "mm",
+	"centimetre": "cm", "centimeter": "cm", "centimetres": "cm", "centimeters": "cm",
+	"metre": "m", "meter": "m", "metres": "m", "meters": "m",
+	"inch": "in", "inches": "in", "\"": "in",
+	"foot": "ft", "feet": "ft", "'": "ft",
+}
+
+// Lookup resolves a unit symbol or common spelling
+func Lookup(symbol string) (Unit, error) {
+	key := strings.ToLower(strings.TrimSpace(symbol))
+	if alias, ok := aliases[key]; ok {
+		key = alias
+	}
+	unit, ok := known[key]
+	if !ok {
+		return Unit{}, ErrUnknownUnit
+	}
+	return unit, nil
+}
+
+// ToCanonical converts value into the canonical unit of its dimension
+func ToCanonical(value float64, symbol string) (float64, string, error) {
+	unit, err := Lookup(symbol)
+	if err != nil {
+		return 0, "", err
+	}
+	return value * unit.Factor, canonical[unit.Dimension], nil
+}
+
+// Convert converts value between two units of the same dimension
+func Convert(value float64, from, to string) (float64, error) {
+	fromUnit, err := Lookup(from)
+	if err != nil {
+		return 0, err
+	}
+	toUnit, err := Lookup(to)
+	if err != nil {
+		return 0, err
+	}
+	if fromUnit.Dimension != toUnit.Dimension {
+		return 0, ErrIncompatibleUnits
+	}
+	return value * fromUnit.Factor / toUnit.Factor, nil
+}